@@ -0,0 +1,298 @@
+package gomail
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"net/smtp"
+	"strconv"
+	"strings"
+)
+
+// authMechanisms are the mechanisms supported by NegotiateAuth, from the
+// strongest to the weakest.
+var authMechanisms = []string{"SCRAM-SHA-256", "SCRAM-SHA-1", "CRAM-MD5", "PLAIN", "LOGIN"}
+
+// A sessionAuth is an Auth keeping state between Start and Next. session
+// returns a copy with its own state, so that the connections of a Mailer or a
+// Pool sharing an Auth do not share that state.
+type sessionAuth interface {
+	smtp.Auth
+	session() smtp.Auth
+}
+
+// newSession returns the Auth to use on a new connection.
+func newSession(a smtp.Auth) smtp.Auth {
+	if s, ok := a.(sessionAuth); ok {
+		return s.session()
+	}
+
+	return a
+}
+
+type negotiateAuth struct {
+	username string
+	password string
+	host     string
+	auth     smtp.Auth
+}
+
+// NegotiateAuth returns an Auth that uses the strongest mechanism advertised
+// by the SMTP server among SCRAM-SHA-256, SCRAM-SHA-1, CRAM-MD5, PLAIN and
+// LOGIN. Like smtp.PlainAuth, it only sends the password in clear text, with
+// PLAIN or LOGIN, if the connection uses TLS or is to localhost. See
+// AllowUnencryptedAuth to lift this restriction.
+//
+// The Auth can be shared by the connections of a Mailer or a Pool, each of
+// them authenticating with its own copy. Used directly with an smtp.Client, it
+// must not be shared by concurrent connections.
+//
+// Example:
+//
+//	mailer := gomail.NewCustomMailer("host:587", gomail.NegotiateAuth("user", "pwd", "host"))
+func NegotiateAuth(username, password, host string) smtp.Auth {
+	return &negotiateAuth{username: username, password: password, host: host}
+}
+
+func (a *negotiateAuth) session() smtp.Auth {
+	return &negotiateAuth{username: a.username, password: a.password, host: a.host}
+}
+
+func (a *negotiateAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if server.Name != a.host {
+		return "", nil, errors.New("gomail: wrong host name")
+	}
+
+	mechanism := ""
+	for _, m := range authMechanisms {
+		if hasAuthMechanism(server, m) {
+			mechanism = m
+			break
+		}
+	}
+	switch mechanism {
+	case "SCRAM-SHA-256":
+		a.auth = ScramSHA256Auth(a.username, a.password)
+	case "SCRAM-SHA-1":
+		a.auth = ScramSHA1Auth(a.username, a.password)
+	case "CRAM-MD5":
+		a.auth = smtp.CRAMMD5Auth(a.username, a.password)
+	case "PLAIN", "LOGIN":
+		if !server.TLS && !isLocalhost(server.Name) {
+			return "", nil, errors.New("gomail: unencrypted connection")
+		}
+		if mechanism == "PLAIN" {
+			a.auth = smtp.PlainAuth("", a.username, a.password, a.host)
+		} else {
+			a.auth = LoginAuth(a.username, a.password, a.host)
+		}
+	default:
+		return "", nil, fmt.Errorf("gomail: no supported authentication mechanism among %q", server.Auth)
+	}
+
+	return a.auth.Start(server)
+}
+
+func (a *negotiateAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	return a.auth.Next(fromServer, more)
+}
+
+func hasAuthMechanism(server *smtp.ServerInfo, mechanism string) bool {
+	for _, m := range server.Auth {
+		if strings.EqualFold(m, mechanism) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
+
+type unencryptedAuth struct {
+	smtp.Auth
+}
+
+// AllowUnencryptedAuth returns an Auth that behaves like a but that is told the
+// connection is encrypted, so that PLAIN or LOGIN can be used on a connection
+// without TLS. The password is then sent in clear text: it should only be used
+// on trusted networks.
+//
+// Example:
+//
+//	auth := gomail.AllowUnencryptedAuth(smtp.PlainAuth("", "user", "pwd", "host"))
+func AllowUnencryptedAuth(a smtp.Auth) smtp.Auth {
+	return unencryptedAuth{a}
+}
+
+func (a unencryptedAuth) session() smtp.Auth {
+	return unencryptedAuth{newSession(a.Auth)}
+}
+
+func (a unencryptedAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	s := *server
+	s.TLS = true
+	return a.Auth.Start(&s)
+}
+
+type scramAuth struct {
+	mechanism string
+	newHash   func() hash.Hash
+	username  string
+	password  string
+
+	// clientFirst is the client-first-message without the GS2 header.
+	clientFirst string
+	nonce       string
+	// serverSignature is the expected server signature, once the client proof
+	// is sent.
+	serverSignature []byte
+	// verified is true once the server signature is checked.
+	verified bool
+}
+
+// ScramSHA1Auth returns an Auth that implements the SCRAM-SHA-1 authentication
+// mechanism as defined in RFC 5802, without channel binding.
+func ScramSHA1Auth(username, password string) smtp.Auth {
+	return &scramAuth{mechanism: "SCRAM-SHA-1", newHash: sha1.New, username: username, password: password}
+}
+
+// ScramSHA256Auth returns an Auth that implements the SCRAM-SHA-256
+// authentication mechanism as defined in RFC 7677, without channel binding.
+func ScramSHA256Auth(username, password string) smtp.Auth {
+	return &scramAuth{mechanism: "SCRAM-SHA-256", newHash: sha256.New, username: username, password: password}
+}
+
+// scramNonce returns a random client nonce.
+var scramNonce = func() (string, error) {
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawStdEncoding.EncodeToString(b), nil
+}
+
+// scramGS2Header is the GS2 header of a client not supporting channel binding.
+const scramGS2Header = "n,,"
+
+func (a *scramAuth) session() smtp.Auth {
+	return &scramAuth{mechanism: a.mechanism, newHash: a.newHash, username: a.username, password: a.password}
+}
+
+func (a *scramAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	nonce, err := scramNonce()
+	if err != nil {
+		return "", nil, err
+	}
+	a.nonce = nonce
+	a.serverSignature = nil
+	a.verified = false
+	username := strings.NewReplacer("=", "=3D", ",", "=2C").Replace(a.username)
+	a.clientFirst = "n=" + username + ",r=" + nonce
+
+	return a.mechanism, []byte(scramGS2Header + a.clientFirst), nil
+}
+
+func (a *scramAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		// The server must prove it knows the password before the
+		// authentication succeeds.
+		if !a.verified {
+			return nil, fmt.Errorf("gomail: missing %s server signature", a.mechanism)
+		}
+		return nil, nil
+	}
+	if a.serverSignature == nil {
+		return a.clientFinal(string(fromServer))
+	}
+
+	attrs := scramAttributes(string(fromServer))
+	if e, ok := attrs["e"]; ok {
+		return nil, fmt.Errorf("gomail: %s authentication failed: %s", a.mechanism, e)
+	}
+	v, err := base64.StdEncoding.DecodeString(attrs["v"])
+	if err != nil || !hmac.Equal(v, a.serverSignature) {
+		return nil, fmt.Errorf("gomail: invalid %s server signature", a.mechanism)
+	}
+	a.verified = true
+
+	return []byte{}, nil
+}
+
+// clientFinal returns the client-final-message answering serverFirst.
+func (a *scramAuth) clientFinal(serverFirst string) ([]byte, error) {
+	attrs := scramAttributes(serverFirst)
+	nonce := attrs["r"]
+	if len(nonce) <= len(a.nonce) || !strings.HasPrefix(nonce, a.nonce) {
+		return nil, fmt.Errorf("gomail: invalid %s server nonce", a.mechanism)
+	}
+	salt, err := base64.StdEncoding.DecodeString(attrs["s"])
+	if err != nil || len(salt) == 0 {
+		return nil, fmt.Errorf("gomail: invalid %s salt", a.mechanism)
+	}
+	iterations, err := strconv.Atoi(attrs["i"])
+	if err != nil || iterations < 1 {
+		return nil, fmt.Errorf("gomail: invalid %s iteration count", a.mechanism)
+	}
+
+	saltedPassword := a.hi([]byte(a.password), salt, iterations)
+	clientKey := a.hmac(saltedPassword, "Client Key")
+	h := a.newHash()
+	h.Write(clientKey)
+	storedKey := h.Sum(nil)
+
+	channelBinding := base64.StdEncoding.EncodeToString([]byte(scramGS2Header))
+	withoutProof := "c=" + channelBinding + ",r=" + nonce
+	authMessage := a.clientFirst + "," + serverFirst + "," + withoutProof
+
+	proof := a.hmac(storedKey, authMessage)
+	subtle.XORBytes(proof, proof, clientKey)
+	a.serverSignature = a.hmac(a.hmac(saltedPassword, "Server Key"), authMessage)
+
+	return []byte(withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof)), nil
+}
+
+func (a *scramAuth) hmac(key []byte, s string) []byte {
+	mac := hmac.New(a.newHash, key)
+	mac.Write([]byte(s))
+	return mac.Sum(nil)
+}
+
+// hi is the PBKDF2 function with a single block, as defined in RFC 5802.
+func (a *scramAuth) hi(password, salt []byte, iterations int) []byte {
+	mac := hmac.New(a.newHash, password)
+	mac.Write(salt)
+	mac.Write(binary.BigEndian.AppendUint32(nil, 1))
+	u := mac.Sum(nil)
+	result := append([]byte(nil), u...)
+	for i := 1; i < iterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		subtle.XORBytes(result, result, u)
+	}
+
+	return result
+}
+
+// scramAttributes parses the comma-separated attributes of a SCRAM message.
+func scramAttributes(s string) map[string]string {
+	attrs := make(map[string]string)
+	for _, attr := range strings.Split(s, ",") {
+		if len(attr) >= 2 && attr[1] == '=' {
+			attrs[attr[:1]] = attr[2:]
+		}
+	}
+
+	return attrs
+}
@@ -0,0 +1,291 @@
+package gomail
+
+import (
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"sync"
+	"testing"
+)
+
+func stubScramNonce(nonce string) func() {
+	original := scramNonce
+	scramNonce = func() (string, error) { return nonce, nil }
+	return func() {
+		scramNonce = original
+	}
+}
+
+func TestScramAuth(t *testing.T) {
+	tests := []struct {
+		auth                     smtp.Auth
+		proto, nonce             string
+		serverFirst, clientFinal string
+		serverFinal              string
+	}{
+		// Examples from RFC 5802 and RFC 7677.
+		{
+			auth:        ScramSHA1Auth("user", "pencil"),
+			proto:       "SCRAM-SHA-1",
+			nonce:       "fyko+d2lbbFgONRv9qkxdawL",
+			serverFirst: "r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,s=QSXCR+Q6sek8bf92,i=4096",
+			clientFinal: "c=biws,r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,p=v0X8v3Bz2T0CJGbJQyF0X+HI4Ts=",
+			serverFinal: "v=rmF9pqV8S7suAoZWja4dJRkFsKQ=",
+		},
+		{
+			auth:        ScramSHA256Auth("user", "pencil"),
+			proto:       "SCRAM-SHA-256",
+			nonce:       "rOprNGfwEbeRWgbNEkqO",
+			serverFirst: "r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096",
+			clientFinal: "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=",
+			serverFinal: "v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=",
+		},
+	}
+
+	for _, test := range tests {
+		restore := stubScramNonce(test.nonce)
+		proto, toServer, err := test.auth.Start(&smtp.ServerInfo{Name: testHost})
+		restore()
+		if err != nil {
+			t.Fatalf("Start error: %v", err)
+		}
+		if proto != test.proto {
+			t.Errorf("Invalid protocol, got %q, want %q", proto, test.proto)
+		}
+		if got, want := string(toServer), "n,,n=user,r="+test.nonce; got != want {
+			t.Errorf("Invalid client first message, got %q, want %q", got, want)
+		}
+
+		toServer, err = test.auth.Next([]byte(test.serverFirst), true)
+		if err != nil {
+			t.Fatalf("Auth error: %v", err)
+		}
+		if got := string(toServer); got != test.clientFinal {
+			t.Errorf("Invalid client final message, got %q, want %q", got, test.clientFinal)
+		}
+
+		toServer, err = test.auth.Next([]byte(test.serverFinal), true)
+		if err != nil {
+			t.Fatalf("Auth error: %v", err)
+		}
+		if len(toServer) != 0 {
+			t.Errorf("Invalid response to the server final message, got %q", toServer)
+		}
+	}
+}
+
+func TestScramAuthErrors(t *testing.T) {
+	defer stubScramNonce("abc")()
+
+	tests := []struct {
+		serverFirst, serverFinal string
+		success                  bool
+	}{
+		{serverFirst: "r=xyz123,s=QSXCR+Q6sek8bf92,i=4096"},
+		{serverFirst: "r=abc,s=QSXCR+Q6sek8bf92,i=4096"},
+		{serverFirst: "r=abc123,s=,i=4096"},
+		{serverFirst: "r=abc123,s=QSXCR+Q6sek8bf92,i=0"},
+		{serverFirst: "r=abc123,s=QSXCR+Q6sek8bf92,i=1", serverFinal: "v=rmF9pqV8S7suAoZWja4dJRkFsKQ="},
+		{serverFirst: "r=abc123,s=QSXCR+Q6sek8bf92,i=1", serverFinal: "e=invalid-proof"},
+		{serverFirst: "r=abc123,s=QSXCR+Q6sek8bf92,i=1", success: true},
+	}
+
+	for _, test := range tests {
+		auth := ScramSHA1Auth("user", "pencil")
+		if _, _, err := auth.Start(&smtp.ServerInfo{Name: testHost}); err != nil {
+			t.Fatalf("Start error: %v", err)
+		}
+		_, err := auth.Next([]byte(test.serverFirst), true)
+		if test.serverFinal != "" {
+			if err != nil {
+				t.Fatalf("Auth error: %v", err)
+			}
+			_, err = auth.Next([]byte(test.serverFinal), true)
+		}
+		if test.success {
+			if err != nil {
+				t.Fatalf("Auth error: %v", err)
+			}
+			// The server accepts the authentication without its signature.
+			_, err = auth.Next([]byte("2.7.0 Authentication successful"), false)
+		}
+		if err == nil {
+			t.Errorf("Server messages %q and %q should be rejected", test.serverFirst, test.serverFinal)
+		}
+	}
+}
+
+func TestNegotiateAuth(t *testing.T) {
+	defer stubScramNonce("abc")()
+
+	tests := []struct {
+		name       string
+		tls        bool
+		mechanisms []string
+		proto      string
+	}{
+		{testHost, true, []string{"LOGIN", "PLAIN", "CRAM-MD5", "SCRAM-SHA-1", "SCRAM-SHA-256"}, "SCRAM-SHA-256"},
+		{testHost, false, []string{"PLAIN", "scram-sha-1"}, "SCRAM-SHA-1"},
+		{testHost, false, []string{"LOGIN", "PLAIN", "CRAM-MD5"}, "CRAM-MD5"},
+		{testHost, true, []string{"LOGIN", "PLAIN"}, "PLAIN"},
+		{testHost, true, []string{"LOGIN", "XOAUTH2"}, "LOGIN"},
+		{"localhost", false, []string{"PLAIN"}, "PLAIN"},
+		{testHost, false, []string{"PLAIN"}, ""},
+		{testHost, false, []string{"LOGIN"}, ""},
+		{testHost, true, []string{"XOAUTH2"}, ""},
+		{testHost, true, nil, ""},
+	}
+
+	for _, test := range tests {
+		auth := NegotiateAuth(testUser, testPwd, test.name)
+		proto, _, err := auth.Start(&smtp.ServerInfo{Name: test.name, TLS: test.tls, Auth: test.mechanisms})
+		if test.proto == "" {
+			if err == nil {
+				t.Errorf("Authentication with %q should fail (TLS: %v), got %q", test.mechanisms, test.tls, proto)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Start error with %q: %v", test.mechanisms, err)
+		} else if proto != test.proto {
+			t.Errorf("Invalid protocol with %q, got %q, want %q", test.mechanisms, proto, test.proto)
+		}
+	}
+
+	auth := NegotiateAuth(testUser, testPwd, testHost)
+	if _, _, err := auth.Start(&smtp.ServerInfo{Name: "other.example.com", TLS: true, Auth: []string{"PLAIN"}}); err == nil {
+		t.Error("The credentials should not be sent to another host")
+	}
+
+	auth = AllowUnencryptedAuth(NegotiateAuth(testUser, testPwd, testHost))
+	proto, toServer, err := auth.Start(&smtp.ServerInfo{Name: testHost, Auth: []string{"PLAIN"}})
+	if err != nil {
+		t.Fatalf("Start error: %v", err)
+	}
+	if proto != "PLAIN" || string(toServer) != "\x00"+testUser+"\x00"+testPwd {
+		t.Errorf("Invalid PLAIN authentication, got %q %q", proto, toServer)
+	}
+}
+
+func TestNegotiateAuthServer(t *testing.T) {
+	defer stubScramNonce("rOprNGfwEbeRWgbNEkqO")()
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+
+	done := make(chan string)
+	go func() {
+		defer serverConn.Close()
+		server := textproto.NewConn(serverConn)
+		lines := []struct{ send, want string }{
+			{"220 " + testHost + " ESMTP", "EHLO localhost"},
+			{"250-" + testHost + "\r\n250 AUTH PLAIN LOGIN CRAM-MD5 SCRAM-SHA-1 SCRAM-SHA-256", "AUTH SCRAM-SHA-256 " + base64.StdEncoding.EncodeToString([]byte("n,,n=user,r=rOprNGfwEbeRWgbNEkqO"))},
+			{"334 " + base64.StdEncoding.EncodeToString([]byte("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")), base64.StdEncoding.EncodeToString([]byte("c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="))},
+			{"334 " + base64.StdEncoding.EncodeToString([]byte("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=")), ""},
+			{"235 2.7.0 Authentication successful", ""},
+		}
+		for i, l := range lines {
+			if err := server.PrintfLine("%s", l.send); err != nil {
+				done <- err.Error()
+				return
+			}
+			if i == len(lines)-1 {
+				break
+			}
+			got, err := server.ReadLine()
+			if err != nil {
+				done <- err.Error()
+				return
+			}
+			if got != l.want {
+				done <- "got " + got + ", want " + l.want
+				return
+			}
+		}
+		done <- ""
+	}()
+
+	c, err := smtp.NewClient(clientConn, testHost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Auth(NegotiateAuth("user", "pencil", testHost)); err != nil {
+		t.Error(err)
+	}
+	if msg := <-done; msg != "" {
+		t.Errorf("Invalid client command: %s", msg)
+	}
+}
+
+// scramClient is a flakyClient that authenticates with the SCRAM-SHA-256
+// example of RFC 7677. started, if not nil, is waited for between the first
+// and the second message so that the exchanges overlap.
+type scramClient struct {
+	*flakyClient
+	started *sync.WaitGroup
+}
+
+func (c scramClient) Extension(ext string) (bool, string) {
+	return ext == "AUTH", ""
+}
+
+func (c scramClient) Auth(a smtp.Auth) error {
+	proto, _, err := a.Start(&smtp.ServerInfo{Name: "host", TLS: true, Auth: []string{"PLAIN", "SCRAM-SHA-256"}})
+	if err != nil {
+		return err
+	}
+	if proto != "SCRAM-SHA-256" {
+		return errors.New("unexpected mechanism " + proto)
+	}
+	if c.started != nil {
+		c.started.Done()
+		c.started.Wait()
+	}
+	toServer, err := a.Next([]byte("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"), true)
+	if err != nil {
+		return err
+	}
+	if string(toServer) != "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=" {
+		return errors.New("invalid client final message " + string(toServer))
+	}
+	_, err = a.Next([]byte("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="), true)
+	return err
+}
+
+func TestNegotiateAuthConcurrent(t *testing.T) {
+	defer stubScramNonce("rOprNGfwEbeRWgbNEkqO")()
+	server := &flakyServer{}
+	started := new(sync.WaitGroup)
+	started.Add(4)
+	initSMTP = func(d NetDialer, addr string) (smtpClient, error) {
+		c, err := server.dial(d, addr)
+		return scramClient{c.(*flakyClient), started}, err
+	}
+
+	// Each connection of the pool authenticates with the same Auth.
+	pool := NewMailer("host", "user", "pencil", 587).Pool(4)
+	defer pool.Close()
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 4; j++ {
+				if err := pool.Send(newBatchMessage("to@example.com")); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if server.dials != 4 {
+		t.Errorf("Invalid number of connections, got %d, want 4", server.dials)
+	}
+}
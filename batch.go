@@ -0,0 +1,213 @@
+package gomail
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// A MessageError is the error of one of the messages given to
+// Mailer.SendBatch or Send.
+type MessageError struct {
+	// Index is the index of the message in the batch.
+	Index int
+	Err   error
+}
+
+func (e *MessageError) Error() string {
+	return "message " + strconv.Itoa(e.Index) + ": " + e.Err.Error()
+}
+
+func (e *MessageError) Unwrap() error {
+	return e.Err
+}
+
+// A BatchError is returned by Mailer.SendBatch and Send when some messages could
+// not be sent. The other messages were sent.
+type BatchError struct {
+	Errors []*MessageError
+}
+
+func (e *BatchError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+
+	return "gomail: " + strconv.Itoa(len(e.Errors)) + " messages could not be sent: " + strings.Join(msgs, "; ")
+}
+
+func (e *BatchError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, err := range e.Errors {
+		errs[i] = err
+	}
+
+	return errs
+}
+
+// SendBatch sends the messages over a single connection to the SMTP server.
+// The connection is reset between messages. If a message fails, the others are
+// still sent and a *BatchError reporting every failed message is returned. If
+// the connection is lost or the server shuts it down with a 421 reply, a new
+// connection is opened and the failed message is sent again.
+//
+// Like pools, SendBatch does not use the email-sending function set with
+// SetSendMail.
+//
+// Example:
+//
+//	if err := mailer.SendBatch(msgs...); err != nil {
+//		if batchErr, ok := err.(*gomail.BatchError); ok {
+//			for _, e := range batchErr.Errors {
+//				log.Printf("%s: %v", msgs[e.Index].GetHeader("To"), e.Err)
+//			}
+//		}
+//	}
+func (m *Mailer) SendBatch(msgs ...*Message) error {
+	return m.sendBatch(len(msgs), func(i int) *Message { return msgs[i] })
+}
+
+// SendEach sends msg to each recipient in its own transaction, over a single
+// connection like SendBatch, so that the recipients do not see each other. The
+// To header field of each email only holds its recipient and the Cc and Bcc
+// header fields are removed. If name is not nil, it returns the display name
+// of each recipient in the To header field. msg is left unchanged, except for
+// the readers of the files attached or embedded with a reader, which are read
+// once before the first email is sent.
+//
+// If some recipients fail, the others are still sent and a *BatchError whose
+// indexes are those of the failed recipients is returned.
+//
+// Example:
+//
+//	err := mailer.SendEach(msg, []string{"alex@example.com", "bob@example.com"}, func(address string) string {
+//		return names[address]
+//	})
+func (m *Mailer) SendEach(msg *Message, recipients []string, name func(address string) string) error {
+	c := msg.Clone()
+	c.DelHeader("Cc")
+	c.DelHeader("Bcc")
+	// The emails are exported once per recipient but readers can only be read
+	// once.
+	for _, files := range [][]*File{c.attachments, c.embedded} {
+		if err := bufferReaders(files); err != nil {
+			return err
+		}
+	}
+
+	return m.sendBatch(len(recipients), func(i int) *Message {
+		n := ""
+		if name != nil {
+			n = name(recipients[i])
+		}
+		c.SetAddressHeader("To", recipients[i], n)
+		return c
+	})
+}
+
+// bufferReaders reads the content of the files having a reader into Content.
+func bufferReaders(files []*File) error {
+	for _, f := range files {
+		if f.reader == nil {
+			continue
+		}
+		content, err := ioutil.ReadAll(f.reader)
+		if err != nil {
+			return err
+		}
+		f.Content = content
+		f.reader = nil
+		f.streamSize = 0
+	}
+
+	return nil
+}
+
+// sendBatch sends the n messages returned by message over a single connection.
+func (m *Mailer) sendBatch(n int, message func(i int) *Message) error {
+	b := &batch{m: m}
+	defer b.quit()
+
+	var errs []*MessageError
+	for i := 0; i < n; i++ {
+		msg := message(i)
+		o := msg.sendOptions(nil)
+		err := m.sendMessage(msg, func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+			return b.sendMail(from, to, msg, o)
+		})
+		if err != nil {
+			errs = append(errs, &MessageError{Index: i, Err: err})
+		}
+	}
+	if errs != nil {
+		return &BatchError{Errors: errs}
+	}
+
+	return nil
+}
+
+// batch holds the connection used by SendBatch.
+type batch struct {
+	m *Mailer
+	c smtpClient
+}
+
+func (b *batch) sendMail(from string, to []string, msg []byte, o *sendOptions) error {
+	err := b.send(from, to, msg, o)
+	if err != nil && isConnLost(err) {
+		// Resume with a new connection.
+		b.drop()
+		err = b.send(from, to, msg, o)
+	}
+	if err != nil && isConnLost(err) {
+		b.drop()
+	}
+
+	return err
+}
+
+// send sends an email, opening a connection if there is none or resetting the
+// current one.
+func (b *batch) send(from string, to []string, msg []byte, o *sendOptions) error {
+	if b.c == nil {
+		c, err := b.m.dial(b.m.addr, b.m.auth, b.m.ssl)
+		if err != nil {
+			return err
+		}
+		b.c = c
+	} else if err := b.c.Reset(); err != nil {
+		return wrapSMTPError(err)
+	}
+
+	return sendMail(b.c, from, to, msg, o)
+}
+
+func (b *batch) drop() {
+	if b.c != nil {
+		b.c.Close()
+		b.c = nil
+	}
+}
+
+func (b *batch) quit() {
+	if b.c != nil {
+		b.c.Quit()
+		b.drop()
+	}
+}
+
+// isConnLost reports whether the connection cannot be used anymore, either
+// because of an error of the connection or because the server is shutting it
+// down.
+func isConnLost(err error) bool {
+	var e *textproto.Error
+	if errors.As(err, &e) && e.Code == 421 {
+		return true
+	}
+
+	return isConnError(err)
+}
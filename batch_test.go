@@ -0,0 +1,224 @@
+package gomail
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+func newBatchMessage(to string) *Message {
+	msg := NewMessage()
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", to)
+	msg.SetBody("text/plain", "Test")
+
+	return msg
+}
+
+func TestSendBatch(t *testing.T) {
+	server := &flakyServer{}
+	initSMTP = server.dial
+
+	msgs := []*Message{
+		newBatchMessage("to1@example.com"),
+		newBatchMessage("reject@example.com"),
+		newBatchMessage("to2@example.com"),
+		newBatchMessage("reject@example.com"),
+		newBatchMessage("to3@example.com"),
+	}
+	err := NewMailer("host", "username", "password", 587).SendBatch(msgs...)
+
+	batchErr, ok := err.(*BatchError)
+	if !ok {
+		t.Fatalf("Invalid error, got %v, want a *BatchError", err)
+	}
+	if len(batchErr.Errors) != 2 || batchErr.Errors[0].Index != 1 || batchErr.Errors[1].Index != 3 {
+		t.Fatalf("Invalid failed messages: %v", err)
+	}
+	var tpErr *textproto.Error
+	if !errors.As(err, &tpErr) || tpErr.Code != 550 {
+		t.Errorf("The errors of the server should be wrapped, got %v", err)
+	}
+	if server.dials != 1 || server.sent != 3 {
+		t.Errorf("Invalid counts, got %d dials and %d sent, want 1 and 3", server.dials, server.sent)
+	}
+	if server.open != 0 {
+		t.Errorf("The connection should be closed, %d still open", server.open)
+	}
+}
+
+func TestSendBatchShutdown(t *testing.T) {
+	server := &flakyServer{shutdownAt: 2}
+	initSMTP = server.dial
+
+	msgs := []*Message{
+		newBatchMessage("to1@example.com"),
+		newBatchMessage("to2@example.com"),
+		newBatchMessage("to3@example.com"),
+	}
+	if err := NewMailer("host", "username", "password", 587).SendBatch(msgs...); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second message is sent again over a new connection.
+	if server.dials != 2 || server.sent != 3 {
+		t.Errorf("Invalid counts, got %d dials and %d sent, want 2 and 3", server.dials, server.sent)
+	}
+	if server.open != 0 {
+		t.Errorf("The connections should be closed, %d still open", server.open)
+	}
+}
+
+// recordingClient is a flakyClient that logs the envelope and the To header
+// field of the emails it sends.
+type recordingClient struct {
+	*flakyClient
+	log *[]string
+}
+
+func (c recordingClient) Mail(from string) error {
+	*c.log = append(*c.log, "Mail "+from)
+	return c.flakyClient.Mail(from)
+}
+
+func (c recordingClient) Rcpt(to string) error {
+	*c.log = append(*c.log, "Rcpt "+to)
+	return c.flakyClient.Rcpt(to)
+}
+
+func (c recordingClient) Data() (io.WriteCloser, error) {
+	w, err := c.flakyClient.Data()
+	if err != nil {
+		return nil, err
+	}
+	return &recordingWriter{WriteCloser: w, log: c.log}, nil
+}
+
+type recordingWriter struct {
+	io.WriteCloser
+	buf bytes.Buffer
+	log *[]string
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	return w.WriteCloser.Write(p)
+}
+
+func (w *recordingWriter) Close() error {
+	m, err := mail.ReadMessage(&w.buf)
+	if err != nil {
+		return err
+	}
+	*w.log = append(*w.log, "Data To: "+m.Header.Get("To")+" Cc: "+m.Header.Get("Cc"))
+	return w.WriteCloser.Close()
+}
+
+func TestSendEach(t *testing.T) {
+	server := &flakyServer{}
+	var log []string
+	initSMTP = func(d NetDialer, addr string) (smtpClient, error) {
+		c, err := server.dial(d, addr)
+		return recordingClient{c.(*flakyClient), &log}, err
+	}
+
+	msg := newBatchMessage("list@example.com")
+	msg.SetHeader("Cc", "cc@example.com")
+	names := map[string]string{"to1@example.com": "Alex"}
+	err := NewMailer("host", "username", "password", 587).SendEach(msg, []string{
+		"to1@example.com",
+		"reject@example.com",
+		"to2@example.com",
+	}, func(address string) string { return names[address] })
+
+	batchErr, ok := err.(*BatchError)
+	if !ok || len(batchErr.Errors) != 1 || batchErr.Errors[0].Index != 1 {
+		t.Fatalf("Invalid error, got %v, want the second recipient to fail", err)
+	}
+	want := []string{
+		"Mail from@example.com",
+		"Rcpt to1@example.com",
+		"Data To: Alex <to1@example.com> Cc: ",
+		"Mail from@example.com",
+		"Rcpt reject@example.com",
+		"Mail from@example.com",
+		"Rcpt to2@example.com",
+		"Data To: to2@example.com Cc: ",
+	}
+	if got := strings.Join(log, "\n"); got != strings.Join(want, "\n") {
+		t.Errorf("Invalid transactions, got:\n%s\nwant:\n%s", got, strings.Join(want, "\n"))
+	}
+	if server.dials != 1 || server.sent != 2 {
+		t.Errorf("Invalid counts, got %d dials and %d sent, want 1 and 2", server.dials, server.sent)
+	}
+	if got := msg.GetHeader("To"); len(got) != 1 || got[0] != "list@example.com" {
+		t.Errorf("The message should be left unchanged, got To %q", got)
+	}
+}
+
+// bodyClient is a flakyClient that keeps the emails it sends.
+type bodyClient struct {
+	*flakyClient
+	bodies *[]string
+}
+
+func (c bodyClient) Data() (io.WriteCloser, error) {
+	w, err := c.flakyClient.Data()
+	if err != nil {
+		return nil, err
+	}
+	return &bodyWriter{WriteCloser: w, bodies: c.bodies}, nil
+}
+
+type bodyWriter struct {
+	io.WriteCloser
+	buf    bytes.Buffer
+	bodies *[]string
+}
+
+func (w *bodyWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	return w.WriteCloser.Write(p)
+}
+
+func (w *bodyWriter) Close() error {
+	*w.bodies = append(*w.bodies, w.buf.String())
+	return w.WriteCloser.Close()
+}
+
+func TestSendEachReader(t *testing.T) {
+	server := &flakyServer{}
+	var bodies []string
+	initSMTP = func(d NetDialer, addr string) (smtpClient, error) {
+		c, err := server.dial(d, addr)
+		return bodyClient{c.(*flakyClient), &bodies}, err
+	}
+
+	msg := newBatchMessage("list@example.com")
+	if err := msg.AttachReader("report.txt", strings.NewReader("report")); err != nil {
+		t.Fatal(err)
+	}
+	if err := msg.AttachSized("sized.txt", strings.NewReader("sized"), 5); err != nil {
+		t.Fatal(err)
+	}
+	recipients := []string{"to1@example.com", "to2@example.com", "to3@example.com"}
+	if err := NewMailer("host", "username", "password", 587).SendEach(msg, recipients, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(bodies) != len(recipients) {
+		t.Fatalf("Invalid number of emails, got %d, want %d", len(bodies), len(recipients))
+	}
+	for i, body := range bodies {
+		for _, content := range []string{"report", "sized"} {
+			if want := base64.StdEncoding.EncodeToString([]byte(content)); !strings.Contains(body, want) {
+				t.Errorf("The email to %s should contain %q:\n%s", recipients[i], content, body)
+			}
+		}
+	}
+}
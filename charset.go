@@ -0,0 +1,107 @@
+package gomail
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/alexcesaro/quotedprintable.v2"
+)
+
+// defaultCharset is the charset of messages whose charset is not set.
+const defaultCharset = "UTF-8"
+
+// charsets maps the lowercase names and aliases of common IANA charsets to
+// their preferred MIME name.
+var charsets = map[string]string{
+	"utf-8":        "UTF-8",
+	"utf8":         "UTF-8",
+	"us-ascii":     "US-ASCII",
+	"ascii":        "US-ASCII",
+	"iso-8859-1":   "ISO-8859-1",
+	"latin1":       "ISO-8859-1",
+	"iso-8859-2":   "ISO-8859-2",
+	"iso-8859-5":   "ISO-8859-5",
+	"iso-8859-7":   "ISO-8859-7",
+	"iso-8859-9":   "ISO-8859-9",
+	"iso-8859-15":  "ISO-8859-15",
+	"windows-1250": "windows-1250",
+	"windows-1251": "windows-1251",
+	"windows-1252": "windows-1252",
+	"koi8-r":       "KOI8-R",
+	"koi8-u":       "KOI8-U",
+	"shift_jis":    "Shift_JIS",
+	"euc-jp":       "EUC-JP",
+	"iso-2022-jp":  "ISO-2022-JP",
+	"euc-kr":       "EUC-KR",
+	"gb2312":       "GB2312",
+	"gbk":          "GBK",
+	"gb18030":      "GB18030",
+	"big5":         "Big5",
+}
+
+// normalizeCharset returns the preferred MIME name of charset if it is a
+// common one, charset itself if it is a valid charset name as defined in
+// RFC 2978, 2.3. and false if it is not valid.
+func normalizeCharset(charset string) (string, bool) {
+	if name, ok := charsets[strings.ToLower(charset)]; ok {
+		return name, true
+	}
+	if len(charset) == 0 || len(charset) > 40 {
+		return "", false
+	}
+	for i := 0; i < len(charset); i++ {
+		c := charset[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' {
+			continue
+		}
+		if !strings.ContainsRune("!#$%&'+-^_`{}~", rune(c)) {
+			return "", false
+		}
+	}
+
+	return charset, true
+}
+
+// SetCharset sets the charset of the message. Common charset names are
+// normalized, for example "utf8" becomes "UTF-8". It returns an error if name
+// is not a valid charset name, in which case the charset is left unchanged.
+//
+// Only the header fields set after the call are encoded with the new charset.
+//
+// Example:
+//
+//	if err := msg.SetCharset("ISO-8859-1"); err != nil {
+//		panic(err)
+//	}
+func (msg *Message) SetCharset(name string) error {
+	charset, ok := normalizeCharset(name)
+	if !ok {
+		return fmt.Errorf("gomail: invalid charset %q", name)
+	}
+	msg.charset = charset
+	msg.setHeaderEncoder()
+
+	return nil
+}
+
+// setHeaderEncoder sets the encoder of the header fields from the charset and
+// the encoding of the message.
+func (msg *Message) setHeaderEncoder() {
+	var e quotedprintable.Encoding
+	if msg.encoding == Base64 {
+		e = quotedprintable.B
+	} else {
+		e = quotedprintable.Q
+	}
+	msg.hEncoder = e.NewHeaderEncoder(msg.headerCharset())
+}
+
+// headerCharset returns the charset of the encoded header fields.
+func (msg *Message) headerCharset() string {
+	if msg.transcode {
+		// Only the body is transcoded so header fields stay in UTF-8.
+		return defaultCharset
+	}
+
+	return msg.charset
+}
@@ -0,0 +1,159 @@
+package gomail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// EmbedInline embeds the image to the email and returns its Content-ID. If the
+// file has no Content-ID, a unique one is generated.
+//
+// If the message was created with SetContentIDRewriting(true), the HTML parts
+// can reference the image by its name, either as src="cid:name" or as
+// src="name", and the reference is rewritten with the Content-ID when the
+// message is exported.
+//
+// Example:
+//
+//	f, err := gomail.OpenFile("/tmp/image.jpg")
+//	if err != nil {
+//		panic(err)
+//	}
+//	cid := msg.EmbedInline(f)
+//	msg.SetBody("text/html", `<img src="cid:`+cid+`" alt="My image" />`)
+func (msg *Message) EmbedInline(f *File) string {
+	if f.ContentID == "" {
+		f.ContentID = msg.newContentID()
+	}
+
+	return msg.embed(f).ContentID
+}
+
+// newContentID returns a Content-ID unique in the message. It is made of a
+// counter and of a random suffix so that it is also unlikely to collide with
+// the Content-IDs of other messages.
+func (msg *Message) newContentID() string {
+	msg.cidCount++
+	var buf [8]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		panic(err)
+	}
+
+	return fmt.Sprintf("part%d.%x@gomail", msg.cidCount, buf)
+}
+
+// contentID returns the Content-ID of the embedded file f. The name of the file
+// is used if it has none.
+func contentID(f *File) string {
+	if f.ContentID != "" {
+		return stripNewlines(f.ContentID)
+	}
+
+	return stripNewlines(f.Name)
+}
+
+// checkContentIDs returns an error if two embedded files have the same
+// Content-ID.
+func (msg *Message) checkContentIDs() error {
+	seen := make(map[string]bool, len(msg.embedded))
+	for _, f := range msg.embedded {
+		cid := contentID(f)
+		if seen[cid] {
+			return fmt.Errorf("gomail: several embedded files have the Content-ID %q", cid)
+		}
+		seen[cid] = true
+	}
+
+	return nil
+}
+
+// embeddedFile returns the embedded file that is f, a copy of f with another
+// Content-ID or an identical file with the same name, Content-ID and content.
+// It returns nil if there is none.
+func (msg *Message) embeddedFile(f *File) *File {
+	for _, e := range msg.embedded {
+		if e == f || e.dedupedFrom == f {
+			return e
+		}
+		if !e.isStream() && !f.isStream() && e.Name == f.Name && e.ContentID == f.ContentID &&
+			e.MimeType == f.MimeType && bytes.Equal(e.Content, f.Content) {
+			return e
+		}
+	}
+
+	return nil
+}
+
+// dedupeContentID returns f or, if an embedded file already has the same
+// Content-ID, a copy of f with a suffixed Content-ID. f is left unchanged so
+// that it can be embedded in other messages. It is done when the file is
+// embedded rather than when the message is exported so that exporting does
+// not modify the message.
+func (msg *Message) dedupeContentID(f *File) *File {
+	seen := make(map[string]bool, len(msg.embedded))
+	for _, e := range msg.embedded {
+		seen[contentID(e)] = true
+	}
+
+	cid := contentID(f)
+	unique := cid
+	for n := 2; seen[unique]; n++ {
+		unique = cid + "-" + strconv.Itoa(n)
+	}
+	if unique == cid {
+		return f
+	}
+	c := *f
+	c.ContentID = unique
+	c.dedupedFrom = f
+
+	return &c
+}
+
+// cidReplacer returns a replacer rewriting the references to the embedded
+// files by name into references to their Content-ID. It returns nil if there
+// is nothing to rewrite.
+func (msg *Message) cidReplacer() *strings.Replacer {
+	if !msg.rewriteCIDs {
+		return nil
+	}
+
+	var oldnew []string
+	for _, f := range msg.embedded {
+		if f.ContentID == "" || f.ContentID == f.Name {
+			continue
+		}
+		for _, q := range []string{`"`, `'`} {
+			cid := "src=" + q + "cid:" + f.ContentID + q
+			oldnew = append(oldnew,
+				"src="+q+"cid:"+f.Name+q, cid,
+				"src="+q+f.Name+q, cid,
+			)
+		}
+	}
+	if len(oldnew) == 0 {
+		return nil
+	}
+
+	return strings.NewReplacer(oldnew...)
+}
+
+// rewriteCIDs returns body with the references to embedded files rewritten by
+// r if it is HTML. body itself is left untouched.
+func rewriteCIDs(r *strings.Replacer, contentType string, body []byte) []byte {
+	if r == nil || !isHTML(contentType) {
+		return body
+	}
+
+	buf := new(bytes.Buffer)
+	r.WriteString(buf, string(body))
+
+	return buf.Bytes()
+}
+
+func isHTML(contentType string) bool {
+	return strings.HasPrefix(contentType, "text/html")
+}
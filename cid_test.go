@@ -0,0 +1,184 @@
+package gomail
+
+import (
+	"encoding/base64"
+	"io"
+	"net/smtp"
+	"strings"
+	"testing"
+)
+
+func TestEmbedInline(t *testing.T) {
+	msg := NewMessage(SetContentIDRewriting(true))
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.SetBody("text/plain", `src="cid:logo.png"`)
+	msg.AddAlternative("text/html", "<img src=\"cid:logo.png\">\r\n<img src='photo.jpg'>")
+	cid1 := msg.EmbedInline(CreateFile("logo.png", []byte("Content 1")))
+	cid2 := msg.EmbedInline(CreateFile("photo.jpg", []byte("Content 2")))
+	cid3 := msg.EmbedInline(CreateFile("photo.jpg", []byte("Content 3")))
+	explicit := CreateFile("icon.png", []byte("Content 4"))
+	explicit.ContentID = "icon"
+
+	if cid1 == cid2 || cid2 == cid3 {
+		t.Fatalf("Content-IDs should be unique, got %q, %q and %q", cid1, cid2, cid3)
+	}
+	for _, cid := range []string{cid1, cid2, cid3} {
+		if !strings.Contains(cid, "@") || strings.ContainsAny(cid, "<> ") {
+			t.Errorf("Invalid Content-ID %q", cid)
+		}
+	}
+	if cid := msg.EmbedInline(explicit); cid != "icon" {
+		t.Errorf("Invalid Content-ID, got %q, want %q", cid, "icon")
+	}
+
+	got := sendToString(t, msg)
+	for _, want := range []string{
+		`<img src=3D"cid:` + cid1 + `">` + "\r\n",
+		`<img src=3D'cid:` + cid2 + `'>` + "\r\n",
+		"Content-ID: <" + cid1 + ">\r\n",
+		"Content-ID: <" + cid2 + ">\r\n",
+		"Content-ID: <" + cid3 + ">\r\n",
+		"Content-ID: <icon>\r\n",
+		// Only HTML parts are rewritten.
+		`src=3D"cid:logo.png"` + "\r\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Message does not contain %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestEmbedInlineNoRewriting(t *testing.T) {
+	msg := NewMessage()
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.SetBody("text/html", `<img src="cid:logo.png">`)
+	msg.EmbedInline(CreateFile("logo.png", []byte("Content")))
+
+	got := sendToString(t, msg)
+	if !strings.Contains(got, `<img src=3D"cid:logo.png">`) {
+		t.Errorf("Message should not be rewritten:\n%s", got)
+	}
+}
+
+func sendToString(t *testing.T, msg *Message) string {
+	var got string
+	sendMail := func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		got = string(msg)
+		return nil
+	}
+	mailer := NewMailer("host", "username", "password", 587, SetSendMail(sendMail))
+	if err := mailer.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	return got
+}
+
+func TestEmbedInlineBodyWriter(t *testing.T) {
+	msg := NewMessage(SetContentIDRewriting(true))
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.SetBodyWriter("text/html", func(w io.Writer) error {
+		// The reference is split between two writes.
+		io.WriteString(w, `<img src="cid:lo`)
+		_, err := io.WriteString(w, `go.png">`)
+		return err
+	})
+	cid := msg.EmbedInline(CreateFile("logo.png", []byte("Content")))
+
+	got := sendToString(t, msg)
+	if want := `<img src=3D"cid:` + cid + `">`; !strings.Contains(got, want) {
+		t.Errorf("Message does not contain %q:\n%s", want, got)
+	}
+}
+
+func TestDuplicateContentID(t *testing.T) {
+	msg := NewMessage()
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.SetBody("text/html", `<img src="cid:logo.png">`)
+	msg.Embed(CreateFile("logo.png", []byte("Content 1")))
+	f := CreateFile("other.png", []byte("Content 2"))
+	f.ContentID = "logo.png"
+	msg.Embed(f)
+
+	if _, err := msg.Bytes(); err == nil || !strings.Contains(err.Error(), `"logo.png"`) {
+		t.Errorf("Invalid error, got %v", err)
+	}
+}
+
+func TestAutoDedupeContentID(t *testing.T) {
+	msg := NewMessage(SetAutoDedupeCID(true))
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.Embed(CreateFile("logo.png", []byte("Content 1")))
+	msg.Embed(CreateFile("logo.png", []byte("Content 2")))
+	msg.Embed(CreateFile("logo.png", []byte("Content 3")))
+
+	// Exporting again does not change the Content-IDs.
+	for i := 0; i < 2; i++ {
+		got := sendToString(t, msg)
+		for _, want := range []string{"<logo.png>", "<logo.png-2>", "<logo.png-3>"} {
+			if !strings.Contains(got, "Content-ID: "+want+"\r\n") {
+				t.Errorf("Message does not contain the Content-ID %s:\n%s", want, got)
+			}
+		}
+	}
+}
+
+func TestAutoDedupeContentIDSharedFile(t *testing.T) {
+	logo := CreateFile("logo.png", []byte("Shared logo"))
+	for i := 0; i < 2; i++ {
+		msg := NewMessage(SetAutoDedupeCID(true))
+		msg.SetHeader("From", "from@example.com")
+		msg.SetHeader("To", "to@example.com")
+		if i == 0 {
+			msg.Embed(CreateFile("logo.png", []byte("Other logo")))
+		}
+		msg.Embed(logo)
+		// Embedding the shared file again does nothing.
+		msg.Embed(logo)
+		cid := contentID(msg.embed(logo))
+
+		want, files := "logo.png", 1
+		if i == 0 {
+			want, files = "logo.png-2", 2
+		}
+		if cid != want {
+			t.Errorf("Invalid Content-ID in message %d, got %q, want %q", i, cid, want)
+		}
+		if len(msg.embedded) != files {
+			t.Errorf("Invalid number of embedded files in message %d, got %d, want %d", i, len(msg.embedded), files)
+		}
+		if got := sendToString(t, msg); !strings.Contains(got, "Content-ID: <"+want+">\r\n") {
+			t.Errorf("Message %d does not contain the Content-ID <%s>:\n%s", i, want, got)
+		}
+	}
+	if logo.ContentID != "" {
+		t.Errorf("The shared file should be left unchanged, got Content-ID %q", logo.ContentID)
+	}
+}
+
+func TestEmbedOnce(t *testing.T) {
+	msg := NewMessage()
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.SetBody("text/plain", "Hello")
+	msg.AddAlternative("text/html", `<img src="cid:logo.png">`)
+	logo := CreateFile("logo.png", []byte("Logo content"))
+	msg.Embed(logo, logo)
+	msg.Embed(CreateFile("logo.png", []byte("Logo content")))
+
+	got := sendToString(t, msg)
+	if n := strings.Count(got, base64.StdEncoding.EncodeToString([]byte("Logo content"))); n != 1 {
+		t.Errorf("The image should be written once, got %d times:\n%s", n, got)
+	}
+
+	// A file with the same name but another content is still embedded.
+	msg.Embed(CreateFile("logo.png", []byte("Other content")))
+	if len(msg.embedded) != 2 {
+		t.Errorf("Invalid number of embedded files, got %d, want 2", len(msg.embedded))
+	}
+}
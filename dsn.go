@@ -0,0 +1,102 @@
+package gomail
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DSNOptions are the Delivery Status Notification parameters defined in
+// RFC 3461. They are only sent to servers advertising the DSN extension.
+type DSNOptions struct {
+	// Return is either "FULL" or "HDRS" and tells whether the whole message or
+	// only its header is returned with a failure notification. It is not sent
+	// if empty.
+	Return string
+	// EnvelopeID is an identifier returned in the notifications. It is not
+	// sent if empty.
+	EnvelopeID string
+	// Notify is either "NEVER" or a combination of "SUCCESS", "FAILURE" and
+	// "DELAY". It is not sent if empty.
+	Notify []string
+}
+
+// A SendOption configures how a message is sent.
+//
+// SendOptions are ignored by the email-sending functions set with SetSendMail
+// since they only receive the envelope and the content of the message.
+type SendOption func(o *sendOptions)
+
+type sendOptions struct {
+	dsn *DSNOptions
+	// body is the SMTP extension needed to send the content of the message.
+	body string
+}
+
+// WithDSN is a send option to request Delivery Status Notifications. It is
+// ignored if the server does not support them.
+//
+// Example:
+//
+//	err := mailer.Send(msg, gomail.WithDSN(gomail.DSNOptions{
+//		Notify: []string{"SUCCESS", "FAILURE"},
+//	}))
+func WithDSN(opts DSNOptions) SendOption {
+	return func(o *sendOptions) {
+		o.dsn = &opts
+	}
+}
+
+func newSendOptions(opts []SendOption) *sendOptions {
+	o := new(sendOptions)
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+// sendOptions returns the options used to send msg.
+func (msg *Message) sendOptions(opts []SendOption) *sendOptions {
+	o := newSendOptions(opts)
+	o.body = msg.bodyExtension()
+
+	return o
+}
+
+// mailParams returns the parameters of the MAIL command.
+func (o *DSNOptions) mailParams() []string {
+	var params []string
+	if o.Return != "" {
+		params = append(params, "RET="+strings.ToUpper(o.Return))
+	}
+	if o.EnvelopeID != "" {
+		params = append(params, "ENVID="+xtext(o.EnvelopeID))
+	}
+
+	return params
+}
+
+// rcptParams returns the parameters of the RCPT command of the recipient to.
+func (o *DSNOptions) rcptParams(to string) []string {
+	var params []string
+	if len(o.Notify) > 0 {
+		params = append(params, "NOTIFY="+strings.ToUpper(strings.Join(o.Notify, ",")))
+	}
+
+	return append(params, "ORCPT=rfc822;"+xtext(to))
+}
+
+// xtext encodes s as defined in RFC 3461, 4.
+func xtext(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < '!' || c > '~' || c == '+' || c == '=' {
+			fmt.Fprintf(&b, "+%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
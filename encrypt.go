@@ -0,0 +1,239 @@
+package gomail
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+)
+
+// An EncryptionRecipient is a recipient for which a message is encrypted with
+// Message.Encrypt. It is created with SMIMERecipient or PGPRecipients.
+type EncryptionRecipient interface {
+	// isPGP reports whether the recipient uses PGP/MIME rather than S/MIME.
+	isPGP() bool
+}
+
+// A PGPEncrypter encrypts content with OpenPGP, for example with
+// golang.org/x/crypto/openpgp, for all its recipients.
+type PGPEncrypter interface {
+	// EncryptPGP returns content encrypted and ASCII-armored.
+	EncryptPGP(content []byte) ([]byte, error)
+}
+
+type smimeRecipient struct {
+	cert *x509.Certificate
+}
+
+func (smimeRecipient) isPGP() bool { return false }
+
+type pgpRecipients struct {
+	e PGPEncrypter
+}
+
+func (pgpRecipients) isPGP() bool { return true }
+
+// SMIMERecipient returns an S/MIME recipient owning the given certificate. Its
+// public key must be an RSA key.
+func SMIMERecipient(cert *x509.Certificate) EncryptionRecipient {
+	return smimeRecipient{cert: cert}
+}
+
+// PGPRecipients returns the recipients for which e encrypts messages with
+// PGP/MIME.
+func PGPRecipients(e PGPEncrypter) EncryptionRecipient {
+	return pgpRecipients{e: e}
+}
+
+// Encrypt encrypts the content of the message for the given recipients. The
+// message, signed first if it has a signature, is then sent as an
+// application/pkcs7-mime part as defined in RFC 8551 for S/MIME recipients, or
+// as a multipart/encrypted part as defined in RFC 3156 for PGP recipients. The
+// header fields of the message, like Subject, are not encrypted.
+//
+// It returns an error if there is no recipient or if S/MIME and PGP recipients
+// are mixed.
+//
+// Example:
+//
+//	if err := msg.Encrypt(gomail.SMIMERecipient(cert)); err != nil {
+//		panic(err)
+//	}
+func (msg *Message) Encrypt(recipients ...EncryptionRecipient) error {
+	if len(recipients) == 0 {
+		return errors.New("gomail: no encryption recipient")
+	}
+	pgp := recipients[0].isPGP()
+	for _, r := range recipients[1:] {
+		if r.isPGP() || pgp {
+			return errors.New("gomail: PGP recipients cannot be mixed with other recipients")
+		}
+	}
+	for _, r := range recipients {
+		if r, ok := r.(smimeRecipient); ok {
+			if _, ok := r.cert.PublicKey.(*rsa.PublicKey); !ok {
+				return errors.New("gomail: S/MIME encryption only supports RSA keys")
+			}
+		}
+	}
+	msg.recipients = recipients
+
+	return nil
+}
+
+// writeEncrypted renders the content of msg, signed if needed, encrypts it and
+// writes the encrypted content in w.
+func (w *messageWriter) writeEncrypted(msg *Message) error {
+	inner := w.newInnerWriter()
+	defer putMessageWriter(inner)
+	if msg.signer != nil {
+		if err := inner.writeSigned(msg, msg.signer); err != nil {
+			return err
+		}
+	} else {
+		msg.writeContent(inner)
+	}
+	if inner.err != nil {
+		return inner.err
+	}
+
+	content := getBuffer()
+	defer putBuffer(content)
+	writeHeaderBlock(content, inner.header)
+	content.Write(inner.buf.Bytes())
+
+	if r, ok := msg.recipients[0].(pgpRecipients); ok {
+		encrypted, err := r.e.EncryptPGP(content.Bytes())
+		if err != nil {
+			return err
+		}
+		w.writePGPEncrypted(encrypted)
+		return nil
+	}
+
+	certs := make([]*x509.Certificate, len(msg.recipients))
+	for i, r := range msg.recipients {
+		certs[i] = r.(smimeRecipient).cert
+	}
+	enveloped, err := envelope(content.Bytes(), certs)
+	if err != nil {
+		return err
+	}
+	h := make(map[string][]string)
+	h["Content-Type"] = []string{"application/pkcs7-mime; smime-type=enveloped-data; name=\"smime.p7m\""}
+	h["Content-Disposition"] = []string{"attachment; filename=\"smime.p7m\""}
+	h["Content-Transfer-Encoding"] = []string{string(Base64)}
+	w.write(h, enveloped, Base64)
+
+	return nil
+}
+
+// writePGPEncrypted writes the multipart/encrypted structure of RFC 3156, 4.
+func (w *messageWriter) writePGPEncrypted(encrypted []byte) {
+	w.openMultipart("encrypted; protocol=\"application/pgp-encrypted\"")
+
+	h := make(map[string][]string)
+	h["Content-Type"] = []string{"application/pgp-encrypted"}
+	h["Content-Description"] = []string{"PGP/MIME version identification"}
+	h["Content-Transfer-Encoding"] = []string{string(SevenBit)}
+	w.write(h, []byte("Version: 1\r\n"), SevenBit)
+
+	h = make(map[string][]string)
+	h["Content-Type"] = []string{"application/octet-stream; name=\"encrypted.asc\""}
+	h["Content-Disposition"] = []string{"inline; filename=\"encrypted.asc\""}
+	h["Content-Transfer-Encoding"] = []string{string(SevenBit)}
+	w.write(h, encrypted, SevenBit)
+
+	w.closeMultipart()
+}
+
+var (
+	oidEnvelopedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+	oidAES256CBC     = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// The ASN.1 structures of a CMS enveloped-data content as defined in RFC 5652.
+type (
+	envelopedData struct {
+		Version              int
+		RecipientInfos       []keyTransRecipientInfo `asn1:"set"`
+		EncryptedContentInfo encryptedContentInfo
+	}
+
+	keyTransRecipientInfo struct {
+		Version                int
+		RID                    issuerAndSerialNumber
+		KeyEncryptionAlgorithm algorithmIdentifier
+		EncryptedKey           []byte
+	}
+
+	encryptedContentInfo struct {
+		ContentType                asn1.ObjectIdentifier
+		ContentEncryptionAlgorithm algorithmIdentifier
+		EncryptedContent           asn1.RawValue
+	}
+)
+
+// envelope encrypts content with AES-256-CBC and a random key, itself
+// encrypted for each certificate with RSA, and returns the DER encoding of the
+// resulting CMS enveloped-data content.
+func envelope(content []byte, certs []*x509.Certificate) ([]byte, error) {
+	key := make([]byte, 32)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	// PKCS #7 padding, RFC 5652, 6.3.
+	n := aes.BlockSize - len(content)%aes.BlockSize
+	padded := append(append([]byte(nil), content...), bytes.Repeat([]byte{byte(n)}, n)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(padded, padded)
+
+	infos := make([]keyTransRecipientInfo, len(certs))
+	for i, cert := range certs {
+		encryptedKey, err := rsa.EncryptPKCS1v15(rand.Reader, cert.PublicKey.(*rsa.PublicKey), key)
+		if err != nil {
+			return nil, err
+		}
+		infos[i] = keyTransRecipientInfo{
+			RID: issuerAndSerialNumber{
+				Issuer:       asn1.RawValue{FullBytes: cert.RawIssuer},
+				SerialNumber: cert.SerialNumber,
+			},
+			KeyEncryptionAlgorithm: algorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue},
+			EncryptedKey:           encryptedKey,
+		}
+	}
+
+	params, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+	ed, err := asn1.Marshal(envelopedData{
+		RecipientInfos: infos,
+		EncryptedContentInfo: encryptedContentInfo{
+			ContentType:                oidData,
+			ContentEncryptionAlgorithm: algorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: params}},
+			EncryptedContent:           asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, Bytes: padded},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(contentInfo{
+		ContentType: oidEnvelopedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: ed},
+	})
+}
@@ -0,0 +1,155 @@
+package gomail
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newEncryptedMessage() *Message {
+	msg := NewMessage()
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.SetHeader("Subject", "Secret")
+	msg.SetBody("text/plain", "¡Hola, señor!")
+
+	return msg
+}
+
+func TestEncryptSMIME(t *testing.T) {
+	openssl, err := exec.LookPath("openssl")
+	if err != nil {
+		t.Skip("openssl is not installed")
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := testCertificate(t, key)
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := newEncryptedMessage()
+	if err := msg.Encrypt(SMIMERecipient(testCertificate(t, other)), SMIMERecipient(cert)); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	name := filepath.Join(dir, "encrypted.eml")
+	if err := msg.WriteToFile(name); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(b); !strings.Contains(got, "Subject: Secret\r\n") ||
+		!strings.Contains(got, "Content-Type: application/pkcs7-mime; smime-type=enveloped-data; name=\"smime.p7m\"\r\n") ||
+		strings.Contains(got, "=C2=A1Hola") {
+		t.Errorf("Invalid encrypted message:\n%s", got)
+	}
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writePEM(t, certFile, "CERTIFICATE", cert.Raw)
+	writePEM(t, keyFile, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key))
+
+	out, err := exec.Command(openssl, "smime", "-decrypt", "-in", name, "-recip", certFile, "-inkey", keyFile).CombinedOutput()
+	if err != nil {
+		t.Fatalf("Decryption failed: %v\n%s", err, out)
+	}
+	want := "Content-Transfer-Encoding: quoted-printable\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"Mime-Version: 1.0\r\n" +
+		"\r\n" +
+		"=C2=A1Hola, se=C3=B1or!"
+	if string(out) != want {
+		t.Errorf("Invalid decrypted content, got:\n%q\nwant:\n%q", out, want)
+	}
+}
+
+func writePEM(t *testing.T, name, typ string, der []byte) {
+	if err := os.WriteFile(name, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type stubPGPEncrypter struct {
+	content []byte
+}
+
+func (e *stubPGPEncrypter) EncryptPGP(content []byte) ([]byte, error) {
+	e.content = append([]byte(nil), content...)
+	return []byte("-----BEGIN PGP MESSAGE-----"), nil
+}
+
+func TestEncryptPGP(t *testing.T) {
+	e := new(stubPGPEncrypter)
+	msg := newEncryptedMessage()
+	if err := msg.Encrypt(PGPRecipients(e)); err != nil {
+		t.Fatal(err)
+	}
+
+	want := message{
+		from: "from@example.com",
+		to:   []string{"to@example.com"},
+		content: "From: from@example.com\r\n" +
+			"To: to@example.com\r\n" +
+			"Subject: Secret\r\n" +
+			"Content-Type: multipart/encrypted; protocol=\"application/pgp-encrypted\"; boundary=_BOUNDARY_1_\r\n" +
+			"\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			"Content-Type: application/pgp-encrypted\r\n" +
+			"Content-Description: PGP/MIME version identification\r\n" +
+			"Content-Transfer-Encoding: 7bit\r\n" +
+			"\r\n" +
+			"Version: 1\r\n" +
+			"\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			"Content-Type: application/octet-stream; name=\"encrypted.asc\"\r\n" +
+			"Content-Disposition: inline; filename=\"encrypted.asc\"\r\n" +
+			"Content-Transfer-Encoding: 7bit\r\n" +
+			"\r\n" +
+			"-----BEGIN PGP MESSAGE-----\r\n" +
+			"--_BOUNDARY_1_--\r\n",
+	}
+	testMessage(t, msg, 1, want)
+
+	wantContent := "Content-Transfer-Encoding: quoted-printable\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"Mime-Version: 1.0\r\n" +
+		"\r\n" +
+		"=C2=A1Hola, se=C3=B1or!"
+	if string(e.content) != wantContent {
+		t.Errorf("Invalid encrypted content, got:\n%s\nwant:\n%s", e.content, wantContent)
+	}
+}
+
+func TestEncryptInvalidRecipients(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	smime := SMIMERecipient(testCertificate(t, key))
+	pgp := PGPRecipients(new(stubPGPEncrypter))
+
+	msg := newEncryptedMessage()
+	if err := msg.Encrypt(); err == nil {
+		t.Error("Encrypt should fail without recipient")
+	}
+	if err := msg.Encrypt(smime, pgp); err == nil {
+		t.Error("Encrypt should fail when S/MIME and PGP recipients are mixed")
+	}
+	if msg.recipients != nil {
+		t.Error("The recipients should not be set when Encrypt fails")
+	}
+}
@@ -1,19 +1,260 @@
 package gomail
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/base64"
+	"errors"
+	"fmt"
 	"io"
+	"mime"
 	"net/mail"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	patchedMulipart "github.com/Kane-Sendgrid/gomail/patch/mime/multipart"
 	"gopkg.in/alexcesaro/quotedprintable.v2"
 )
 
 // Export converts the message into a net/mail.Message.
+//
+// If the message cannot be exported, for example because the SignatureProvider
+// returns an error, Export returns nil. Use ExportWithError to get the error.
 func (msg *Message) Export() *mail.Message {
+	m, err := msg.export()
+	if err != nil {
+		return nil
+	}
+
+	return m
+}
+
+// ExportWithError converts the message into a net/mail.Message like Export but
+// returns the error preventing the message from being exported, if any, such
+// as a signing or encoding error or ErrMessageTooLarge.
+//
+// Example:
+//
+//	m, err := msg.ExportWithError()
+//	if err != nil {
+//		return err
+//	}
+func (msg *Message) ExportWithError() (*mail.Message, error) {
+	return msg.export()
+}
+
+// WriteTo implements io.WriterTo. It writes the whole message, header fields
+// included, as it is sent by Mailer.Send: lines end with CRLF and the header
+// fields are sorted. The Bcc header field is not written.
+//
+// The message is streamed to w so it is never entirely held in memory.
+func (msg *Message) WriteTo(w io.Writer) (int64, error) {
+	cw := &countWriter{w: w, limit: msg.maxSize}
+	if msg.preSendHook != nil {
+		// The hook needs the whole body before the header is written.
+		mw, err := msg.exportWriter()
+		if err != nil {
+			return 0, err
+		}
+		defer putMessageWriter(mw)
+		cw.Write(flattenHeader(mw.export(), "", msg.headerOrder))
+		cw.Write(mw.buf.Bytes())
+
+		return cw.n, cw.err
+	}
+
+	mw := newMessageWriter(msg)
+	defer putMessageWriter(mw)
+	hw := &headerWriter{w: cw, header: mw.header, order: msg.headerOrder}
+	mw.out = hw
+
+	if err := msg.writeMessage(mw); err != nil {
+		return cw.n, err
+	}
+	if !hw.done {
+		hw.flush()
+	}
+
+	return cw.n, cw.err
+}
+
+// Size returns the size in bytes of the message as written by WriteTo. This is
+// the encoded size, including the header, the base64 or quoted-printable
+// expansion and the line breaks, not the size of the raw content. The message
+// is not kept in memory while it is measured.
+//
+// Files added with AttachReader or EmbedReader are consumed by Size so they
+// cannot be sent afterwards.
+//
+// Example:
+//
+//	if size, err := msg.Size(); err != nil || size > 25<<20 {
+//		// Reject the message
+//	}
+func (msg *Message) Size() (int64, error) {
+	return msg.WriteTo(io.Discard)
+}
+
+// WriteToFile writes the message to the named file, as written by WriteTo, so
+// that it can be opened by email clients as an .eml file. The file ends with a
+// line break. If an error occurs, the partially written file is removed.
+//
+// Example:
+//
+//	if err := msg.WriteToFile("message.eml"); err != nil {
+//		panic(err)
+//	}
+func (msg *Message) WriteToFile(name string) (err error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(name)
+		}
+	}()
+
+	bw := bufio.NewWriter(f)
+	w := &tailWriter{w: bw}
+	if _, err = msg.WriteTo(w); err != nil {
+		return err
+	}
+	if !bytes.Equal(w.tail[:], crlf) {
+		if _, err = w.Write(crlf); err != nil {
+			return err
+		}
+	}
+	if err = bw.Flush(); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// tailWriter remembers the last two bytes written to w.
+type tailWriter struct {
+	w    io.Writer
+	tail [2]byte
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	switch {
+	case n >= 2:
+		copy(w.tail[:], p[n-2:n])
+	case n == 1:
+		w.tail = [2]byte{w.tail[1], p[0]}
+	}
+
+	return n, err
+}
+
+// Bytes returns the whole message as written by WriteTo. It is mostly useful
+// for logging and testing.
+func (msg *Message) Bytes() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if _, err := msg.WriteTo(buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (msg *Message) export() (*mail.Message, error) {
+	w, err := msg.exportWriter()
+	if err != nil {
+		return nil, err
+	}
+
+	return w.export(), nil
+}
+
+// exportWriter writes the message to a new messageWriter. The caller owns the
+// writer and can return it to the pool with putMessageWriter once the message
+// is not used anymore. Only the boundaries returned by Boundaries are stored in
+// msg, atomically, so that a message can be exported by several goroutines at
+// the same time.
+func (msg *Message) exportWriter() (*messageWriter, error) {
 	w := newMessageWriter(msg)
+	if msg.maxSize > 0 {
+		w.out = &countWriter{w: w.buf, limit: msg.maxSize}
+	}
+
+	if err := msg.writeMessage(w); err != nil {
+		putMessageWriter(w)
+		return nil, err
+	}
+	if msg.preSendHook != nil {
+		if err := msg.preSendHook(w.header, w.buf.Bytes()); err != nil {
+			putMessageWriter(w)
+			return nil, err
+		}
+	}
+
+	return w, nil
+}
+
+// writeMessage writes the body of the message to w, signing and encrypting it
+// if needed. The message is validated first if it was created with
+// SetExportValidation(true).
+func (msg *Message) writeMessage(w *messageWriter) error {
+	if msg.validate {
+		if err := msg.Validate(); err != nil {
+			return err
+		}
+	}
+	if err := msg.checkContentIDs(); err != nil {
+		return err
+	}
+
+	if msg.recipients != nil {
+		if err := w.writeEncrypted(msg); err != nil {
+			return err
+		}
+	} else if msg.signer != nil {
+		if err := w.writeSigned(msg, msg.signer); err != nil {
+			return err
+		}
+	} else {
+		msg.writeContent(w)
+	}
+	if w.err == nil {
+		msg.usedBoundaries.Store(append([]string(nil), w.used...))
+	}
+
+	return w.err
+}
+
+// writeContent writes the content of the message to w, inside a
+// multipart/report if the message is a delivery report.
+func (msg *Message) writeContent(w *messageWriter) {
+	if msg.report == nil {
+		msg.writeParts(w)
+		return
+	}
+
+	w.openMultipart("report; report-type=delivery-status")
+	msg.writeParts(w)
+	msg.writeReport(w)
+	w.closeMultipart()
+}
+
+// writeParts writes the parts, embedded files and attachments of the message
+// to w.
+func (msg *Message) writeParts(w *messageWriter) {
+	if len(msg.parts)+len(msg.mixedParts)+len(msg.embedded)+len(msg.attachments) == 0 {
+		msg.writeEmptyBody(w)
+		return
+	}
 
 	if msg.hasMixedPart() {
 		w.openMultipart("mixed")
@@ -26,73 +267,395 @@ func (msg *Message) Export() *mail.Message {
 	if msg.hasAlternativePart() {
 		w.openMultipart("alternative")
 	}
-	for _, part := range msg.parts {
-		h := make(map[string][]string)
-		h["Mime-Version"] = []string{"1.0"}
-		h["Content-Type"] = []string{part.contentType + "; charset=" + msg.charset}
-		h["Content-Transfer-Encoding"] = []string{string(msg.encoding)}
-
-		w.write(h, part.body.Bytes(), msg.encoding)
+	cids := msg.cidReplacer()
+	for _, p := range msg.parts {
+		msg.writePart(w, cids, p)
 	}
 	if msg.hasAlternativePart() {
 		w.closeMultipart()
 	}
 
-	w.addFiles(msg.embedded, false)
+	w.addFiles(msg.embedded, false, msg.hEncoder)
 	if msg.hasRelatedPart() {
 		w.closeMultipart()
 	}
 
-	w.addFiles(msg.attachments, true)
+	for _, p := range msg.mixedParts {
+		msg.writePart(w, cids, p)
+	}
+
+	w.addFiles(msg.attachments, true, msg.hEncoder)
 	if msg.hasMixedPart() {
 		w.closeMultipart()
 	}
-	msg.msgWriter = w
+}
+
+// writeEmptyBody writes an empty text/plain body so that a message without
+// content, such as a notification only made of header fields, is still a
+// valid MIME message. A Content-Type set in the header of the message is kept.
+func (msg *Message) writeEmptyBody(w *messageWriter) {
+	if _, ok := w.header["Content-Type"]; ok && w.depth == 0 {
+		return
+	}
+
+	h := make(map[string][]string)
+	h["Content-Type"] = []string{"text/plain; charset=" + msg.charset}
+	h["Content-Transfer-Encoding"] = []string{string(SevenBit)}
+	w.write(h, nil, SevenBit)
+}
+
+// writePart writes p to w with the references to embedded files rewritten by
+// cids.
+func (msg *Message) writePart(w *messageWriter, cids *strings.Replacer, p part) {
+	if p.raw {
+		h := make(map[string][]string, len(p.header))
+		for field, values := range p.header {
+			h[field] = values
+		}
+		w.write(h, p.body.Bytes(), Binary)
+		return
+	}
+
+	enc := msg.encoding
+	if p.encoding != "" {
+		enc = p.encoding
+	}
+	body, stream, err := partBody(cids, p)
+	if err != nil {
+		w.setErr(err)
+		return
+	}
+	write := p.write
+	if msg.transcode && isText(p.contentType) {
+		cenc, err := msg.charsetEncoder(msg.partCharset(p))
+		if err != nil {
+			w.setErr(err)
+			return
+		}
+		if cenc != nil && stream {
+			write = transcodeFunc(cenc, write)
+		} else if cenc != nil {
+			if body, err = transcodeBytes(cenc, body); err != nil {
+				w.setErr(err)
+				return
+			}
+		}
+	}
+	if msg.normalizeCRLF && isText(p.contentType) {
+		if stream {
+			write = crlfFunc(write)
+		} else {
+			body = normalizeCRLF(body)
+		}
+	}
+	enc = msg.resolveEncoding(enc, body, stream)
+	h := make(map[string][]string)
+	for field, values := range p.header {
+		h[field] = make([]string, len(values))
+		for i, v := range values {
+			h[field][i] = foldHeader(field, v)
+		}
+	}
+	h["Mime-Version"] = []string{"1.0"}
+	h["Content-Type"] = []string{msg.partContentType(p)}
+	h["Content-Transfer-Encoding"] = []string{transferEncoding(enc)}
 
-	return w.export()
+	if stream {
+		w.writeHeader(h)
+		w.writeFuncBody(write, enc)
+	} else {
+		w.write(h, body, enc)
+	}
+}
+
+// partBody returns the body of p with the references to embedded files
+// rewritten by r. If the body is written by a function that can be streamed,
+// partBody returns true instead.
+func partBody(r *strings.Replacer, p part) (body []byte, stream bool, err error) {
+	if p.write == nil {
+		return rewriteCIDs(r, p.contentType, p.body.Bytes()), false, nil
+	}
+	if r == nil || !isHTML(p.contentType) {
+		return nil, true, nil
+	}
+
+	// The references can only be rewritten in the whole body.
+	buf := new(bytes.Buffer)
+	if err := p.write(buf); err != nil {
+		return nil, false, err
+	}
+
+	return rewriteCIDs(r, p.contentType, buf.Bytes()), false, nil
+}
+
+// partContentType returns the Content-Type header value of p. The charset is
+// only added to text content types that do not already have one.
+func (msg *Message) partContentType(p part) string {
+	contentType := p.contentType
+	_, params, err := mime.ParseMediaType(p.contentType)
+	_, hasCharset := params["charset"]
+	if (err != nil || !hasCharset) && isText(p.contentType) {
+		charset := msg.charset
+		if p.charset != "" {
+			charset = p.charset
+		}
+		contentType += "; charset=" + charset
+	}
+
+	for _, param := range p.params {
+		contentType += "; " + stripNewlines(param.name) + "=" + paramValue(param.value)
+	}
+
+	return contentType
+}
+
+func isText(contentType string) bool {
+	return len(contentType) >= 5 && strings.EqualFold(contentType[:5], "text/")
+}
+
+// paramValue returns s as a parameter value, quoted only if it is not a valid
+// token as defined in RFC 2045.
+func paramValue(s string) string {
+	if s == "" || strings.IndexFunc(s, isNotTokenChar) != -1 {
+		return quotedParam(s)
+	}
+
+	return s
+}
+
+func isNotTokenChar(r rune) bool {
+	return r <= ' ' || r >= 0x7f || strings.ContainsRune("()<>@,;:\\\"/[]?=", r)
 }
 
 // Reset resets all state in Message and returns all used buffers to the pool.
 // The initial settings used to create the instance are preserved so the
-// instance can be safely reused to create a new message.
+// instance can be safely reused to create a new message. Reset must not be
+// called while the message is exported or sent.
 func (msg *Message) Reset() {
-	for _, part := range msg.parts {
-		putBuffer(part.body)
+	for _, parts := range [][]part{msg.parts, msg.mixedParts} {
+		for _, part := range parts {
+			if part.body != nil {
+				putBuffer(part.body)
+			}
+		}
 	}
 	msg.parts = nil
-	if msg.msgWriter != nil {
-		putBuffer(msg.msgWriter.buf)
-		msg.msgWriter = nil
-	}
+	msg.mixedParts = nil
 	msg.header = make(header)
 	msg.attachments = nil
 	msg.embedded = nil
+	msg.signer = nil
+	msg.recipients = nil
+	msg.multipart = 0
+	msg.report = nil
+	msg.envelopeFrom = ""
+}
+
+// The MIME structure of a message is, when every kind of part is present:
+//
+//	multipart/mixed
+//	├── multipart/related
+//	│   ├── multipart/alternative
+//	│   │   ├── text/plain
+//	│   │   └── text/html
+//	│   └── embedded files
+//	├── parts added with AddPart
+//	└── attachments
+//
+// A multipart is only used when it contains more than one part so that the
+// embedded files are always siblings of the parts referencing them.
+
+// A Multipart is a set of multipart types wrapping the content of a message.
+type Multipart uint8
+
+const (
+	// MultipartMixed wraps the content and the attachments.
+	MultipartMixed Multipart = 1 << iota
+	// MultipartRelated wraps the bodies and the embedded files.
+	MultipartRelated
+	// MultipartAlternative wraps the bodies.
+	MultipartAlternative
+)
+
+// SetMultipartStructure forces the given multipart types to be used even when
+// they would only contain a single part. The other multipart types are still
+// used when needed. The nesting order is always the one described above.
+//
+// Example:
+//
+//	// A single image referenced by its Content-ID
+//	msg.Embed(f)
+//	msg.SetMultipartStructure(gomail.MultipartRelated)
+func (msg *Message) SetMultipartStructure(m Multipart) {
+	msg.multipart = m
+}
+
+// SetBoundaries sets the boundaries of the multipart parts instead of random
+// ones. They are used in the order the multipart parts appear in the message,
+// outermost first, and the parts beyond the given boundaries get random ones.
+// The boundaries of an encrypted content are always random.
+//
+// Example:
+//
+//	// Deterministic output, for example in golden tests
+//	if err := msg.SetBoundaries("mixed-boundary", "alternative-boundary"); err != nil {
+//		panic(err)
+//	}
+func (msg *Message) SetBoundaries(boundaries ...string) error {
+	for i, b := range boundaries {
+		if err := patchedMulipart.NewWriter(io.Discard).SetBoundary(b); err != nil {
+			return fmt.Errorf("gomail: invalid boundary %q", b)
+		}
+		for _, prev := range boundaries[:i] {
+			if prev == b {
+				return fmt.Errorf("gomail: duplicate boundary %q", b)
+			}
+		}
+	}
+	msg.boundaries = append([]string(nil), boundaries...)
+
+	return nil
+}
+
+// Boundaries returns the boundaries of the multipart parts of the most recent
+// export of the message, outermost first, in the order they appear in the
+// message. It returns nil if the message has not been exported yet or has no
+// multipart parts. If the message is exported by several goroutines at the
+// same time, the boundaries of any of these exports are returned.
+//
+// Example:
+//
+//	msg.WriteTo(w)
+//	for _, b := range msg.Boundaries() {
+//		fmt.Println(b)
+//	}
+func (msg *Message) Boundaries() []string {
+	b, _ := msg.usedBoundaries.Load().([]string)
+	if len(b) == 0 {
+		return nil
+	}
+
+	return append([]string(nil), b...)
+}
+
+// SetPartHook sets a function called with the header of each part right before
+// it is written, so that it can add, replace or remove fields in h. The parts
+// are presented in the order they are written: a multipart part comes before
+// the parts it contains and the top-level part, whose fields are merged into
+// the header of the message, comes first. The content of a signed or encrypted
+// message is presented before the parts wrapping it, since it is rendered
+// first. A nil hook removes the previous one.
+//
+// Example:
+//
+//	msg.SetPartHook(func(h map[string][]string) {
+//		h["X-Scanned"] = []string{"yes"}
+//	})
+func (msg *Message) SetPartHook(hook func(h map[string][]string)) {
+	msg.partHook = hook
 }
 
 func (msg *Message) hasMixedPart() bool {
-	return (len(msg.parts) > 0 && len(msg.attachments) > 0) || len(msg.attachments) > 1
+	mixed := len(msg.mixedParts) + len(msg.attachments)
+	return msg.multipart&MultipartMixed != 0 ||
+		mixed > 0 && len(msg.parts)+len(msg.embedded)+mixed > 1
 }
 
 func (msg *Message) hasRelatedPart() bool {
-	return (len(msg.parts) > 0 && len(msg.embedded) > 0) || len(msg.embedded) > 1
+	return msg.multipart&MultipartRelated != 0 ||
+		len(msg.embedded) > 0 && len(msg.parts)+len(msg.embedded) > 1
 }
 
 func (msg *Message) hasAlternativePart() bool {
-	return len(msg.parts) > 1
+	return msg.multipart&MultipartAlternative != 0 || len(msg.parts) > 1
 }
 
 // messageWriter helps converting the message into a net/mail.Message
 type messageWriter struct {
-	header     map[string][]string
-	buf        *bytes.Buffer
+	header map[string][]string
+	buf    *bytes.Buffer
+	// out is where the body is written, buf unless the message is streamed.
+	out        io.Writer
 	writers    [3]*patchedMulipart.Writer
 	partWriter io.Writer
 	depth      uint8
+	err        error
+	// contentLength is true if the parts have a Content-Length header field.
+	// The header of the current part is then kept in pending until its body
+	// is encoded.
+	contentLength bool
+	pending       map[string][]string
+	// unwrappedBase64 is true if base64 bodies are written on a single line.
+	unwrappedBase64 bool
+	// normalizeCRLF is true if the line breaks of text files are normalized.
+	normalizeCRLF bool
+	// boundaries are the boundaries set with SetBoundaries and used lists the
+	// boundaries of the multipart parts opened so far.
+	boundaries []string
+	used       []string
+	// partHook is called with the header of each part, see SetPartHook.
+	partHook func(h map[string][]string)
+	// qpThreshold is the quoted-printable threshold of the message, used for
+	// the files encoded with AutoQuotedPrintable.
+	qpThreshold float64
+}
+
+var writerPool = sync.Pool{
+	New: func() interface{} {
+		return &messageWriter{header: make(map[string][]string)}
+	},
+}
+
+// getMessageWriter returns an empty messageWriter from the pool.
+func getMessageWriter() *messageWriter {
+	w := writerPool.Get().(*messageWriter)
+	w.buf = getBuffer()
+	w.out = w.buf
+
+	return w
+}
+
+// putMessageWriter returns w and its buffer to their pools. The header map is
+// cleared but kept so that its capacity is reused.
+func putMessageWriter(w *messageWriter) {
+	putBuffer(w.buf)
+	for k := range w.header {
+		delete(w.header, k)
+	}
+	w.buf = nil
+	w.out = nil
+	w.writers = [3]*patchedMulipart.Writer{}
+	w.partWriter = nil
+	w.depth = 0
+	w.err = nil
+	w.contentLength = false
+	w.pending = nil
+	w.unwrappedBase64 = false
+	w.normalizeCRLF = false
+	w.boundaries = nil
+	w.used = w.used[:0]
+	w.partHook = nil
+	w.qpThreshold = 0
+	writerPool.Put(w)
+}
+
+// newInnerWriter returns a writer for the content wrapped by w, such as the
+// content of a signed or encrypted message, with the settings of w.
+func (w *messageWriter) newInnerWriter() *messageWriter {
+	inner := getMessageWriter()
+	inner.contentLength = w.contentLength
+	inner.unwrappedBase64 = w.unwrappedBase64
+	inner.normalizeCRLF = w.normalizeCRLF
+	inner.partHook = w.partHook
+	inner.qpThreshold = w.qpThreshold
+
+	return inner
 }
 
 func newMessageWriter(msg *Message) *messageWriter {
+	w := getMessageWriter()
 	// We copy the header so Export does not modify the message
-	header := make(map[string][]string, len(msg.header)+2)
+	header := w.header
 	for k, v := range msg.header {
 		header[k] = v
 	}
@@ -100,62 +663,387 @@ func newMessageWriter(msg *Message) *messageWriter {
 	if _, ok := header["Mime-Version"]; !ok {
 		header["Mime-Version"] = []string{"1.0"}
 	}
-	if _, ok := header["Date"]; !ok {
-		header["Date"] = []string{msg.FormatDate(now())}
+	if _, ok := header["Date"]; !ok && !msg.noAutoDate {
+		header["Date"] = []string{msg.FormatDate(msg.currentTime())}
 	}
+	w.contentLength = msg.contentLength
+	w.unwrappedBase64 = msg.unwrappedBase64
+	w.normalizeCRLF = msg.normalizeCRLF
+	w.boundaries = msg.boundaries
+	w.partHook = msg.partHook
+	w.qpThreshold = msg.qpThreshold
 
-	return &messageWriter{header: header, buf: getBuffer()}
+	return w
 }
 
 // Stubbed out for testing.
 var now = time.Now
 
 func (w *messageWriter) openMultipart(mimeType string) {
-	w.writers[w.depth] = patchedMulipart.NewWriter(w.buf)
-	contentType := "multipart/" + mimeType + "; boundary=" + w.writers[w.depth].Boundary()
+	w.writers[w.depth] = patchedMulipart.NewWriter(w.out)
+	if n := len(w.used); n < len(w.boundaries) {
+		w.setErr(w.writers[w.depth].SetBoundary(w.boundaries[n]))
+	}
+	w.used = append(w.used, w.writers[w.depth].Boundary())
+	h := make(map[string][]string)
+	h["Content-Type"] = []string{"multipart/" + mimeType + "; boundary=" + w.writers[w.depth].Boundary()}
 
 	if w.depth == 0 {
-		w.header["Content-Type"] = []string{contentType}
+		w.writeHeader(h)
 	} else {
-		h := make(map[string][]string)
-		h["Content-Type"] = []string{contentType}
 		w.createPart(h)
 	}
 	w.depth++
 }
 
 func (w *messageWriter) createPart(h map[string][]string) {
-	// No need to check the error since the underlying writer is a bytes.Buffer
-	w.partWriter, _ = w.writers[w.depth-1].CreatePart(h)
+	if w.partHook != nil {
+		w.partHook(h)
+	}
+	var err error
+	w.partWriter, err = w.writers[w.depth-1].CreatePart(h)
+	w.setErr(err)
 }
 
 func (w *messageWriter) closeMultipart() {
 	if w.depth > 0 {
-		w.writers[w.depth-1].Close()
+		w.setErr(w.writers[w.depth-1].Close())
 		w.depth--
 	}
 }
 
-func (w *messageWriter) addFiles(files []*File, isAttachment bool) {
+// setErr keeps err in w.err unless an error was already kept. Writing goes on
+// after an error but its result is discarded.
+func (w *messageWriter) setErr(err error) {
+	if w.err == nil {
+		w.err = err
+	}
+}
+
+func (w *messageWriter) addFiles(files []*File, isAttachment bool, hEnc *quotedprintable.HeaderEncoder) {
 	for _, f := range files {
+		content := f.Content
+		normalize := w.normalizeCRLF && isText(f.MimeType)
+		if f.crlfSet {
+			normalize = f.normalizeCRLF
+		}
+		if normalize && !f.isStream() {
+			content = normalizeCRLF(content)
+		}
+		var enc Encoding
+		if f.encoding == AutoQuotedPrintable {
+			enc = textEncoding(content, f.isStream(), w.qpThreshold)
+		} else {
+			enc = resolveEncoding(f.encoding, content, f.isStream())
+		}
 		h := make(map[string][]string)
-		h["Content-Type"] = []string{f.MimeType + "; name=\"" + f.Name + "\""}
-		// as per the SetEncoding method in gomail.go, we are enforcing the encoding to be either
-		// Base64, or Base64PreEncoded
-		h["Content-Transfer-Encoding"] = []string{string(Base64)}
+		h["Content-Type"] = []string{withFileName("Content-Type", stripNewlines(f.MimeType), "name", f.Name)}
+		h["Content-Transfer-Encoding"] = []string{transferEncoding(enc)}
+		disposition := "inline"
 		if isAttachment {
-			h["Content-Disposition"] = []string{"attachment; filename=\"" + f.Name + "\""}
+			disposition = "attachment"
+		}
+		if f.disposition != "" {
+			disposition = f.disposition
+		}
+		h["Content-Disposition"] = []string{withFileName("Content-Disposition", disposition, "filename", f.Name) + dispositionParams(f)}
+		if !isAttachment || f.ContentID != "" && disposition == "inline" {
+			h["Content-ID"] = []string{"<" + contentID(f) + ">"}
+		}
+		if f.description != "" {
+			desc := encodeHeader(hEnc, stripNewlines(f.description))
+			h["Content-Description"] = []string{foldHeader("Content-Description", desc)}
+		}
+		for field, values := range f.header {
+			if fileFields[field] {
+				continue
+			}
+			h[field] = make([]string, len(values))
+			for i, v := range values {
+				h[field][i] = foldHeader(field, encodeHeader(hEnc, stripNewlines(v)))
+			}
+		}
+
+		switch {
+		case normalize && f.isStream():
+			w.writeHeader(h)
+			w.writeFuncBody(crlfFunc(f.copyContent), enc)
+		case f.open != nil:
+			w.writeHeader(h)
+			w.copyFile(f, enc)
+		case f.reader != nil:
+			w.writeHeader(h)
+			w.copyBody(f.reader, enc)
+		default:
+			w.write(h, content, enc)
+		}
+	}
+}
+
+// fileFields are the header fields of a file part that are set from the File
+// and cannot be set with SetFileHeader.
+var fileFields = map[string]bool{
+	"Content-Type":              true,
+	"Content-Transfer-Encoding": true,
+	"Content-Disposition":       true,
+	"Content-Id":                true,
+}
+
+// foldHeader folds the value of a header field at its spaces so that its lines
+// do not exceed maxHeaderLineLen characters when possible. Encoded-words are
+// separated by spaces so they are folded too.
+func foldHeader(field, value string) string {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	writeFolded(buf, value, len(field)+1, false)
+
+	// The multipart writer adds its own space after the colon.
+	return buf.String()[1:]
+}
+
+// dispositionParams returns the optional parameters of the Content-Disposition
+// header field of f that were set.
+func dispositionParams(f *File) string {
+	var params string
+	if f.hasSize {
+		params += "; size=" + strconv.FormatInt(f.size, 10)
+	}
+	if !f.creationDate.IsZero() {
+		params += "; creation-date=\"" + f.creationDate.Format(time.RFC1123Z) + "\""
+	}
+	if !f.modDate.IsZero() {
+		params += "; modification-date=\"" + f.modDate.Format(time.RFC1123Z) + "\""
+	}
+
+	return params
+}
+
+// withFileName returns the value of the header field named field made of value
+// followed by the param parameter set to name. Long and non-ASCII names are
+// written as defined in RFC 2231, split into several parameters if needed, and
+// the header field is folded so that its lines do not exceed maxHeaderLineLen
+// characters.
+func withFileName(field, value, param, name string) string {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	buf.WriteString(value)
+	lineLen := len(field) + 2 + len(value)
+	for _, p := range nameParams(param, stripNewlines(name)) {
+		buf.WriteByte(';')
+		lineLen++
+		if lineLen+1+len(p) > maxHeaderLineLen {
+			buf.WriteString("\r\n")
+			lineLen = 0
+		}
+		buf.WriteByte(' ')
+		buf.WriteString(p)
+		lineLen += 1 + len(p)
+	}
+
+	return buf.String()
+}
+
+// maxParamLen is the maximum length of a parameter written on its own line,
+// between the folding space and the semicolon.
+const maxParamLen = maxHeaderLineLen - 2
+
+// nameParams returns the parameters setting param to name: a single quoted
+// parameter when possible, parameter value continuations as defined in
+// RFC 2231, 3. when it would be too long and a charset as defined in
+// RFC 2231, 4. when name is not printable ASCII.
+func nameParams(param, name string) []string {
+	var units []string
+	if isPrintableASCII(name) {
+		p := param + "=" + quotedParam(name)
+		if len(p) <= maxParamLen {
+			return []string{p}
+		}
+		for i := 0; i < len(name); i++ {
+			if name[i] == '\\' || name[i] == '"' {
+				units = append(units, "\\"+name[i:i+1])
+			} else {
+				units = append(units, name[i:i+1])
+			}
+		}
+		return splitParam(units, func(i int) string {
+			return param + "*" + strconv.Itoa(i) + "=\""
+		}, "\"")
+	}
+
+	for i := 0; i < len(name); i++ {
+		if isAttributeChar(name[i]) {
+			units = append(units, name[i:i+1])
 		} else {
-			h["Content-Disposition"] = []string{"inline; filename=\"" + f.Name + "\""}
-			if f.ContentID != "" {
-				h["Content-ID"] = []string{"<" + f.ContentID + ">"}
+			units = append(units, fmt.Sprintf("%%%02X", name[i]))
+		}
+	}
+	if p := param + "*=UTF-8''" + strings.Join(units, ""); len(p) <= maxParamLen {
+		return []string{p}
+	}
+
+	return splitParam(units, func(i int) string {
+		if i == 0 {
+			return param + "*0*=UTF-8''"
+		}
+		return param + "*" + strconv.Itoa(i) + "*="
+	}, "")
+}
+
+// splitParam returns the parameters whose values are made of units, each one
+// starting with prefix(i) and ending with suffix, so that they are at most
+// maxParamLen characters long.
+func splitParam(units []string, prefix func(i int) string, suffix string) []string {
+	var params []string
+	p := prefix(0)
+	for _, u := range units {
+		if len(p)+len(u)+len(suffix) > maxParamLen {
+			params = append(params, p+suffix)
+			p = prefix(len(params))
+		}
+		p += u
+	}
+
+	return append(params, p+suffix)
+}
+
+func isPrintableASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < ' ' || s[i] >= 0x7f {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isAttributeChar reports whether c can be written as is in an extended
+// parameter value, as defined in RFC 2231, 7.
+func isAttributeChar(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		strings.IndexByte("!#$&+-.^_`|~", c) != -1
+}
+
+// quotedParam returns s as a quoted-string, as defined in RFC 2045, that can be
+// used as a parameter value in a header.
+func quotedParam(s string) string {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	quote(buf, stripNewlines(s))
+
+	return buf.String()
+}
+
+var newlineStripper = strings.NewReplacer("\r", "", "\n", "")
+
+// stripNewlines removes CR and LF characters from s so that it cannot be used
+// to inject header fields.
+func stripNewlines(s string) string {
+	return newlineStripper.Replace(s)
+}
+
+// bodyExtension returns the SMTP extension needed to send the message, if any:
+// BINARYMIME if a part is in binary and 8BITMIME if a part is in 8bit and is
+// not only made of ASCII characters. The content of readers cannot be checked
+// so it is assumed to need the extension.
+func (msg *Message) bodyExtension() string {
+	ext := ""
+	check := func(enc Encoding, content []byte, isReader bool) {
+		enc = msg.resolveEncoding(enc, content, isReader)
+		switch {
+		case enc == Binary:
+			ext = "BINARYMIME"
+		case enc == Unencoded && ext == "" && (isReader || bytes.IndexFunc(content, isNotASCII) != -1):
+			ext = "8BITMIME"
+		}
+	}
+
+	for _, parts := range [][]part{msg.parts, msg.mixedParts} {
+		for _, p := range parts {
+			enc := msg.encoding
+			if p.encoding != "" {
+				enc = p.encoding
+			}
+			if p.write != nil {
+				check(enc, nil, true)
 			} else {
-				h["Content-ID"] = []string{"<" + f.Name + ">"}
+				check(enc, p.body.Bytes(), false)
+			}
+		}
+	}
+	for _, files := range [][]*File{msg.embedded, msg.attachments} {
+		for _, f := range files {
+			check(f.encoding, f.Content, f.isStream())
+		}
+	}
+	if msg.report != nil {
+		check(AutoEncoding, msg.report.Returned, false)
+	}
+
+	return ext
+}
+
+func isNotASCII(r rune) bool {
+	return r >= utf8.RuneSelf
+}
+
+// resolveEncoding is like the resolveEncoding function but it uses the
+// quoted-printable threshold of the message.
+func (msg *Message) resolveEncoding(enc Encoding, content []byte, isReader bool) Encoding {
+	if enc == AutoQuotedPrintable {
+		return textEncoding(content, isReader, msg.qpThreshold)
+	}
+
+	return resolveEncoding(enc, content, isReader)
+}
+
+// resolveEncoding returns the encoding used for content when enc is
+// AutoEncoding or AutoQuotedPrintable. The content of readers cannot be
+// checked so EightBit or QuotedPrintable is used.
+func resolveEncoding(enc Encoding, content []byte, isReader bool) Encoding {
+	switch enc {
+	case AutoEncoding:
+		if !isReader {
+			if _, err := check7bit(content, 0); err == nil {
+				return SevenBit
 			}
 		}
+		return EightBit
+	case AutoQuotedPrintable:
+		return textEncoding(content, isReader, defaultQPThreshold)
+	}
+
+	return enc
+}
+
+// textEncoding returns Base64 if the ratio of the bytes of content that
+// quoted-printable would escape is above threshold and QuotedPrintable
+// otherwise.
+func textEncoding(content []byte, isReader bool, threshold float64) Encoding {
+	if isReader || len(content) == 0 {
+		return QuotedPrintable
+	}
+
+	escaped := 0
+	for _, c := range content {
+		if c >= utf8.RuneSelf || c == '=' || c == 0x7f || (c < ' ' && c != '\r' && c != '\n' && c != '\t') {
+			escaped++
+		}
+	}
+	if float64(escaped)/float64(len(content)) > threshold {
+		return Base64
+	}
+
+	return QuotedPrintable
+}
 
-		w.write(h, f.Content, f.encoding)
+// transferEncoding returns the Content-Transfer-Encoding header value matching
+// enc.
+func transferEncoding(enc Encoding) string {
+	if enc == Base64PreEncoded {
+		return string(Base64)
 	}
+
+	return string(enc)
 }
 
 func (w *messageWriter) write(h map[string][]string, body []byte, enc Encoding) {
@@ -165,46 +1053,237 @@ func (w *messageWriter) write(h map[string][]string, body []byte, enc Encoding)
 
 func (w *messageWriter) writeHeader(h map[string][]string) {
 	if w.depth == 0 {
+		if w.partHook != nil {
+			w.partHook(h)
+		}
 		for field, value := range h {
 			w.header[field] = value
 		}
+	} else if w.contentLength {
+		w.pending = h
 	} else {
 		w.createPart(h)
 	}
 }
 
 func (w *messageWriter) writeBody(body []byte, enc Encoding) {
-	var subWriter io.Writer
+	if w.err != nil {
+		return
+	}
+
+	writer := w.bodyWriter(enc)
+	_, err := writer.Write(body)
+	w.setErr(err)
+	w.setErr(writer.Close())
+}
+
+// writeFuncBody writes the body of the current part with f.
+func (w *messageWriter) writeFuncBody(f func(io.Writer) error, enc Encoding) {
+	if w.err != nil {
+		return
+	}
+
+	writer := w.bodyWriter(enc)
+	w.setErr(f(writer))
+	w.setErr(writer.Close())
+}
+
+// copyBody writes the content read from r as the body of the current part.
+func (w *messageWriter) copyBody(r io.Reader, enc Encoding) {
+	if w.err != nil {
+		return
+	}
+
+	writer := w.bodyWriter(enc)
+	_, err := io.Copy(writer, r)
+	w.setErr(err)
+	w.setErr(writer.Close())
+}
+
+// copyFile opens the content of f and copies it to the body of the current
+// part.
+func (w *messageWriter) copyFile(f *File, enc Encoding) {
+	if w.err != nil {
+		return
+	}
+
+	r, err := f.open()
+	if err != nil {
+		w.setErr(err)
+		return
+	}
+	w.copyBody(r, enc)
+	w.setErr(r.Close())
+}
+
+// copyContent copies the content of f, which is read at export time, to dst.
+func (f *File) copyContent(dst io.Writer) error {
+	r := f.reader
+	if f.open != nil {
+		rc, err := f.open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		r = rc
+	}
+	_, err := io.Copy(dst, r)
+
+	return err
+}
+
+// bodyWriter returns a writer encoding what is written to it in the body of
+// the current part. It must be closed once the body is written.
+func (w *messageWriter) bodyWriter(enc Encoding) io.WriteCloser {
+	if w.pending != nil {
+		buf := getBuffer()
+		return &bufferedPart{WriteCloser: w.newEncoder(enc, buf), w: w, buf: buf}
+	}
 	if w.depth == 0 {
-		subWriter = w.buf
-	} else {
-		subWriter = w.partWriter
-	}
-
-	// The errors returned by writers are not checked since these writers cannot
-	// return errors.
-	if enc == Base64 {
-		writer := base64.NewEncoder(base64.StdEncoding, newBase64LineWriter(subWriter))
-		writer.Write(body)
-		writer.Close()
-	} else if enc == Base64PreEncoded {
-		newBase64LineWriter(subWriter).Write(body)
-	} else if enc == Unencoded {
-		subWriter.Write(body)
-	} else {
-		writer := quotedprintable.NewEncoder(newQpLineWriter(subWriter))
-		writer.Write(body)
+		return w.newEncoder(enc, w.out)
+	}
+
+	return w.newEncoder(enc, w.partWriter)
+}
+
+// newEncoder returns a writer encoding what is written to it with enc and
+// writing the result to out. It must be closed once everything is written.
+func (w *messageWriter) newEncoder(enc Encoding, out io.Writer) io.WriteCloser {
+	switch enc {
+	case Base64:
+		if w.unwrappedBase64 {
+			return base64.NewEncoder(base64.StdEncoding, out)
+		}
+		return base64.NewEncoder(base64.StdEncoding, newBase64LineWriter(out))
+	case Base64PreEncoded:
+		if w.unwrappedBase64 {
+			return nopCloser{spaceStripper{out}}
+		}
+		return nopCloser{spaceStripper{newBase64LineWriter(out)}}
+	case Unencoded:
+		return nopCloser{&eightBitLineWriter{w: out}}
+	case Binary:
+		return nopCloser{out}
+	case SevenBit:
+		return nopCloser{&sevenBitWriter{w: out}}
+	default:
+		sw := &qpSpaceWriter{w: newQpLineWriter(out)}
+		return qpWriter{quotedprintable.NewEncoder(sw), sw}
+	}
+}
+
+// qpWriter is a quoted-printable encoder whose Close writes the spaces ending
+// the text.
+type qpWriter struct {
+	io.Writer
+	spaces *qpSpaceWriter
+}
+
+func (w qpWriter) Close() error {
+	return w.spaces.Close()
+}
+
+// bufferedPart buffers the encoded body of a part so that the part can be
+// created with a Content-Length header field when it is closed.
+type bufferedPart struct {
+	io.WriteCloser
+	w   *messageWriter
+	buf *bytes.Buffer
+}
+
+func (p *bufferedPart) Close() error {
+	defer putBuffer(p.buf)
+	if err := p.WriteCloser.Close(); err != nil {
+		return err
 	}
+
+	h := p.w.pending
+	p.w.pending = nil
+	h["Content-Length"] = []string{strconv.Itoa(p.buf.Len())}
+	p.w.createPart(h)
+	if p.w.err != nil {
+		return nil
+	}
+	_, err := p.w.partWriter.Write(p.buf.Bytes())
+
+	return err
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
 }
 
 func (w *messageWriter) export() *mail.Message {
 	return &mail.Message{Header: w.header, Body: w.buf}
 }
 
+// countWriter counts the bytes written to w and keeps the first error returned
+// by w. Once w failed, nothing more is written to it. If limit is positive, at
+// most limit bytes are written and ErrMessageTooLarge is returned afterwards.
+type countWriter struct {
+	w     io.Writer
+	n     int64
+	limit int64
+	err   error
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	tooLarge := w.limit > 0 && w.n+int64(len(p)) > w.limit
+	if tooLarge {
+		p = p[:w.limit-w.n]
+	}
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	if err == nil && tooLarge {
+		err = ErrMessageTooLarge
+	}
+	w.err = err
+
+	return n, err
+}
+
+// headerWriter writes the header fields of a streamed message before the first
+// byte of its body since they cannot change once the body is being written.
+type headerWriter struct {
+	w      io.Writer
+	header map[string][]string
+	order  []string
+	done   bool
+}
+
+func (w *headerWriter) Write(p []byte) (int, error) {
+	if !w.done {
+		if err := w.flush(); err != nil {
+			return 0, err
+		}
+	}
+
+	return w.w.Write(p)
+}
+
+func (w *headerWriter) flush() error {
+	w.done = true
+	_, err := w.w.Write(flattenHeader(&mail.Message{Header: w.header}, "", w.order))
+
+	return err
+}
+
 // As required by RFC 2045, 6.7. (page 21) for quoted-printable, and
 // RFC 2045, 6.8. (page 25) for base64.
 const maxLineLen = 76
 
+var (
+	crlf        = []byte("\r\n")
+	qpSoftBreak = []byte("=\r\n")
+)
+
 // base64LineWriter limits text encoded in base64 to 76 characters per line
 type base64LineWriter struct {
 	w       io.Writer
@@ -217,18 +1296,257 @@ func newBase64LineWriter(w io.Writer) *base64LineWriter {
 
 func (w *base64LineWriter) Write(p []byte) (int, error) {
 	n := 0
-	for len(p)+w.lineLen > maxLineLen {
-		w.w.Write(p[:maxLineLen-w.lineLen])
-		w.w.Write([]byte("\r\n"))
-		p = p[maxLineLen-w.lineLen:]
-		n += maxLineLen - w.lineLen
-		w.lineLen = 0
+	for len(p) > 0 {
+		// The line break is only written before the next byte so that the
+		// output never ends with an empty line, however p is split.
+		if w.lineLen == maxLineLen {
+			if _, err := w.w.Write(crlf); err != nil {
+				return n, err
+			}
+			w.lineLen = 0
+		}
+
+		toWrite := maxLineLen - w.lineLen
+		if toWrite > len(p) {
+			toWrite = len(p)
+		}
+		m, err := w.w.Write(p[:toWrite])
+		n += m
+		w.lineLen += m
+		if err != nil {
+			return n, err
+		}
+		p = p[toWrite:]
+	}
+
+	return n, nil
+}
+
+// spaceStripper removes the white space and line breaks written to w so that
+// base64 encoded content that is already wrapped can be wrapped again.
+type spaceStripper struct {
+	w io.Writer
+}
+
+func (s spaceStripper) Write(p []byte) (int, error) {
+	start := 0
+	for i, c := range p {
+		if c != ' ' && c != '\t' && c != '\r' && c != '\n' {
+			continue
+		}
+		if start < i {
+			if _, err := s.w.Write(p[start:i]); err != nil {
+				return start, err
+			}
+		}
+		start = i + 1
+	}
+	if start < len(p) {
+		if _, err := s.w.Write(p[start:]); err != nil {
+			return start, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// maxEightBitLineLen is the maximum length of a line, CRLF excluded, as
+// required by RFC 5322, 2.1.1.
+const maxEightBitLineLen = 998
+
+// eightBitLineWriter breaks the lines of unencoded text longer than 998
+// octets.
+type eightBitLineWriter struct {
+	w       io.Writer
+	lineLen int
+}
+
+func (w *eightBitLineWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		end := bytes.IndexByte(p, '\n') + 1
+		if end == 0 {
+			end = len(p)
+		}
+		lineLen := end
+		if p[end-1] == '\n' {
+			lineLen--
+			if end > 1 && p[end-2] == '\r' {
+				lineLen--
+			}
+		}
+
+		if w.lineLen+lineLen > maxEightBitLineLen {
+			m, err := w.w.Write(p[:maxEightBitLineLen-w.lineLen])
+			n += m
+			if err != nil {
+				return n, err
+			}
+			if _, err := w.w.Write(crlf); err != nil {
+				return n, err
+			}
+			p = p[m:]
+			w.lineLen = 0
+			continue
+		}
+
+		m, err := w.w.Write(p[:end])
+		n += m
+		if err != nil {
+			return n, err
+		}
+		if p[end-1] == '\n' {
+			w.lineLen = 0
+		} else {
+			w.lineLen += m
+		}
+		p = p[end:]
 	}
 
-	w.w.Write(p)
-	w.lineLen += len(p)
+	return n, nil
+}
+
+var (
+	errNot7bit      = errors.New("gomail: 7bit content contains non-ASCII characters")
+	errLongLine7bit = errors.New("gomail: 7bit content contains lines longer than 998 octets")
+)
+
+// sevenBitWriter checks that the text written to it is valid 7bit data as
+// defined in RFC 2045, 2.7, before writing it to w.
+type sevenBitWriter struct {
+	w       io.Writer
+	lineLen int
+}
+
+func (w *sevenBitWriter) Write(p []byte) (int, error) {
+	lineLen, err := check7bit(p, w.lineLen)
+	if err != nil {
+		return 0, err
+	}
+	w.lineLen = lineLen
+
+	return w.w.Write(p)
+}
+
+// check7bit checks that p is valid 7bit data given the length of the current
+// line and returns the length of the last line of p.
+func check7bit(p []byte, lineLen int) (int, error) {
+	for _, c := range p {
+		switch {
+		case c == 0 || c >= utf8.RuneSelf:
+			return lineLen, errNot7bit
+		case c == '\n':
+			lineLen = 0
+		case c != '\r':
+			lineLen++
+			if lineLen > maxEightBitLineLen {
+				return lineLen, errLongLine7bit
+			}
+		}
+	}
+
+	return lineLen, nil
+}
+
+// normalizeCRLF returns b with its bare LF and bare CR converted to CRLF.
+func normalizeCRLF(b []byte) []byte {
+	buf := new(bytes.Buffer)
+	(&crlfWriter{w: buf}).Write(b)
+
+	return buf.Bytes()
+}
+
+// crlfFunc returns a function writing what f writes with its line breaks
+// normalized to CRLF.
+func crlfFunc(f func(io.Writer) error) func(io.Writer) error {
+	return func(w io.Writer) error {
+		return f(&crlfWriter{w: w})
+	}
+}
+
+// crlfWriter converts the bare LF and bare CR written to it to CRLF.
+type crlfWriter struct {
+	w io.Writer
+	// cr is true if the last byte written was a CR, already followed by LF.
+	cr bool
+}
 
-	return n + len(p), nil
+func (w *crlfWriter) Write(p []byte) (int, error) {
+	out := make([]byte, 0, len(p)+len(p)/16)
+	for _, c := range p {
+		switch {
+		case c == '\r':
+			out = append(out, '\r', '\n')
+		case c == '\n' && w.cr:
+			// The LF of a CRLF was already written.
+		case c == '\n':
+			out = append(out, '\r', '\n')
+		default:
+			out = append(out, c)
+		}
+		w.cr = c == '\r'
+	}
+	if _, err := w.w.Write(out); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// qpSpaceWriter encodes the spaces and tabs ending the lines of text encoded
+// in quoted-printable, as required by RFC 2045, 6.7. (3), since they may be
+// removed in transport. The spaces followed by a soft line break do not end
+// the line since the equal sign follows them.
+type qpSpaceWriter struct {
+	w io.Writer
+	// spaces holds the last spaces and tabs written, until the next byte
+	// tells whether they end the line.
+	spaces []byte
+}
+
+func (w *qpSpaceWriter) Write(p []byte) (int, error) {
+	out := make([]byte, 0, len(p)+3*len(w.spaces))
+	for _, c := range p {
+		switch c {
+		case ' ', '\t':
+			w.spaces = append(w.spaces, c)
+			continue
+		case '\r', '\n':
+			out = appendEncodedSpaces(out, w.spaces)
+		default:
+			out = append(out, w.spaces...)
+		}
+		w.spaces = w.spaces[:0]
+		out = append(out, c)
+	}
+	if _, err := w.w.Write(out); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Close writes the spaces ending the text, which end its last line.
+func (w *qpSpaceWriter) Close() error {
+	if len(w.spaces) == 0 {
+		return nil
+	}
+	_, err := w.w.Write(appendEncodedSpaces(nil, w.spaces))
+	w.spaces = w.spaces[:0]
+
+	return err
+}
+
+func appendEncodedSpaces(dst, spaces []byte) []byte {
+	for _, c := range spaces {
+		if c == ' ' {
+			dst = append(dst, "=20"...)
+		} else {
+			dst = append(dst, "=09"...)
+		}
+	}
+
+	return dst
 }
 
 // qpLineWriter limits text encoded in quoted-printable to 76 characters per
@@ -247,15 +1565,17 @@ func (w *qpLineWriter) Write(p []byte) (int, error) {
 	for len(p) > 0 {
 		// If the text is not over the limit, write everything
 		if len(p) < maxLineLen-w.lineLen {
-			w.w.Write(p)
-			w.lineLen += len(p)
-			return n + len(p), nil
+			m, err := w.w.Write(p)
+			w.lineLen += m
+			return n + m, err
 		}
 
 		i := bytes.IndexAny(p[:maxLineLen-w.lineLen+2], "\n")
 		// If there is a newline before the limit, write the end of the line
 		if i != -1 && (i != maxLineLen-w.lineLen+1 || p[i-1] == '\r') {
-			w.w.Write(p[:i+1])
+			if m, err := w.w.Write(p[:i+1]); err != nil {
+				return n + m, err
+			}
 			p = p[i+1:]
 			n += i + 1
 			w.lineLen = 0
@@ -274,8 +1594,12 @@ func (w *qpLineWriter) Write(p []byte) (int, error) {
 		}
 
 		// Insert the newline where it is needed
-		w.w.Write(p[:toWrite])
-		w.w.Write([]byte("=\r\n"))
+		if m, err := w.w.Write(p[:toWrite]); err != nil {
+			return n + m, err
+		}
+		if _, err := w.w.Write(qpSoftBreak); err != nil {
+			return n + toWrite, err
+		}
 		p = p[toWrite:]
 		n += toWrite
 		w.lineLen = 0
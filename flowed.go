@@ -0,0 +1,99 @@
+package gomail
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// flowedWidth is the maximum length of the lines of a format=flowed body, as
+// recommended by RFC 3676.
+const flowedWidth = 72
+
+// SetFlowedBody sets a text/plain body in the format=flowed format defined in
+// RFC 3676, so that email clients supporting it can reflow the text to the
+// width of the screen. The lines of text are wrapped at 72 characters, the
+// wrapped lines ending with a space, and the lines starting with a space, ">"
+// or "From " are space-stuffed. The body is encoded in quoted-printable, unless
+// another encoding is set with SetPartEncoding, so that the trailing spaces
+// are kept.
+//
+// Example:
+//
+//	msg.SetFlowedBody("A long paragraph that email clients can reflow...")
+func (msg *Message) SetFlowedBody(text string, settings ...PartSetting) {
+	buf := getBuffer()
+	buf.WriteString(flowText(text))
+	settings = append([]PartSetting{
+		SetPartEncoding(QuotedPrintable),
+		SetContentTypeParam("format", "flowed"),
+	}, settings...)
+	msg.parts = []part{newPart("text/plain", buf, settings)}
+}
+
+// flowText returns text in the format=flowed format.
+func flowText(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		// The signature separator keeps its trailing space.
+		if line != "-- " {
+			line = strings.TrimRight(line, " ")
+		}
+		for _, l := range wrapFlowedLine(line) {
+			lines = append(lines, spaceStuff(l))
+		}
+	}
+
+	return strings.Join(lines, "\r\n")
+}
+
+// wrapFlowedLine wraps line at spaces so that the lines are at most
+// flowedWidth characters long, when possible. All the lines but the last one
+// end with a space, making soft line breaks.
+func wrapFlowedLine(line string) []string {
+	var lines []string
+	for utf8.RuneCountInString(line) > flowedWidth {
+		i := lastSpaceBefore(line, flowedWidth)
+		if i < 0 {
+			// Long words are not broken.
+			if i = strings.IndexByte(line, ' '); i < 0 {
+				break
+			}
+		}
+		if i+1 == len(line) {
+			break
+		}
+		lines = append(lines, line[:i+1])
+		line = line[i+1:]
+	}
+
+	return append(lines, line)
+}
+
+// lastSpaceBefore returns the byte index of the last space among the first n
+// runes of s, or -1 if there is none.
+func lastSpaceBefore(s string, n int) int {
+	last := -1
+	runes := 0
+	for i, r := range s {
+		if runes == n {
+			break
+		}
+		if r == ' ' {
+			last = i
+		}
+		runes++
+	}
+
+	return last
+}
+
+// spaceStuff adds a space in front of the lines that would otherwise be read
+// as quoted or space-stuffed, or be altered by some servers.
+func spaceStuff(line string) string {
+	if strings.HasPrefix(line, " ") || strings.HasPrefix(line, ">") || strings.HasPrefix(line, "From ") {
+		return " " + line
+	}
+
+	return line
+}
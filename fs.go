@@ -0,0 +1,127 @@
+package gomail
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// AttachFS attaches the named file of fsys to the email. The file is named
+// after the base name of name unless the SetFileName setting is given.
+//
+// Example:
+//
+//	//go:embed templates
+//	var templates embed.FS
+//
+//	if err := msg.AttachFS(templates, "templates/terms.pdf"); err != nil {
+//		panic(err)
+//	}
+func (msg *Message) AttachFS(fsys fs.FS, name string, settings ...FileSetting) error {
+	f, err := openFS(fsys, name, settings)
+	if err != nil {
+		return err
+	}
+	if err := msg.checkSize(msg.encodedFileSize(f)); err != nil {
+		return err
+	}
+	msg.Attach(f)
+
+	return nil
+}
+
+// EmbedFS embeds the named image of fsys to the email. It works like AttachFS.
+//
+// Example:
+//
+//	if err := msg.EmbedFS(os.DirFS("/var/www"), "img/logo.png"); err != nil {
+//		panic(err)
+//	}
+//	msg.SetBody("text/html", `<img src="cid:logo.png" alt="Logo" />`)
+func (msg *Message) EmbedFS(fsys fs.FS, name string, settings ...FileSetting) error {
+	f, err := openFS(fsys, name, settings)
+	if err != nil {
+		return err
+	}
+	if err := msg.checkSize(msg.encodedFileSize(f)); err != nil {
+		return err
+	}
+	msg.Embed(f)
+
+	return nil
+}
+
+func openFS(fsys fs.FS, name string, settings []FileSetting) (*File, error) {
+	content, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return CreateFile(path.Base(name), content, settings...), nil
+}
+
+// AttachFile attaches the file at the given path to the email. The file is
+// named after the base name of path and its modification time is used as the
+// modification-date parameter, unless the SetFileName and SetFileModDate
+// settings are given. The MIME type is detected from the extension and then
+// from the first bytes of the file.
+//
+// The file is not held in memory: it is opened and streamed each time the
+// message is exported. AttachFile returns an error if the file cannot be
+// opened.
+//
+// Example:
+//
+//	if err := msg.AttachFile("/tmp/report.pdf"); err != nil {
+//		panic(err)
+//	}
+func (msg *Message) AttachFile(path string, settings ...FileSetting) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("gomail: cannot attach %s: it is a directory", path)
+	}
+
+	f := &File{
+		Name:     filepath.Base(path),
+		encoding: Base64,
+		modDate:  info.ModTime(),
+		open: func() (io.ReadCloser, error) {
+			return os.Open(path)
+		},
+		streamSize: info.Size(),
+	}
+	f.applySettings(settings)
+	if err := msg.checkSize(msg.encodedFileSize(f)); err != nil {
+		return err
+	}
+
+	if f.MimeType == "" {
+		f.MimeType = mime.TypeByExtension(filepath.Ext(f.Name))
+	}
+	if f.MimeType == "" {
+		// http.DetectContentType considers at most the first 512 bytes.
+		buf := make([]byte, 512)
+		n, err := io.ReadFull(file, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		f.MimeType = http.DetectContentType(buf[:n])
+	}
+	msg.Attach(f)
+
+	return nil
+}
@@ -0,0 +1,164 @@
+package gomail
+
+import (
+	"encoding/base64"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+var testFS = fstest.MapFS{
+	"docs/test.pdf":  {Data: []byte("Content 1")},
+	"img/image.jpg":  {Data: []byte("Content 2")},
+	"img/image2.jpg": {Data: []byte("Content 3")},
+}
+
+func TestAttachFS(t *testing.T) {
+	msg := NewMessage()
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.SetBody("text/html", `<img src="cid:image.jpg" />`)
+	if err := msg.AttachFS(testFS, "docs/test.pdf"); err != nil {
+		t.Fatal(err)
+	}
+	if err := msg.EmbedFS(testFS, "img/image.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if err := msg.EmbedFS(testFS, "img/image2.jpg", SetFileName("logo.jpg")); err != nil {
+		t.Fatal(err)
+	}
+
+	want := message{
+		from: "from@example.com",
+		to:   []string{"to@example.com"},
+		content: "From: from@example.com\r\n" +
+			"To: to@example.com\r\n" +
+			"Content-Type: multipart/mixed; boundary=_BOUNDARY_1_\r\n" +
+			"\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			"Content-Type: multipart/related; boundary=_BOUNDARY_2_\r\n" +
+			"\r\n" +
+			"--_BOUNDARY_2_\r\n" +
+			"Content-Type: text/html; charset=UTF-8\r\n" +
+			"Content-Transfer-Encoding: quoted-printable\r\n" +
+			"Mime-Version: 1.0\r\n" +
+			"\r\n" +
+			"<img src=3D\"cid:image.jpg\" />\r\n" +
+			"--_BOUNDARY_2_\r\n" +
+			"Content-Type: image/jpeg; name=\"image.jpg\"\r\n" +
+			"Content-Disposition: inline; filename=\"image.jpg\"\r\n" +
+			"Content-ID: <image.jpg>\r\n" +
+			"Content-Transfer-Encoding: base64\r\n" +
+			"\r\n" +
+			base64.StdEncoding.EncodeToString([]byte("Content 2")) + "\r\n" +
+			"--_BOUNDARY_2_\r\n" +
+			"Content-Type: image/jpeg; name=\"logo.jpg\"\r\n" +
+			"Content-Disposition: inline; filename=\"logo.jpg\"\r\n" +
+			"Content-ID: <logo.jpg>\r\n" +
+			"Content-Transfer-Encoding: base64\r\n" +
+			"\r\n" +
+			base64.StdEncoding.EncodeToString([]byte("Content 3")) + "\r\n" +
+			"--_BOUNDARY_2_--\r\n" +
+			"\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			"Content-Type: application/pdf; name=\"test.pdf\"\r\n" +
+			"Content-Disposition: attachment; filename=\"test.pdf\"\r\n" +
+			"Content-Transfer-Encoding: base64\r\n" +
+			"\r\n" +
+			base64.StdEncoding.EncodeToString([]byte("Content 1")) + "\r\n" +
+			"--_BOUNDARY_1_--\r\n",
+	}
+
+	testMessage(t, msg, 2, want)
+}
+
+func TestAttachFSNotFound(t *testing.T) {
+	msg := NewMessage()
+	if err := msg.AttachFS(testFS, "missing.pdf"); err == nil {
+		t.Error("AttachFS should return an error when the file does not exist")
+	}
+}
+
+func TestAttachFile(t *testing.T) {
+	dir := t.TempDir()
+	modTime := stubNow().Add(-time.Hour)
+	for name, content := range map[string]string{
+		"report.pdf": "Content 1",
+		"noext":      "<html><body>Hello</body></html>",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	msg := NewMessage()
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	if err := msg.AttachFile(filepath.Join(dir, "report.pdf")); err != nil {
+		t.Fatal(err)
+	}
+	if err := msg.AttachFile(filepath.Join(dir, "noext"), SetFileName("page")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The modification time of files is in the local time zone.
+	date := modTime.Local().Format(time.RFC1123Z)
+	want := message{
+		from: "from@example.com",
+		to:   []string{"to@example.com"},
+		content: "From: from@example.com\r\n" +
+			"To: to@example.com\r\n" +
+			"Content-Type: multipart/mixed; boundary=_BOUNDARY_1_\r\n" +
+			"\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			"Content-Type: application/pdf; name=\"report.pdf\"\r\n" +
+			"Content-Disposition: attachment; filename=\"report.pdf\"; " +
+			"modification-date=\"" + date + "\"\r\n" +
+			"Content-Transfer-Encoding: base64\r\n" +
+			"\r\n" +
+			base64.StdEncoding.EncodeToString([]byte("Content 1")) + "\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			"Content-Type: text/html; charset=utf-8; name=\"page\"\r\n" +
+			"Content-Disposition: attachment; filename=\"page\"; " +
+			"modification-date=\"" + date + "\"\r\n" +
+			"Content-Transfer-Encoding: base64\r\n" +
+			"\r\n" +
+			base64.StdEncoding.EncodeToString([]byte("<html><body>Hello</body></html>")) + "\r\n" +
+			"--_BOUNDARY_1_--\r\n",
+	}
+
+	// The files are read again each time the message is sent.
+	testMessage(t, msg, 1, want)
+	testMessage(t, msg, 1, want)
+}
+
+func TestAttachFileNotFound(t *testing.T) {
+	msg := NewMessage()
+	err := msg.AttachFile(filepath.Join(t.TempDir(), "missing.pdf"))
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Invalid error, got %v, want a not exist error", err)
+	}
+
+	// A file removed after being attached makes the export fail.
+	path := filepath.Join(t.TempDir(), "removed.pdf")
+	if err := os.WriteFile(path, []byte("Content"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := msg.AttachFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := msg.Bytes(); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Invalid error, got %v, want a not exist error", err)
+	}
+}
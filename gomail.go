@@ -5,27 +5,83 @@ package gomail
 
 import (
 	"bytes"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"mime"
+	"net/http"
+	"net/mail"
+	"net/textproto"
 	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"gopkg.in/alexcesaro/quotedprintable.v2"
 )
 
 // Message represents an email.
+//
+// A message can be exported or sent by several goroutines at the same time,
+// with Export, WriteTo, Bytes, Size or Mailer.Send, as long as it is not
+// modified meanwhile. The content of the files added with AttachReader or
+// EmbedReader can only be read once though, and the functions given to
+// SetBodyWriter are called concurrently.
 type Message struct {
 	header      header
 	parts       []part
+	mixedParts  []part
 	attachments []*File
 	embedded    []*File
 	charset     string
 	encoding    Encoding
 	hEncoder    *quotedprintable.HeaderEncoder
-	msgWriter   *messageWriter
+	signer      SignatureProvider
+	recipients  []EncryptionRecipient
+	rewriteCIDs bool
+	dedupeCIDs  bool
+	cidCount    int
+	strict      bool
+	validate    bool
+	maxSize     int64
+	location    *time.Location
+	formatDate  func(time.Time) string
+	now         func() time.Time
+	multipart   Multipart
+	report      *DeliveryReport
+	qpThreshold float64
+	// contentLength adds a Content-Length header field to the parts.
+	contentLength bool
+	// unwrappedBase64 writes base64 bodies on a single line.
+	unwrappedBase64 bool
+	// headerEncodings holds the modes set with SetHeaderEncoding.
+	headerEncodings map[string]HeaderEncoding
+	// transcode enables the conversion of text parts to their charset.
+	transcode       bool
+	charsetEncoders map[string]CharsetEncoder
+	// envelopeFrom is the address used in the MAIL command if not empty.
+	envelopeFrom string
+	// preSendHook is called with the final header and body, see
+	// SetPreSendHook.
+	preSendHook func(header map[string][]string, body []byte) error
+	// headerOrder lists the header fields written first.
+	headerOrder []string
+	// normalizeCRLF converts the line breaks of the text parts to CRLF.
+	normalizeCRLF bool
+	// boundaries are the boundaries set with SetBoundaries.
+	boundaries []string
+	// usedBoundaries holds the boundaries of the most recent export.
+	usedBoundaries atomic.Value
+	// noAutoDate disables the default Date header field.
+	noAutoDate bool
+	// partHook is called with the header of each part, see SetPartHook.
+	partHook func(h map[string][]string)
+	// excludeFrom leaves the From addresses out of the recipients.
+	excludeFrom bool
 }
 
 type header map[string][]string
@@ -33,30 +89,125 @@ type header map[string][]string
 type part struct {
 	contentType string
 	body        *bytes.Buffer
+	// charset overrides the charset of the message if not empty.
+	charset string
+	// encoding overrides the encoding of the message if not empty.
+	encoding Encoding
+	// params are added to the Content-Type header field.
+	params []param
+	// write, if not nil, writes the body at export time instead of body.
+	write func(io.Writer) error
+	// header holds the additional header fields of the part.
+	header header
+	// raw is true if the part was added with AddRawPart and is written as is
+	// with header.
+	raw bool
+}
+
+type param struct {
+	name, value string
 }
 
 // NewMessage creates a new message. It uses UTF-8 and quoted-printable encoding
 // by default.
 func NewMessage(settings ...MessageSetting) *Message {
 	msg := &Message{
-		header:   make(header),
-		charset:  "UTF-8",
-		encoding: QuotedPrintable,
+		header:      make(header),
+		charset:     defaultCharset,
+		encoding:    QuotedPrintable,
+		qpThreshold: defaultQPThreshold,
 	}
 
 	msg.applySettings(settings)
 
-	var e quotedprintable.Encoding
-	if msg.encoding == Base64 {
-		e = quotedprintable.B
+	// An empty or invalid charset would make the Content-Type header fields
+	// malformed.
+	if charset, ok := normalizeCharset(msg.charset); ok {
+		msg.charset = charset
 	} else {
-		e = quotedprintable.Q
+		msg.charset = defaultCharset
 	}
-	msg.hEncoder = e.NewHeaderEncoder(msg.charset)
+	msg.setHeaderEncoder()
 
 	return msg
 }
 
+// Clone returns a copy of the message that can be modified and reset without
+// affecting msg. Files attached or embedded with a reader or a function are
+// shared since their content is only read at export time.
+//
+// Example:
+//
+//	base := gomail.NewMessage()
+//	base.SetHeader("From", "alex@example.com")
+//	base.Embed(logo)
+//
+//	msg := base.Clone()
+//	msg.SetHeader("To", "bob@example.com")
+func (msg *Message) Clone() *Message {
+	c := *msg
+	c.header = cloneHeader(msg.header)
+	if msg.headerEncodings != nil {
+		c.headerEncodings = make(map[string]HeaderEncoding, len(msg.headerEncodings))
+		for field, mode := range msg.headerEncodings {
+			c.headerEncodings[field] = mode
+		}
+	}
+	c.parts = cloneParts(msg.parts)
+	c.mixedParts = cloneParts(msg.mixedParts)
+	c.attachments = cloneFiles(msg.attachments)
+	c.embedded = cloneFiles(msg.embedded)
+	c.recipients = append([]EncryptionRecipient(nil), msg.recipients...)
+	c.usedBoundaries = atomic.Value{}
+
+	return &c
+}
+
+func cloneParts(parts []part) []part {
+	if parts == nil {
+		return nil
+	}
+	clones := make([]part, len(parts))
+	for i, p := range parts {
+		if p.body != nil {
+			p.body = getBuffer()
+			p.body.Write(parts[i].body.Bytes())
+		}
+		p.params = append([]param(nil), p.params...)
+		p.header = cloneHeader(p.header)
+		clones[i] = p
+	}
+
+	return clones
+}
+
+func cloneHeader(h header) header {
+	if h == nil {
+		return nil
+	}
+	clone := make(header, len(h))
+	for field, values := range h {
+		clone[field] = append([]string(nil), values...)
+	}
+
+	return clone
+}
+
+func cloneFiles(files []*File) []*File {
+	if files == nil {
+		return nil
+	}
+	clones := make([]*File, len(files))
+	for i, f := range files {
+		clone := *f
+		clone.Content = append([]byte(nil), f.Content...)
+		clone.header = cloneHeader(f.header)
+		clones[i] = &clone
+	}
+
+	return clones
+}
+
 func (msg *Message) applySettings(settings []MessageSetting) {
 	for _, s := range settings {
 		s(msg)
@@ -67,7 +218,9 @@ func (msg *Message) applySettings(settings []MessageSetting) {
 // email.
 type MessageSetting func(msg *Message)
 
-// SetCharset is a message setting to set the charset of the email.
+// SetCharset is a message setting to set the charset of the email. Common
+// charset names are normalized and UTF-8 is used if the name is not valid. Use
+// Message.SetCharset to check the name.
 //
 // Example:
 //
@@ -89,6 +242,256 @@ func SetEncoding(enc Encoding) MessageSetting {
 	}
 }
 
+// SetTimeZone is a message setting to convert the dates formatted by
+// FormatDate, including the default Date header field, to the given location.
+//
+// Example:
+//
+//	msg := gomail.NewMessage(SetTimeZone(time.UTC))
+func SetTimeZone(loc *time.Location) MessageSetting {
+	return func(msg *Message) {
+		msg.location = loc
+	}
+}
+
+// SetNow is a message setting to replace the function giving the current time,
+// used for the default Date header field. Unlike stubbing a global clock, it
+// only affects this message, which makes it handy in parallel tests.
+//
+// Example:
+//
+//	date := time.Date(2014, 6, 25, 17, 46, 0, 0, time.UTC)
+//	msg := gomail.NewMessage(gomail.SetNow(func() time.Time { return date }))
+func SetNow(now func() time.Time) MessageSetting {
+	return func(msg *Message) {
+		msg.now = now
+	}
+}
+
+// currentTime returns the current time given by the function set with SetNow,
+// if any.
+func (msg *Message) currentTime() time.Time {
+	if msg.now != nil {
+		return msg.now()
+	}
+
+	return now()
+}
+
+// SetDateFormatter is a message setting to replace the function used by
+// FormatDate, including for the default Date header field. The formatted date
+// must be a valid RFC 5322 date.
+//
+// Example:
+//
+//	msg := gomail.NewMessage(SetDateFormatter(func(t time.Time) string {
+//		return t.Format(time.RFC1123Z + " (MST)")
+//	}))
+func SetDateFormatter(format func(time.Time) string) MessageSetting {
+	return func(msg *Message) {
+		msg.formatDate = format
+	}
+}
+
+// SetPreSendHook is a message setting to call hook each time the message is
+// exported, written or sent, once its body is rendered and before its header
+// is written. body holds the exact bytes sent after the header, so that hook
+// can compute a signature over it, and hook can add, replace or remove fields
+// in header, which is a copy of the header of the message. The values of
+// header must be replaced rather than modified in place. The header fields
+// are always written sorted by name.
+//
+// If hook returns an error, the message is not exported or sent and the error
+// is returned.
+//
+// Since the body must be rendered before the header is written, WriteTo holds
+// the whole message in memory when a hook is set.
+//
+// Example:
+//
+//	msg := gomail.NewMessage(gomail.SetPreSendHook(func(h map[string][]string, body []byte) error {
+//		sig, err := signer.Sign(h, body)
+//		if err != nil {
+//			return err
+//		}
+//		h["Dkim-Signature"] = []string{sig}
+//		return nil
+//	}))
+func SetPreSendHook(hook func(header map[string][]string, body []byte) error) MessageSetting {
+	return func(msg *Message) {
+		msg.preSendHook = hook
+	}
+}
+
+// ErrMessageTooLarge is returned when a message is larger than the size set
+// with SetMaxSize.
+var ErrMessageTooLarge = errors.New("gomail: message is too large")
+
+// SetMaxSize is a message setting to limit the encoded size of the email to n
+// bytes. Writing a larger message stops as soon as the limit is reached and
+// returns ErrMessageTooLarge, so that large attachments are never entirely
+// encoded. The methods adding a file and returning an error, such as
+// AttachReader or AttachFile, also return ErrMessageTooLarge as soon as the
+// known size of the content exceeds the limit. Attach and Embed cannot return
+// an error so the limit is then only enforced when the message is written.
+//
+// Example:
+//
+//	msg := gomail.NewMessage(SetMaxSize(25 << 20))
+func SetMaxSize(n int64) MessageSetting {
+	return func(msg *Message) {
+		msg.maxSize = n
+	}
+}
+
+// checkSize returns ErrMessageTooLarge if the encoded size of the content of
+// the message, plus extra bytes, exceeds the limit set with SetMaxSize. The
+// header fields and the content of unknown size are not counted so a message
+// passing this check may still be too large once written.
+func (msg *Message) checkSize(extra int64) error {
+	if msg.maxSize <= 0 {
+		return nil
+	}
+
+	size := extra
+	for _, parts := range [][]part{msg.parts, msg.mixedParts} {
+		for _, p := range parts {
+			if p.body != nil {
+				size += int64(p.body.Len())
+			}
+		}
+	}
+	for _, files := range [][]*File{msg.attachments, msg.embedded} {
+		for _, f := range files {
+			size += msg.encodedFileSize(f)
+		}
+	}
+	if size > msg.maxSize {
+		return ErrMessageTooLarge
+	}
+
+	return nil
+}
+
+// encodedFileSize returns a lower bound of the encoded size of the content of
+// f, or 0 if the size of a streamed content is unknown.
+func (msg *Message) encodedFileSize(f *File) int64 {
+	n := int64(len(f.Content))
+	if f.isStream() {
+		n = f.streamSize
+	}
+	if f.encoding != Base64 {
+		// The other encodings never make the content smaller.
+		return n
+	}
+
+	encoded := (n + 2) / 3 * 4
+	if !msg.unwrappedBase64 {
+		encoded += (encoded + 75) / 76 * 2
+	}
+
+	return encoded
+}
+
+// SetContentIDRewriting is a message setting to rewrite, in the HTML parts of
+// the email, the references to embedded images by their name into references
+// to their Content-ID. See Message.EmbedInline.
+//
+// Example:
+//
+//	msg := gomail.NewMessage(SetContentIDRewriting(true))
+func SetContentIDRewriting(enable bool) MessageSetting {
+	return func(msg *Message) {
+		msg.rewriteCIDs = enable
+	}
+}
+
+// SetPartContentLength is a message setting to add a Content-Length header
+// field, the size in bytes of the encoded body, to the parts of a multipart
+// message that are not multipart themselves. This header field is not
+// standard in emails and is only useful for the gateways that expect it. The
+// body of every part is then buffered before it is written, even when the
+// message is streamed with WriteTo.
+//
+// Example:
+//
+//	msg := gomail.NewMessage(SetPartContentLength(true))
+func SetPartContentLength(enable bool) MessageSetting {
+	return func(msg *Message) {
+		msg.contentLength = enable
+	}
+}
+
+// SetUnwrappedBase64 is a message setting to write the base64 bodies on a
+// single line instead of lines of 76 characters, for the relays that do not
+// handle wrapped base64 bodies. The lines of such messages are longer than
+// RFC 2045 allows so this setting must not be used for messages sent by SMTP.
+//
+// Example:
+//
+//	msg := gomail.NewMessage(SetUnwrappedBase64(true))
+func SetUnwrappedBase64(enable bool) MessageSetting {
+	return func(msg *Message) {
+		msg.unwrappedBase64 = enable
+	}
+}
+
+// SetCRLFNormalization is a message setting to convert the bare LF and bare CR
+// of the text parts and of the text/* files to CRLF before they are encoded, as
+// RFC 2045 requires for text. Other files are left unchanged since their
+// content may be binary; see SetFileCRLFNormalization.
+//
+// Example:
+//
+//	msg := gomail.NewMessage(SetCRLFNormalization(true))
+func SetCRLFNormalization(enable bool) MessageSetting {
+	return func(msg *Message) {
+		msg.normalizeCRLF = enable
+	}
+}
+
+// SetAutoDate is a message setting to choose whether a Date header field with
+// the current time is added when the message has none, which is the default.
+// Disabling it is useful when a relay or an API stamps its own date; RFC 5322
+// requires the Date header field so it must then be added downstream.
+//
+// Example:
+//
+//	msg := gomail.NewMessage(SetAutoDate(false))
+func SetAutoDate(enable bool) MessageSetting {
+	return func(msg *Message) {
+		msg.noAutoDate = !enable
+	}
+}
+
+// SetExcludeFrom is a message setting to leave the addresses of the From
+// header field out of the recipients, so that a sender copied in To, Cc or Bcc
+// by mistake does not get the message.
+//
+// Example:
+//
+//	msg := gomail.NewMessage(SetExcludeFrom(true))
+func SetExcludeFrom(exclude bool) MessageSetting {
+	return func(msg *Message) {
+		msg.excludeFrom = exclude
+	}
+}
+
+// SetAutoDedupeCID is a message setting to make the Content-IDs of embedded
+// files unique. By default, exporting a message where two embedded files have
+// the same Content-ID, for example because they have the same name, fails.
+// With this setting, the duplicates are embedded instead as copies with a
+// suffixed Content-ID, the files given to Embed being left unchanged.
+//
+// Example:
+//
+//	msg := gomail.NewMessage(SetAutoDedupeCID(true))
+func SetAutoDedupeCID(enable bool) MessageSetting {
+	return func(msg *Message) {
+		msg.dedupeCIDs = enable
+	}
+}
+
 // Encoding represents a MIME encoding scheme like quoted-printable or base64.
 type Encoding string
 
@@ -100,20 +503,77 @@ const (
 	// encode the data in base64
 	Base64 Encoding = "base64"
 	// Unencoded can be used to avoid encoding the body of an email. The headers
-	// will still be encoded using quoted-printable encoding.
+	// will still be encoded using quoted-printable encoding. Lines longer than
+	// 998 octets are broken as required by RFC 5322.
 	Unencoded Encoding = "8bit"
-	// Base64PreEncoded represents data that has already been base64 encoded
+	// EightBit is the same as Unencoded. Non-ASCII content can only be sent to
+	// SMTP servers supporting the 8BITMIME extension.
+	EightBit = Unencoded
+	// SevenBit avoids encoding the body of an email that only contains ASCII
+	// characters in lines of at most 998 octets. Writing a body that does not
+	// meet these requirements fails.
+	SevenBit Encoding = "7bit"
+	// AutoEncoding uses SevenBit for the bodies meeting its requirements and
+	// EightBit for the others.
+	AutoEncoding Encoding = "auto"
+	// Binary avoids encoding the body of an email without any line length
+	// limit. It can only be sent to SMTP servers supporting the BINARYMIME and
+	// CHUNKING extensions.
+	Binary Encoding = "binary"
+	// AutoQuotedPrintable uses QuotedPrintable for the bodies that are mostly
+	// made of printable ASCII characters and Base64 for the others. Bodies
+	// written by a function cannot be analyzed and use QuotedPrintable. See
+	// SetQuotedPrintableThreshold.
+	AutoQuotedPrintable Encoding = "auto-quoted-printable"
+	// Base64PreEncoded represents data that has already been base64 encoded.
+	// Any white space or line break in the data is removed and the data is
+	// wrapped again at 76 characters per line.
 	Base64PreEncoded Encoding = "base64preencoded"
 )
 
+// defaultQPThreshold is the ratio of escaped bytes above which base64 is
+// shorter than quoted-printable: an escaped byte takes 3 characters and base64
+// takes 4/3 characters per byte.
+const defaultQPThreshold = 1.0 / 6
+
+// SetQuotedPrintableThreshold is a message setting to set the ratio of bytes
+// that quoted-printable would have to escape above which the parts using
+// AutoQuotedPrintable are encoded in base64. It is 1/6 by default, the ratio
+// above which base64 is shorter.
+//
+// Example:
+//
+//	msg := gomail.NewMessage(SetEncoding(gomail.AutoQuotedPrintable), SetQuotedPrintableThreshold(0.3))
+func SetQuotedPrintableThreshold(ratio float64) MessageSetting {
+	return func(msg *Message) {
+		msg.qpThreshold = ratio
+	}
+}
+
 // SetHeader sets a value to the given header field.
+//
+// Values containing non-ASCII characters are encoded as defined in RFC 2047.
+// In the address fields (From, Sender, Reply-To, To, Cc and Bcc) only the
+// names of the addresses are encoded.
 func (msg *Message) SetHeader(field string, value ...string) {
 	for i := range value {
-		value[i] = encodeHeader(msg.hEncoder, value[i])
+		value[i] = msg.encodeHeaderValue(field, value[i])
 	}
 	msg.header[field] = value
 }
 
+// AddHeader adds values to the given header field. The values are appended to
+// the existing ones, if any.
+//
+// Example:
+//
+//	msg.AddHeader("References", "<1234@example.com>")
+func (msg *Message) AddHeader(field string, value ...string) {
+	for _, v := range value {
+		msg.header[field] = append(msg.header[field], msg.encodeHeaderValue(field, v))
+	}
+}
+
 // SetRawHeader sets a value to the given header field without encoding
 func (msg *Message) SetRawHeader(field string, value ...string) {
 	msg.header[field] = value
@@ -136,27 +596,49 @@ func (msg *Message) SetHeaders(h map[string][]string) {
 
 // SetAddressHeader sets an address to the given header field.
 func (msg *Message) SetAddressHeader(field, address, name string) {
-	msg.header[field] = []string{msg.FormatAddress(address, name)}
+	msg.header[field] = []string{msg.formatAddress(address, name, msg.headerEncoding(field))}
+}
+
+// SetEnvelopeFrom sets the envelope sender of the message, the address given to
+// the MAIL command of the SMTP server and to which bounces are sent. By
+// default, it is the address of the Sender or From header field. The header
+// fields are left unchanged. This allows VERP schemes where each message has
+// its own envelope sender encoding its recipient. See GetEnvelopeFrom.
+//
+// Example:
+//
+//	msg.SetHeader("From", "alex@example.com")
+//	msg.SetEnvelopeFrom("bounces+bob=example.org@example.com")
+func (msg *Message) SetEnvelopeFrom(address string) {
+	msg.envelopeFrom = address
 }
 
 // FormatAddress formats an address and a name as a valid RFC 5322 address.
+// Names containing special characters are quoted and non-ASCII names are
+// encoded as defined in RFC 2047.
 func (msg *Message) FormatAddress(address, name string) string {
+	return msg.formatAddress(address, name, AutoHeaderEncoding)
+}
+
+// formatAddress formats an address like FormatAddress encoding the name with
+// the given mode.
+func (msg *Message) formatAddress(address, name string, mode HeaderEncoding) string {
 	if name == "" {
 		return address
 	}
 	buf := getBuffer()
 	defer putBuffer(buf)
 
-	if !quotedprintable.NeedsEncoding(name) {
-		quote(buf, name)
-	} else {
-		var n string
+	if mode == NoHeaderEncoding || !quotedprintable.NeedsEncoding(name) {
 		if hasSpecials(name) {
-			n = encodeHeader(quotedprintable.B.NewHeaderEncoder(msg.charset), name)
+			quote(buf, name)
 		} else {
-			n = encodeHeader(msg.hEncoder, name)
+			buf.WriteString(name)
 		}
-		buf.WriteString(n)
+	} else {
+		// The B encoding is used when the Q encoding would not be valid or,
+		// unless the Q encoding is required, would be longer.
+		buf.WriteString(msg.encodeWord(name, mode, hasSpecials(name)))
 	}
 	buf.WriteString(" <")
 	buf.WriteString(address)
@@ -170,8 +652,29 @@ func (msg *Message) SetDateHeader(field string, date time.Time) {
 	msg.header[field] = []string{msg.FormatDate(date)}
 }
 
-// FormatDate formats a date as a valid RFC 5322 date.
+// SetDateLocation converts the dates formatted by FormatDate, including the
+// default Date header field, to the given location, like the SetTimeZone
+// setting. A nil location keeps the dates in their own location, which is the
+// local time zone for the default Date header field.
+//
+// Example:
+//
+//	msg.SetDateLocation(time.UTC)
+func (msg *Message) SetDateLocation(loc *time.Location) {
+	msg.location = loc
+}
+
+// FormatDate formats a date as a valid RFC 5322 date, with a numeric time zone
+// offset and no comment, e.g. "Wed, 25 Jun 2014 17:46:00 +0000". See
+// SetTimeZone and SetDateFormatter to change how dates are formatted.
 func (msg *Message) FormatDate(date time.Time) string {
+	if msg.location != nil {
+		date = date.In(msg.location)
+	}
+	if msg.formatDate != nil {
+		return msg.formatDate(date)
+	}
+
 	return date.Format(time.RFC1123Z)
 }
 
@@ -186,15 +689,13 @@ func (msg *Message) DelHeader(field string) {
 }
 
 // SetBody sets the body of the message.
-func (msg *Message) SetBody(contentType, body string) {
+//
+// The content type can carry parameters, in which case the charset of the
+// message is only added if the content type does not already have one.
+func (msg *Message) SetBody(contentType, body string, settings ...PartSetting) {
 	buf := getBuffer()
 	buf.WriteString(body)
-	msg.parts = []part{
-		part{
-			contentType: contentType,
-			body:        buf,
-		},
-	}
+	msg.parts = []part{newPart(contentType, buf, settings)}
 }
 
 // AddAlternative adds an alternative body to the message. Commonly used to
@@ -207,15 +708,10 @@ func (msg *Message) SetBody(contentType, body string) {
 //	msg.AddAlternative("text/html", "<p>Hello!</p>")
 //
 // More info: http://en.wikipedia.org/wiki/MIME#Alternative
-func (msg *Message) AddAlternative(contentType, body string) {
+func (msg *Message) AddAlternative(contentType, body string, settings ...PartSetting) {
 	buf := getBuffer()
 	buf.WriteString(body)
-	msg.parts = append(msg.parts,
-		part{
-			contentType: contentType,
-			body:        buf,
-		},
-	)
+	msg.parts = append(msg.parts, newPart(contentType, buf, settings))
 }
 
 // GetBodyWriter gets a writer that writes to the body. It can be useful with
@@ -226,18 +722,153 @@ func (msg *Message) AddAlternative(contentType, body string) {
 //	w := msg.GetBodyWriter("text/plain")
 //	t := template.Must(template.New("example").Parse("Hello {{.}}!"))
 //	t.Execute(w, "Bob")
-func (msg *Message) GetBodyWriter(contentType string) io.Writer {
+func (msg *Message) GetBodyWriter(contentType string, settings ...PartSetting) io.Writer {
 	buf := getBuffer()
-	msg.parts = append(msg.parts,
-		part{
-			contentType: contentType,
-			body:        buf,
-		},
-	)
+	msg.parts = append(msg.parts, newPart(contentType, buf, settings))
 
 	return buf
 }
 
+// SetBodyWriter sets the body of the message to the content written by f. f is
+// called each time the message is written so the body is never held in memory.
+// The error returned by f, if any, is returned when writing or sending the
+// message.
+//
+// Example:
+//
+//	t := template.Must(template.New("example").Parse("Hello {{.}}!"))
+//	msg.SetBodyWriter("text/plain", func(w io.Writer) error {
+//		return t.Execute(w, "Bob")
+//	})
+func (msg *Message) SetBodyWriter(contentType string, f func(io.Writer) error, settings ...PartSetting) {
+	p := newPart(contentType, nil, settings)
+	p.write = f
+	msg.parts = []part{p}
+}
+
+// AddAlternativeWriter adds an alternative body to the message like
+// AddAlternative. Its content is written by f, see SetBodyWriter.
+func (msg *Message) AddAlternativeWriter(contentType string, f func(io.Writer) error, settings ...PartSetting) {
+	p := newPart(contentType, nil, settings)
+	p.write = f
+	msg.parts = append(msg.parts, p)
+}
+
+// AddPart adds a part with the given content type, body and header fields to
+// the message. Unlike the alternative bodies, the parts added with AddPart
+// follow the body and the embedded files in a multipart/mixed part, before the
+// attachments. It can be used for the content that is neither a body nor a
+// file, such as a JSON report or a forwarded message/rfc822 message.
+//
+// The body is encoded with the encoding of the message unless it is set with
+// SetPartEncoding. The message and multipart content types are encoded with
+// 7bit or 8bit as required by RFC 2046. The Content-Type and
+// Content-Transfer-Encoding header fields are set by gomail and ignored in h.
+//
+// Example:
+//
+//	msg.SetBody("text/plain", "The original message is attached.")
+//	msg.AddPart("message/rfc822", original, map[string][]string{
+//		"Content-Description": {"Forwarded message"},
+//	})
+func (msg *Message) AddPart(contentType string, body []byte, h map[string][]string, settings ...PartSetting) {
+	buf := getBuffer()
+	buf.Write(body)
+	p := newPart(contentType, buf, settings)
+	if p.encoding == "" && isComposite(contentType) {
+		p.encoding = AutoEncoding
+	}
+	for field, values := range h {
+		field = textproto.CanonicalMIMEHeaderKey(field)
+		if field == "Content-Type" || field == "Content-Transfer-Encoding" {
+			continue
+		}
+		if p.header == nil {
+			p.header = make(header)
+		}
+		for _, v := range values {
+			p.header[field] = append(p.header[field], msg.encodeHeaderValue(field, v))
+		}
+	}
+	msg.mixedParts = append(msg.mixedParts, p)
+}
+
+// AddRawPart adds a part already encoded with enc, such as a part built by
+// another MIME library, to the message. Like the parts added with AddPart, it
+// is written in the multipart/mixed part, before the attachments. Its header
+// fields are written as given, without being folded or encoded, and its body
+// is written verbatim. The Content-Transfer-Encoding header field is set to
+// enc unless it is in h. The caller is responsible for the validity of the
+// part: h must contain a Content-Type header field and body must follow the
+// line length limit of enc.
+//
+// Example:
+//
+//	msg.AddRawPart(map[string][]string{
+//		"Content-Type": {"text/calendar; method=REQUEST; charset=UTF-8"},
+//	}, qpEncodedInvite, gomail.QuotedPrintable)
+func (msg *Message) AddRawPart(h map[string][]string, body []byte, enc Encoding) {
+	buf := getBuffer()
+	buf.Write(body)
+	p := part{body: buf, encoding: enc, raw: true, header: make(header, len(h)+1)}
+	for field, values := range h {
+		p.header[textproto.CanonicalMIMEHeaderKey(field)] = append([]string(nil), values...)
+	}
+	if contentType := p.header["Content-Type"]; len(contentType) > 0 {
+		p.contentType = contentType[0]
+	}
+	if _, ok := p.header["Content-Transfer-Encoding"]; !ok && enc != "" {
+		p.header["Content-Transfer-Encoding"] = []string{transferEncoding(enc)}
+	}
+	msg.mixedParts = append(msg.mixedParts, p)
+}
+
+// isComposite reports whether contentType is a message or multipart type.
+func isComposite(contentType string) bool {
+	t := strings.ToLower(contentType)
+	return strings.HasPrefix(t, "message/") || strings.HasPrefix(t, "multipart/")
+}
+
+// A PartSetting can be used as an argument in the functions setting the body
+// of a message to configure the part.
+type PartSetting func(p *part)
+
+// SetPartEncoding is a part setting to set the encoding of a body part instead
+// of using the encoding of the message.
+//
+// Example:
+//
+//	msg.AddAlternative("text/calendar; method=REQUEST", ics, gomail.SetPartEncoding(gomail.Base64))
+func SetPartEncoding(enc Encoding) PartSetting {
+	return func(p *part) {
+		p.encoding = enc
+	}
+}
+
+// SetContentTypeParam is a part setting to add a parameter to the Content-Type
+// header field of the part. The value is quoted if needed.
+//
+// Example:
+//
+//	msg.SetBody("text/plain", body, gomail.SetContentTypeParam("format", "flowed"))
+func SetContentTypeParam(name, value string) PartSetting {
+	return func(p *part) {
+		p.params = append(p.params, param{name, value})
+	}
+}
+
+func newPart(contentType string, body *bytes.Buffer, settings []PartSetting) part {
+	p := part{
+		contentType: contentType,
+		body:        body,
+	}
+	for _, s := range settings {
+		s(&p)
+	}
+
+	return p
+}
+
 // A File represents a file that can be attached or embedded in an email.
 type File struct {
 	Name      string
@@ -245,41 +876,216 @@ type File struct {
 	Content   []byte
 	ContentID string
 	encoding  Encoding
+	// reader, if not nil, is read at export time instead of Content.
+	reader io.Reader
+	// open, if not nil, opens the content at export time instead of Content.
+	open func() (io.ReadCloser, error)
+	// streamSize is the size of the content of reader or open, or 0 if it is
+	// unknown.
+	streamSize int64
+	// Parameters of the Content-Disposition header field as defined in
+	// RFC 2183.
+	size         int64
+	hasSize      bool
+	creationDate time.Time
+	modDate      time.Time
+	description  string
+	header       header
+	// disposition overrides the disposition type implied by Attach or Embed
+	// if not empty.
+	disposition string
+	// normalizeCRLF converts the line breaks of the content to CRLF. It
+	// overrides the setting of the message if crlfSet is true.
+	normalizeCRLF bool
+	crlfSet       bool
+	// dedupedFrom is the file this one is a copy of, with another Content-ID,
+	// see SetAutoDedupeCID.
+	dedupedFrom *File
+}
+
+// A FileSetting can be used as an argument in the functions creating a File to
+// configure it.
+type FileSetting func(f *File)
+
+// SetMimeType is a file setting to set the MIME type of the file instead of
+// detecting it.
+//
+// Example:
+//
+//	msg.AttachReader("report", r, gomail.SetMimeType("application/pdf"))
+func SetMimeType(mimeType string) FileSetting {
+	return func(f *File) {
+		f.MimeType = mimeType
+	}
+}
+
+// SetFileName is a file setting to set the name of the file.
+func SetFileName(name string) FileSetting {
+	return func(f *File) {
+		f.Name = name
+	}
+}
+
+// SetFileEncoding is a file setting to set the Content-Transfer-Encoding of the
+// file. Files are encoded in base64 by default. Text files, such as CSV or
+// iCalendar files, are usually smaller and stay readable in quoted-printable;
+// AutoQuotedPrintable uses it unless the content is mostly binary.
+//
+// Example:
+//
+//	f := gomail.CreateFile("report.csv", content, gomail.SetFileEncoding(gomail.AutoQuotedPrintable))
+func SetFileEncoding(enc Encoding) FileSetting {
+	return func(f *File) {
+		f.encoding = enc
+	}
 }
 
+// SetFileCRLFNormalization is a file setting to convert the bare LF and bare CR
+// of the content of the file to CRLF before it is encoded, whatever its MIME
+// type. It must only be used for text content. Disabling it keeps the line
+// breaks of a text file even if the message is created with
+// SetCRLFNormalization.
+//
+// Example:
+//
+//	f := gomail.CreateFile("report.log", content, gomail.SetFileCRLFNormalization(true))
+func SetFileCRLFNormalization(enable bool) FileSetting {
+	return func(f *File) {
+		f.normalizeCRLF = enable
+		f.crlfSet = true
+	}
+}
+
+// SetFileSize is a file setting to set the size parameter of the
+// Content-Disposition header field as defined in RFC 2183.
+func SetFileSize(size int64) FileSetting {
+	return func(f *File) {
+		f.size = size
+		f.hasSize = true
+	}
+}
+
+// SetDisposition is a file setting to set the disposition type of the
+// Content-Disposition header field, "inline" or "attachment", instead of the
+// one implied by Attach or Embed. It allows, for example, a PDF to be displayed
+// inline or an image to be attached. Embedded files keep their Content-ID while
+// attached files only get one if ContentID is set. Other values are ignored.
+//
+// Example:
+//
+//	msg.Attach(gomail.CreateFile("invoice.pdf", content, gomail.SetDisposition("inline")))
+func SetDisposition(disposition string) FileSetting {
+	return func(f *File) {
+		switch d := strings.ToLower(disposition); d {
+		case "inline", "attachment":
+			f.disposition = d
+		}
+	}
+}
+
+// SetFileCreationDate is a file setting to set the creation-date parameter of
+// the Content-Disposition header field as defined in RFC 2183.
+func SetFileCreationDate(date time.Time) FileSetting {
+	return func(f *File) {
+		f.creationDate = date
+	}
+}
+
+// SetFileModDate is a file setting to set the modification-date parameter of
+// the Content-Disposition header field as defined in RFC 2183.
+//
+// Example:
+//
+//	f := gomail.CreateFile("report.pdf", content, gomail.SetFileModDate(info.ModTime()))
+func SetFileModDate(date time.Time) FileSetting {
+	return func(f *File) {
+		f.modDate = date
+	}
+}
+
+// SetDescription is a file setting to set the Content-Description header field
+// of the file. It is encoded if it contains non-ASCII characters.
+//
+// Example:
+//
+//	f := gomail.CreateFile("report.pdf", content, gomail.SetDescription("Monthly report"))
+func SetDescription(text string) FileSetting {
+	return func(f *File) {
+		f.description = text
+	}
+}
+
+// SetFileHeader is a file setting to add a header field to the part of the
+// file. Values containing non-ASCII characters are encoded. The
+// Content-Type, Content-Transfer-Encoding, Content-Disposition and Content-ID
+// header fields are set by gomail and cannot be replaced.
+//
+// Example:
+//
+//	f := gomail.CreateFile("report.pdf", content, gomail.SetFileHeader("X-Attachment-Id", "42"))
+func SetFileHeader(field string, value ...string) FileSetting {
+	return func(f *File) {
+		field = textproto.CanonicalMIMEHeaderKey(field)
+		if f.header == nil {
+			f.header = make(header)
+		}
+		f.header[field] = value
+	}
+}
+
+// isStream reports whether the content of f is read when the message is
+// exported rather than held in Content.
+func (f *File) isStream() bool {
+	return f.reader != nil || f.open != nil
+}
+
+func (f *File) applySettings(settings []FileSetting) {
+	for _, s := range settings {
+		s(f)
+	}
+}
+
+// SetEncoding sets the Content-Transfer-Encoding of the file, like
+// SetFileEncoding. It returns an error if encoding is not one of the Encoding
+// constants.
 func (f *File) SetEncoding(encoding Encoding) error {
-	if encoding != Base64 && encoding != Base64PreEncoded {
-		return fmt.Errorf("gomail: %s is not a valid encoding for File. Must be Base64 or Base64PreEncoded", encoding)
+	switch encoding {
+	case QuotedPrintable, Base64, Unencoded, SevenBit, AutoEncoding, Binary, AutoQuotedPrintable, Base64PreEncoded:
+	default:
+		return fmt.Errorf("gomail: %q is not a valid file encoding", encoding)
 	}
 	f.encoding = encoding
 	return nil
 }
 
 // OpenFile opens a file on disk to create a gomail.File.
-func OpenFile(filename string) (*File, error) {
+func OpenFile(filename string, settings ...FileSetting) (*File, error) {
 	content, err := readFile(filename)
 	if err != nil {
 		return nil, err
 	}
 
-	f := CreateFile(filepath.Base(filename), content)
+	f := CreateFile(filepath.Base(filename), content, settings...)
 
 	return f, nil
 }
 
 // CreateFile creates a gomail.File from the given name and content.
-func CreateFile(name string, content []byte) *File {
+func CreateFile(name string, content []byte, settings ...FileSetting) *File {
 	mimeType := mime.TypeByExtension(filepath.Ext(name))
 	if mimeType == "" {
 		mimeType = "application/octet-stream"
 	}
 
-	return &File{
+	f := &File{
 		Name:     name,
 		MimeType: mimeType,
 		Content:  content,
 		encoding: Base64,
 	}
+	f.applySettings(settings)
+
+	return f
 }
 
 // Attach attaches the files to the email.
@@ -291,7 +1097,42 @@ func (msg *Message) Attach(f ...*File) {
 	}
 }
 
-// Embed embeds the images to the email.
+// AttachMessage attaches original to the email as a message/rfc822 part named
+// name, as done when forwarding an email. original is written as by WriteTo,
+// so without its Bcc header field, and is encoded with 7bit or 8bit so that it
+// stays readable. The email is then always a multipart/mixed message.
+//
+// Example:
+//
+//	fwd := gomail.NewMessage()
+//	fwd.SetHeader("Subject", "Fwd: "+subject)
+//	fwd.SetBody("text/plain", "See the forwarded message.")
+//	if err := fwd.AttachMessage("original.eml", original); err != nil {
+//		panic(err)
+//	}
+func (msg *Message) AttachMessage(name string, original *Message) error {
+	b, err := original.Bytes()
+	if err != nil {
+		return err
+	}
+	if err := msg.checkSize(int64(len(b))); err != nil {
+		return err
+	}
+
+	buf := getBuffer()
+	buf.Write(b)
+	p := newPart("message/rfc822", buf, nil)
+	p.encoding = AutoEncoding
+	p.header = header{"Content-Disposition": {withFileName("Content-Disposition", "attachment", "filename", name)}}
+	msg.mixedParts = append(msg.mixedParts, p)
+	msg.multipart |= MultipartMixed
+
+	return nil
+}
+
+// Embed embeds the images to the email. Embedding a file again, or a file with
+// the same name, Content-ID and content, does nothing so that images
+// referenced by several parts are only written once.
 //
 // Example:
 //
@@ -302,11 +1143,258 @@ func (msg *Message) Attach(f ...*File) {
 //	msg.Embed(f)
 //	msg.SetBody("text/html", `<img src="cid:image.jpg" alt="My image" />`)
 func (msg *Message) Embed(image ...*File) {
-	if msg.embedded == nil {
-		msg.embedded = image
-	} else {
-		msg.embedded = append(msg.embedded, image...)
+	for _, f := range image {
+		msg.embed(f)
+	}
+}
+
+// embed embeds f unless it is already embedded and returns the embedded file.
+func (msg *Message) embed(f *File) *File {
+	if e := msg.embeddedFile(f); e != nil {
+		return e
+	}
+	if msg.dedupeCIDs {
+		f = msg.dedupeContentID(f)
+	}
+	msg.embedded = append(msg.embedded, f)
+
+	return f
+}
+
+// AttachReader attaches a file whose content is read from r. Unless the
+// SetMimeType setting is given, the MIME type is detected from the first bytes
+// of the content and then from the extension of name.
+//
+// r is read when the message is exported so a message containing such a file
+// can only be exported once.
+//
+// Example:
+//
+//	resp, err := http.Get("http://example.com/report.pdf")
+//	if err != nil {
+//		panic(err)
+//	}
+//	defer resp.Body.Close()
+//	if err := msg.AttachReader("report.pdf", resp.Body); err != nil {
+//		panic(err)
+//	}
+func (msg *Message) AttachReader(name string, r io.Reader, settings ...FileSetting) error {
+	f, err := readerFile(name, r, settings)
+	if err != nil {
+		return err
+	}
+	if err := msg.checkSize(msg.encodedFileSize(f)); err != nil {
+		return err
+	}
+	msg.Attach(f)
+
+	return nil
+}
+
+// EmbedReader embeds an image whose content is read from r. It works like
+// AttachReader.
+func (msg *Message) EmbedReader(name string, r io.Reader, settings ...FileSetting) error {
+	f, err := readerFile(name, r, settings)
+	if err != nil {
+		return err
+	}
+	if err := msg.checkSize(msg.encodedFileSize(f)); err != nil {
+		return err
+	}
+	msg.Embed(f)
+
+	return nil
+}
+
+// AttachSized attaches a file whose content, of size bytes, is read from r. It
+// works like AttachReader but since the size is known, the limit set with
+// SetMaxSize is enforced when the file is added and Attachments reports the
+// size. Writing the message fails if r does not contain exactly size bytes,
+// rather than writing a truncated or padded file.
+//
+// Example:
+//
+//	f, err := os.Open("/tmp/report.pdf")
+//	if err != nil {
+//		panic(err)
+//	}
+//	defer f.Close()
+//	info, err := f.Stat()
+//	if err != nil {
+//		panic(err)
+//	}
+//	if err := msg.AttachSized("report.pdf", f, info.Size()); err != nil {
+//		panic(err)
+//	}
+func (msg *Message) AttachSized(name string, r io.Reader, size int64, settings ...FileSetting) error {
+	f, err := sizedFile(name, r, size, settings)
+	if err != nil {
+		return err
+	}
+	if err := msg.checkSize(msg.encodedFileSize(f)); err != nil {
+		return err
+	}
+	msg.Attach(f)
+
+	return nil
+}
+
+// EmbedSized embeds an image whose content, of size bytes, is read from r. It
+// works like AttachSized.
+func (msg *Message) EmbedSized(name string, r io.Reader, size int64, settings ...FileSetting) error {
+	f, err := sizedFile(name, r, size, settings)
+	if err != nil {
+		return err
+	}
+	if err := msg.checkSize(msg.encodedFileSize(f)); err != nil {
+		return err
 	}
+	msg.Embed(f)
+
+	return nil
+}
+
+func sizedFile(name string, r io.Reader, size int64, settings []FileSetting) (*File, error) {
+	if size < 0 {
+		return nil, fmt.Errorf("gomail: invalid size %d for %q", size, name)
+	}
+	f, err := readerFile(name, r, settings)
+	if err != nil {
+		return nil, err
+	}
+	f.reader = &sizedReader{r: f.reader, name: name, size: size}
+	f.streamSize = size
+
+	return f, nil
+}
+
+// sizedReader reads the content of a file declared to be size bytes long and
+// returns an error if it is shorter or longer.
+type sizedReader struct {
+	r    io.Reader
+	name string
+	size int64
+	n    int64
+}
+
+func (r *sizedReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	if over := r.n - r.size; over > 0 {
+		if n -= int(over); n < 0 {
+			n = 0
+		}
+		return n, fmt.Errorf("gomail: %q is longer than its declared size of %d bytes", r.name, r.size)
+	}
+	if err == io.EOF && r.n < r.size {
+		return n, fmt.Errorf("gomail: %q is %d bytes long, shorter than its declared size of %d bytes", r.name, r.n, r.size)
+	}
+
+	return n, err
+}
+
+func readerFile(name string, r io.Reader, settings []FileSetting) (*File, error) {
+	f := &File{
+		Name:     name,
+		encoding: Base64,
+		reader:   r,
+	}
+	if l, ok := r.(interface{ Len() int }); ok {
+		f.streamSize = int64(l.Len())
+	}
+	f.applySettings(settings)
+
+	if f.MimeType == "" {
+		// http.DetectContentType considers at most the first 512 bytes.
+		buf := make([]byte, 512)
+		n, err := io.ReadFull(r, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+		buf = buf[:n]
+		f.MimeType = detectMimeType(name, buf)
+		// The sniffed bytes are put back in front of the remaining content.
+		f.reader = io.MultiReader(bytes.NewReader(buf), r)
+	}
+
+	return f, nil
+}
+
+// AttachPreEncoded attaches a file whose content is already base64 encoded,
+// such as the data of a data URI, so that it is written as is instead of being
+// encoded again. Line breaks and spaces in b64 are ignored and the content is
+// wrapped at 76 characters per line. An error is returned if b64 is not valid
+// base64. If contentType is empty, it is detected from the content and then
+// from the extension of name.
+//
+// Example:
+//
+//	// uri is "data:image/png;base64,iVBORw0KGgo..."
+//	i := strings.Index(uri, ",")
+//	err := msg.AttachPreEncoded("image.png", "image/png", []byte(uri[i+1:]))
+func (msg *Message) AttachPreEncoded(name, contentType string, b64 []byte, settings ...FileSetting) error {
+	f, err := preEncodedFile(name, contentType, b64, settings)
+	if err != nil {
+		return err
+	}
+	if err := msg.checkSize(msg.encodedFileSize(f)); err != nil {
+		return err
+	}
+	msg.Attach(f)
+
+	return nil
+}
+
+// EmbedPreEncoded embeds an image whose content is already base64 encoded. It
+// works like AttachPreEncoded.
+func (msg *Message) EmbedPreEncoded(name, contentType string, b64 []byte, settings ...FileSetting) error {
+	f, err := preEncodedFile(name, contentType, b64, settings)
+	if err != nil {
+		return err
+	}
+	if err := msg.checkSize(msg.encodedFileSize(f)); err != nil {
+		return err
+	}
+	msg.Embed(f)
+
+	return nil
+}
+
+func preEncodedFile(name, contentType string, b64 []byte, settings []FileSetting) (*File, error) {
+	b64 = bytes.Map(func(r rune) rune {
+		if r == ' ' || r == '\t' || r == '\r' || r == '\n' {
+			return -1
+		}
+		return r
+	}, b64)
+	content, err := base64.StdEncoding.DecodeString(string(b64))
+	if err != nil {
+		return nil, fmt.Errorf("gomail: invalid base64 content for %q: %v", name, err)
+	}
+	if contentType == "" {
+		contentType = detectMimeType(name, content)
+	}
+
+	f := &File{
+		Name:     name,
+		MimeType: contentType,
+		Content:  b64,
+		encoding: Base64PreEncoded,
+	}
+	f.applySettings(settings)
+
+	return f, nil
+}
+
+func detectMimeType(name string, content []byte) string {
+	if mimeType := http.DetectContentType(content); mimeType != "application/octet-stream" {
+		return mimeType
+	}
+	if mimeType := mime.TypeByExtension(filepath.Ext(name)); mimeType != "" {
+		return mimeType
+	}
+
+	return "application/octet-stream"
 }
 
 // Stubbed out for testing.
@@ -334,6 +1422,68 @@ func hasSpecials(text string) bool {
 	return false
 }
 
+func mostlyNonASCII(text string) bool {
+	n := 0
+	for i := 0; i < len(text); i++ {
+		if text[i] >= utf8.RuneSelf {
+			n++
+		}
+	}
+
+	return n > len(text)/2
+}
+
+// addressFields are the header fields containing addresses.
+var addressFields = map[string]bool{
+	"From":     true,
+	"Sender":   true,
+	"Reply-To": true,
+	"To":       true,
+	"Cc":       true,
+	"Bcc":      true,
+}
+
+// encodeHeaderValue encodes a value of the given header field if it contains
+// non-ASCII characters, as set with SetHeaderEncoding. The addresses of address
+// fields are formatted with FormatAddress so that only their names are
+// encoded. Values of address fields that cannot be parsed are left as is,
+// Validate reports them.
+func (msg *Message) encodeHeaderValue(field, value string) string {
+	mode := msg.headerEncoding(field)
+	if mode == NoHeaderEncoding || !quotedprintable.NeedsEncoding(value) {
+		return value
+	}
+	if !addressFields[textproto.CanonicalMIMEHeaderKey(field)] {
+		return msg.encodeWord(value, mode, false)
+	}
+
+	addrs, err := mail.ParseAddressList(value)
+	if err != nil {
+		return value
+	}
+	formatted := make([]string, len(addrs))
+	for i, a := range addrs {
+		formatted[i] = msg.formatAddress(a.Address, a.Name, mode)
+	}
+
+	return strings.Join(formatted, ", ")
+}
+
+// encodeWord encodes value with the given mode. With AutoHeaderEncoding, the B
+// encoding is used instead of the encoding of the message when it is shorter,
+// that is when most characters are not ASCII. forceB is true when the Q
+// encoding would not be valid.
+func (msg *Message) encodeWord(value string, mode HeaderEncoding, forceB bool) string {
+	switch {
+	case forceB || mode == BEncoding || mode == AutoHeaderEncoding && mostlyNonASCII(value):
+		return encodeHeader(quotedprintable.B.NewHeaderEncoder(msg.headerCharset()), value)
+	case mode == QEncoding:
+		return encodeHeader(quotedprintable.Q.NewHeaderEncoder(msg.headerCharset()), value)
+	}
+
+	return encodeHeader(msg.hEncoder, value)
+}
+
 func encodeHeader(enc *quotedprintable.HeaderEncoder, value string) string {
 	if !quotedprintable.NeedsEncoding(value) {
 		return value
@@ -352,8 +1502,14 @@ func getBuffer() *bytes.Buffer {
 	return bufPool.Get().(*bytes.Buffer)
 }
 
+// MaxPooledBufferSize is the capacity in bytes above which the buffers used to
+// export messages are not reused, so that sending a large message does not
+// keep its buffers in memory afterwards. It must not be changed while messages
+// are exported.
+var MaxPooledBufferSize = 1 << 20
+
 func putBuffer(buf *bytes.Buffer) {
-	if buf.Len() > 1024 {
+	if buf.Cap() > MaxPooledBufferSize {
 		return
 	}
 	buf.Reset()
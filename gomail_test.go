@@ -1,14 +1,27 @@
 package gomail
 
 import (
+	"bytes"
 	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	qp "mime/quotedprintable"
+	"net/mail"
 	"net/smtp"
+	"os"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
+	"unicode/utf8"
 )
 
 type message struct {
@@ -55,6 +68,383 @@ func TestMessage(t *testing.T) {
 	testMessage(t, msg, 0, want)
 }
 
+func TestFormatAddress(t *testing.T) {
+	tests := []struct {
+		addr, name, want string
+	}{
+		{"a@example.com", "", "a@example.com"},
+		{"a@example.com", "John Smith", "John Smith <a@example.com>"},
+		{"a@example.com", "Smith, John", "\"Smith, John\" <a@example.com>"},
+		{"a@example.com", "John \"Johnny\" Smith", "\"John \\\"Johnny\\\" Smith\" <a@example.com>"},
+		{"a@example.com", "Jürgen Müller", "=?UTF-8?Q?J=C3=BCrgen_M=C3=BCller?= <a@example.com>"},
+		{"a@example.com", "山田太郎", "=?UTF-8?B?5bGx55Sw5aSq6YOO?= <a@example.com>"},
+	}
+
+	msg := NewMessage()
+	for _, test := range tests {
+		if got := msg.FormatAddress(test.addr, test.name); got != test.want {
+			t.Errorf("FormatAddress(%q, %q) = %q, want %q", test.addr, test.name, got, test.want)
+		}
+	}
+}
+
+func TestGetEnvelopeFrom(t *testing.T) {
+	msg := NewMessage()
+	msg.SetHeader("From", "Alex <from@example.com>")
+	assertEnvelopeFrom(t, msg, "from@example.com")
+
+	msg.SetHeader("Sender", "sender@example.com")
+	assertEnvelopeFrom(t, msg, "sender@example.com")
+
+	msg.SetEnvelopeFrom("bounces+to=example.org@example.com")
+	assertEnvelopeFrom(t, msg, "bounces+to=example.org@example.com")
+
+	msg.SetEnvelopeFrom("bounces@")
+	_, err := msg.GetEnvelopeFrom()
+	if e, ok := err.(*ValidationError); !ok || e.Field != "Return-Path" {
+		t.Errorf("Invalid error, got %v, want a *ValidationError on Return-Path", err)
+	}
+}
+
+func assertEnvelopeFrom(t *testing.T, msg *Message, want string) {
+	got, err := msg.GetEnvelopeFrom()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("Invalid envelope sender, got %q, want %q", got, want)
+	}
+}
+
+func TestGetRecipients(t *testing.T) {
+	msg := NewMessage()
+	msg.SetHeader("To", "to@example.com", "Cc <cc@example.com>")
+	msg.SetHeader("Cc", "cc@example.com")
+	msg.SetHeader("Bcc", "bcc@example.com", "to@example.com")
+
+	got, err := msg.GetRecipients()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"to@example.com", "cc@example.com", "bcc@example.com"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Invalid recipients, got %q, want %q", got, want)
+	}
+
+	msg.SetHeader("Cc", "cc@")
+	_, err = msg.GetRecipients()
+	if e, ok := err.(*ValidationError); !ok || e.Field != "Cc" {
+		t.Errorf("Invalid error, got %v, want a *ValidationError on Cc", err)
+	}
+
+	msg.SetHeader("Cc", "cc@example.com")
+	msg.SetHeader("From", "from")
+	mailer := NewMailer("host", "username", "password", 587, SetSendMail(stubSendMail(t, 0)))
+	err = mailer.Send(msg)
+	if e, ok := err.(*ValidationError); !ok || e.Field != "From" {
+		t.Errorf("Invalid error, got %v, want a *ValidationError on From", err)
+	}
+}
+
+func TestRecipientsDeduplication(t *testing.T) {
+	msg := NewMessage()
+	msg.SetHeader("From", "Alex <alex@example.com>")
+	msg.SetHeader("To", "Bob@Example.COM", "alex@EXAMPLE.com")
+	msg.SetHeader("Cc", " bob@example.com ")
+	msg.SetHeader("Bcc", "Bob <Bob@example.com>", "carol@example.com")
+	msg.SetBody("text/plain", "Test")
+
+	got, err := msg.GetRecipients()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "Bob@example.com,alex@example.com,bob@example.com,carol@example.com"
+	if strings.Join(got, ",") != want {
+		t.Errorf("Invalid recipients, got %q, want %q", got, want)
+	}
+
+	var txs []string
+	mailer := NewMailer("host", "username", "password", 587, SetSendMail(
+		func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+			txs = append(txs, strings.Join(to, ","))
+			return nil
+		}))
+	if err := mailer.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+	// Bob is not sent a Bcc copy since he is already in To.
+	wantTxs := "Bob@example.com,alex@example.com,bob@example.com;carol@example.com"
+	if got := strings.Join(txs, ";"); got != wantTxs {
+		t.Errorf("Invalid transactions, got %q, want %q", got, wantTxs)
+	}
+
+	SetExcludeFrom(true)(msg)
+	got, err = msg.GetRecipients()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = "Bob@example.com,bob@example.com,carol@example.com"
+	if strings.Join(got, ",") != want {
+		t.Errorf("The From address should be excluded, got %q, want %q", got, want)
+	}
+}
+
+func TestBccHeader(t *testing.T) {
+	msg := NewMessage()
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.SetHeader("Bcc", "Bob <Bob@EXAMPLE.com>", "xbob@example.com")
+	msg.SetBody("text/plain", "Test")
+
+	bccs := make(map[string]string)
+	mailer := NewMailer("host", "username", "password", 587, SetSendMail(
+		func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+			m, err := mail.ReadMessage(bytes.NewReader(msg))
+			if err != nil {
+				return err
+			}
+			bccs[strings.Join(to, ",")] = strings.Join(m.Header["Bcc"], ",")
+			return nil
+		}))
+	if err := mailer.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"to@example.com":   "",
+		"Bob@example.com":  "Bob <Bob@EXAMPLE.com>",
+		"xbob@example.com": "xbob@example.com",
+	}
+	if len(bccs) != len(want) {
+		t.Fatalf("Invalid transactions, got %q", bccs)
+	}
+	for to, bcc := range want {
+		if got := bccs[to]; got != bcc {
+			t.Errorf("Invalid Bcc header field sent to %s, got %q, want %q", to, got, bcc)
+		}
+	}
+}
+
+func TestDateFormat(t *testing.T) {
+	now = func() time.Time {
+		return time.Date(2014, 06, 25, 19, 46, 0, 0, time.FixedZone("CEST", 2*3600))
+	}
+	defer func() { now = stubNow }()
+
+	tests := []struct {
+		settings []MessageSetting
+		want     string
+	}{
+		{nil, "Wed, 25 Jun 2014 19:46:00 +0200"},
+		{[]MessageSetting{SetTimeZone(time.UTC)}, "Wed, 25 Jun 2014 17:46:00 +0000"},
+		{
+			[]MessageSetting{SetTimeZone(time.UTC), SetDateFormatter(func(t time.Time) string {
+				return t.Format(time.RFC1123Z + " (MST)")
+			})},
+			"Wed, 25 Jun 2014 17:46:00 +0000 (UTC)",
+		},
+	}
+
+	for _, test := range tests {
+		msg := NewMessage(test.settings...)
+		m := msg.Export()
+		if got := m.Header.Get("Date"); got != test.want {
+			t.Errorf("Invalid Date, got %q, want %q", got, test.want)
+		}
+
+		msg.SetHeader("Date", "Thu, 26 Jun 2014 10:00:00 +0000")
+		m = msg.Export()
+		if got := m.Header.Get("Date"); got != "Thu, 26 Jun 2014 10:00:00 +0000" {
+			t.Errorf("An explicit Date should not be overwritten, got %q", got)
+		}
+	}
+}
+
+func TestSetDateLocation(t *testing.T) {
+	now = func() time.Time {
+		return time.Date(2014, 06, 25, 19, 46, 0, 0, time.FixedZone("CEST", 2*3600))
+	}
+	defer func() { now = stubNow }()
+
+	msg := NewMessage()
+	msg.SetDateLocation(time.FixedZone("EST", -5*3600))
+	if got, want := msg.Export().Header.Get("Date"), "Wed, 25 Jun 2014 12:46:00 -0500"; got != want {
+		t.Errorf("Invalid Date, got %q, want %q", got, want)
+	}
+
+	msg.SetDateLocation(nil)
+	if got, want := msg.Export().Header.Get("Date"), "Wed, 25 Jun 2014 19:46:00 +0200"; got != want {
+		t.Errorf("Invalid Date, got %q, want %q", got, want)
+	}
+}
+
+func TestSetNow(t *testing.T) {
+	for _, hour := range []int{8, 20} {
+		hour := hour
+		t.Run(strconv.Itoa(hour), func(t *testing.T) {
+			t.Parallel()
+			msg := NewMessage(SetNow(func() time.Time {
+				return time.Date(2014, 06, 25, hour, 0, 0, 0, time.UTC)
+			}))
+			want := fmt.Sprintf("Wed, 25 Jun 2014 %02d:00:00 +0000", hour)
+			if got := msg.Export().Header.Get("Date"); got != want {
+				t.Errorf("Invalid Date, got %q, want %q", got, want)
+			}
+		})
+	}
+
+	msg := NewMessage()
+	if got, want := msg.Export().Header.Get("Date"), msg.FormatDate(now()); got != want {
+		t.Errorf("The global clock should be used by default, got %q, want %q", got, want)
+	}
+}
+
+func TestSetAutoDate(t *testing.T) {
+	msg := NewMessage(SetAutoDate(false))
+	msg.SetHeader("From", "from@example.com")
+	msg.SetBody("text/plain", "Test")
+
+	b, err := msg.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "Date:") {
+		t.Errorf("The message should have no Date header field:\n%s", b)
+	}
+
+	msg.SetDateHeader("Date", time.Date(2014, 06, 25, 17, 46, 0, 0, time.UTC))
+	if got, want := msg.Export().Header.Get("Date"), "Wed, 25 Jun 2014 17:46:00 +0000"; got != want {
+		t.Errorf("An explicit Date should be kept, got %q, want %q", got, want)
+	}
+}
+
+func TestPreSendHook(t *testing.T) {
+	var body []byte
+	msg := NewMessage(SetPreSendHook(func(h map[string][]string, b []byte) error {
+		body = append([]byte(nil), b...)
+		h["X-Signature"] = []string{fmt.Sprintf("len=%d", len(b))}
+		delete(h, "X-Internal")
+		return nil
+	}))
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.SetHeader("X-Internal", "secret")
+	msg.SetBody("text/plain", "Hello")
+	msg.Attach(CreateFile("test.txt", []byte("Content")))
+
+	sent := sendToString(t, msg)
+	i := strings.Index(sent, "\r\n\r\n")
+	if got := sent[i+4:]; got != string(body) {
+		t.Errorf("The hook should get the sent body, got:\n%s\nwant:\n%s", body, got)
+	}
+	if want := fmt.Sprintf("X-Signature: len=%d\r\n", len(body)); !strings.Contains(sent[:i+2], want) {
+		t.Errorf("Missing %q in the header:\n%s", want, sent[:i+2])
+	}
+	if strings.Contains(sent, "X-Internal") {
+		t.Errorf("X-Internal should be removed:\n%s", sent)
+	}
+	assertHeader(t, msg, "X-Internal", "secret")
+
+	b, err := msg.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(b); withoutBoundaries(got) != withoutBoundaries(sent) {
+		t.Errorf("WriteTo and Send should write the same message, got:\n%s\nwant:\n%s", got, sent)
+	}
+
+	errHook := errors.New("hook error")
+	msg = NewMessage(SetPreSendHook(func(map[string][]string, []byte) error {
+		return errHook
+	}))
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.SetBody("text/plain", "Hello")
+	if _, err := msg.ExportWithError(); err != errHook {
+		t.Errorf("Invalid Export error, got %v, want %v", err, errHook)
+	}
+	if _, err := msg.WriteTo(new(bytes.Buffer)); err != errHook {
+		t.Errorf("Invalid WriteTo error, got %v, want %v", err, errHook)
+	}
+	mailer := NewMailer("host", "username", "password", 587, SetSendMail(stubSendMail(t, 0)))
+	if err := mailer.Send(msg); err != errHook {
+		t.Errorf("Invalid Send error, got %v, want %v", err, errHook)
+	}
+}
+
+func TestAddHeader(t *testing.T) {
+	msg := NewMessage()
+	msg.AddHeader("X-Tag", "a")
+	assertHeader(t, msg, "X-Tag", "a")
+
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.AddHeader("X-Tag", "b", "Café")
+	assertHeader(t, msg, "X-Tag", "a", "b", "=?UTF-8?Q?Caf=C3=A9?=")
+	msg.SetBody("text/plain", "Test")
+
+	want := message{
+		from: "from@example.com",
+		to:   []string{"to@example.com"},
+		content: "From: from@example.com\r\n" +
+			"To: to@example.com\r\n" +
+			"X-Tag: a, b, =?UTF-8?Q?Caf=C3=A9?=\r\n" +
+			"Content-Type: text/plain; charset=UTF-8\r\n" +
+			"Content-Transfer-Encoding: quoted-printable\r\n" +
+			"\r\n" +
+			"Test",
+	}
+
+	testMessage(t, msg, 0, want)
+}
+
+func TestHeaderEncoding(t *testing.T) {
+	msg := NewMessage()
+	msg.SetHeader("From", "Señor From <from@example.com>")
+	msg.SetHeader("To", "to@example.com", "Jörg <jorg@example.com>, 山田 <yamada@example.com>")
+	msg.SetHeader("Subject", "Happy birthday 🎂")
+	assertHeader(t, msg, "Subject", "=?UTF-8?Q?Happy_birthday_=F0=9F=8E=82?=")
+	msg.SetHeader("X-Mood", "😀")
+	assertHeader(t, msg, "X-Mood", "=?UTF-8?B?8J+YgA==?=")
+
+	subject := strings.Repeat("日本語の長い件名です。", 6)
+	msg.SetHeader("Subject", subject)
+
+	assertHeader(t, msg, "From", "=?UTF-8?Q?Se=C3=B1or_From?= <from@example.com>")
+	assertHeader(t, msg, "To", "to@example.com",
+		"=?UTF-8?Q?J=C3=B6rg?= <jorg@example.com>, =?UTF-8?B?5bGx55Sw?= <yamada@example.com>")
+
+	encoded := msg.GetHeader("Subject")[0]
+	if !strings.HasPrefix(encoded, "=?UTF-8?B?") {
+		t.Errorf("Subject should be B-encoded, got %q", encoded)
+	}
+	dec := new(mime.WordDecoder)
+	for _, word := range strings.Split(encoded, " ") {
+		if len(word) > 75 {
+			t.Errorf("Encoded-word is too long: %q", word)
+		}
+		if s, err := dec.Decode(word); err != nil || !utf8.ValidString(s) {
+			t.Errorf("Encoded-word %q does not contain whole characters: %q, %v", word, s, err)
+		}
+	}
+	if got, err := dec.DecodeHeader(encoded); err != nil || got != subject {
+		t.Errorf("Invalid decoded subject, got %q, %v, want %q", got, err, subject)
+	}
+
+	b, err := msg.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(string(b), "\r\n") {
+		if len(line) > 78 {
+			t.Errorf("Header line is too long: %q", line)
+		}
+		if line == "" {
+			break
+		}
+	}
+}
+
 func TestBodyWriter(t *testing.T) {
 	msg := NewMessage()
 	msg.SetHeader("From", "from@example.com")
@@ -209,73 +599,73 @@ func TestAlternative(t *testing.T) {
 	testMessage(t, msg, 1, want)
 }
 
-func TestAttachmentOnly(t *testing.T) {
-	readFile = func(filename string) ([]byte, error) {
-		return []byte("Content of " + filepath.Base(filename)), nil
-	}
-
-	msg := NewMessage()
-	msg.SetHeader("From", "from@example.com")
-	msg.SetHeader("To", "to@example.com")
-	f, err := OpenFile("/tmp/test.pdf")
-	if err != nil {
-		panic(err)
-	}
-	msg.Attach(f)
-
-	want := message{
-		from: "from@example.com",
-		to:   []string{"to@example.com"},
-		content: "From: from@example.com\r\n" +
-			"To: to@example.com\r\n" +
-			"Content-Type: application/pdf; name=\"test.pdf\"\r\n" +
-			"Content-Disposition: attachment; filename=\"test.pdf\"\r\n" +
-			"Content-Transfer-Encoding: base64\r\n" +
-			"\r\n" +
-			base64.StdEncoding.EncodeToString([]byte("Content of test.pdf")),
-	}
-
-	testMessage(t, msg, 0, want)
-}
-
-func TestAttachment(t *testing.T) {
+func TestSetBodyWriter(t *testing.T) {
+	calls := 0
 	msg := NewMessage()
 	msg.SetHeader("From", "from@example.com")
 	msg.SetHeader("To", "to@example.com")
-	msg.SetBody("text/plain", "Test")
-	msg.Attach(CreateFile("test.pdf", []byte("Content")))
+	msg.SetBodyWriter("text/plain", func(w io.Writer) error {
+		calls++
+		_, err := io.WriteString(w, "¡Hola, señor!")
+		return err
+	})
+	msg.AddAlternativeWriter("text/html", func(w io.Writer) error {
+		_, err := io.WriteString(w, "¡<b>Hola</b>, <i>señor</i>!</h1>")
+		return err
+	}, SetPartEncoding(Base64))
 
 	want := message{
 		from: "from@example.com",
 		to:   []string{"to@example.com"},
 		content: "From: from@example.com\r\n" +
 			"To: to@example.com\r\n" +
-			"Content-Type: multipart/mixed; boundary=_BOUNDARY_1_\r\n" +
+			"Content-Type: multipart/alternative; boundary=_BOUNDARY_1_\r\n" +
 			"\r\n" +
 			"--_BOUNDARY_1_\r\n" +
 			"Content-Type: text/plain; charset=UTF-8\r\n" +
 			"Content-Transfer-Encoding: quoted-printable\r\n" +
 			"Mime-Version: 1.0\r\n" +
 			"\r\n" +
-			"Test\r\n" +
+			"=C2=A1Hola, se=C3=B1or!\r\n" +
 			"--_BOUNDARY_1_\r\n" +
-			"Content-Type: application/pdf; name=\"test.pdf\"\r\n" +
-			"Content-Disposition: attachment; filename=\"test.pdf\"\r\n" +
+			"Content-Type: text/html; charset=UTF-8\r\n" +
 			"Content-Transfer-Encoding: base64\r\n" +
+			"Mime-Version: 1.0\r\n" +
 			"\r\n" +
-			base64.StdEncoding.EncodeToString([]byte("Content")) + "\r\n" +
+			wrapBase64("¡<b>Hola</b>, <i>señor</i>!</h1>") + "\r\n" +
 			"--_BOUNDARY_1_--\r\n",
 	}
 
 	testMessage(t, msg, 1, want)
+	testMessage(t, msg, 1, want)
+	if calls != 2 {
+		t.Errorf("The body writer should be called once per send, got %d calls", calls)
+	}
+
+	wantErr := errors.New("gomail: test error")
+	msg.SetBodyWriter("text/plain", func(w io.Writer) error {
+		return wantErr
+	})
+	if _, err := msg.WriteTo(ioutil.Discard); err != wantErr {
+		t.Errorf("Invalid error, got %v, want %v", err, wantErr)
+	}
 }
 
-func TestAttachmentsOnly(t *testing.T) {
+func TestCalendarInvite(t *testing.T) {
+	ics := "BEGIN:VCALENDAR\r\n" +
+		"METHOD:REQUEST\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"SUMMARY:Meeting\r\n" +
+		"DTSTART:20140625T174600Z\r\n" +
+		"END:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"
+
 	msg := NewMessage()
 	msg.SetHeader("From", "from@example.com")
 	msg.SetHeader("To", "to@example.com")
-	msg.Attach(CreateFile("test.pdf", []byte("Content 1")))
-	msg.Attach(CreateFile("test.zip", []byte("Content 2")))
+	msg.SetBody("text/plain", "Meeting")
+	msg.AddAlternative("text/calendar; method=REQUEST; charset=UTF-8", ics, SetPartEncoding(Base64))
+	msg.Attach(CreateFile("invite.ics", []byte(ics), SetMimeType("application/ics")))
 
 	want := message{
 		from: "from@example.com",
@@ -285,51 +675,396 @@ func TestAttachmentsOnly(t *testing.T) {
 			"Content-Type: multipart/mixed; boundary=_BOUNDARY_1_\r\n" +
 			"\r\n" +
 			"--_BOUNDARY_1_\r\n" +
-			"Content-Type: application/pdf; name=\"test.pdf\"\r\n" +
-			"Content-Disposition: attachment; filename=\"test.pdf\"\r\n" +
+			"Content-Type: multipart/alternative; boundary=_BOUNDARY_2_\r\n" +
+			"\r\n" +
+			"--_BOUNDARY_2_\r\n" +
+			"Content-Type: text/plain; charset=UTF-8\r\n" +
+			"Content-Transfer-Encoding: quoted-printable\r\n" +
+			"Mime-Version: 1.0\r\n" +
+			"\r\n" +
+			"Meeting\r\n" +
+			"--_BOUNDARY_2_\r\n" +
+			"Content-Type: text/calendar; method=REQUEST; charset=UTF-8\r\n" +
 			"Content-Transfer-Encoding: base64\r\n" +
+			"Mime-Version: 1.0\r\n" +
+			"\r\n" +
+			wrapBase64(ics) + "\r\n" +
+			"--_BOUNDARY_2_--\r\n" +
 			"\r\n" +
-			base64.StdEncoding.EncodeToString([]byte("Content 1")) + "\r\n" +
 			"--_BOUNDARY_1_\r\n" +
-			"Content-Type: application/zip; name=\"test.zip\"\r\n" +
-			"Content-Disposition: attachment; filename=\"test.zip\"\r\n" +
+			"Content-Type: application/ics; name=\"invite.ics\"\r\n" +
+			"Content-Disposition: attachment; filename=\"invite.ics\"\r\n" +
 			"Content-Transfer-Encoding: base64\r\n" +
 			"\r\n" +
-			base64.StdEncoding.EncodeToString([]byte("Content 2")) + "\r\n" +
+			wrapBase64(ics) + "\r\n" +
 			"--_BOUNDARY_1_--\r\n",
 	}
 
-	testMessage(t, msg, 1, want)
+	testMessage(t, msg, 2, want)
 }
 
-func TestBase64EncodedAttachment(t *testing.T) {
-	msg := NewMessage()
-	msg.SetHeader("From", "from@example.com")
-	msg.SetHeader("To", "to@example.com")
-	content1 := "hello"
-	content2 := "goodbye"
-	content3 := "something"
-	content1Buf := make([]byte, base64.StdEncoding.EncodedLen(len(content1)))
-	base64.StdEncoding.Encode(content1Buf, []byte(content1))
-	file1 := CreateFile("test.pdf", content1Buf)
-	err := file1.SetEncoding(Unencoded)
+func TestPartContentTypeParams(t *testing.T) {
+	msg := NewMessage(SetCharset("ISO-8859-1"))
+	msg.SetBody("text/calendar; method=REQUEST", "")
+	msg.AddAlternative("text/plain; charset=UTF-8", "")
+	msg.AddAlternative("text/plain; format=flowed", "")
+	msg.AddAlternative("text/plain", "", SetContentTypeParam("format", "flowed"), SetContentTypeParam("delsp", "yes"))
+	msg.AddAlternative("text/html", "", SetContentTypeParam("x-title", "Hello, world"), SetContentTypeParam("x-empty", ""))
+
+	want := []string{
+		"text/calendar; method=REQUEST; charset=ISO-8859-1",
+		"text/plain; charset=UTF-8",
+		"text/plain; format=flowed; charset=ISO-8859-1",
+		"text/plain; charset=ISO-8859-1; format=flowed; delsp=yes",
+		"text/html; charset=ISO-8859-1; x-title=\"Hello, world\"; x-empty=\"\"",
+	}
+	if len(msg.parts) != len(want) {
+		t.Fatalf("Invalid part count, got %d, want %d", len(msg.parts), len(want))
+	}
+	for i, p := range msg.parts {
+		if got := msg.partContentType(p); got != want[i] {
+			t.Errorf("Invalid Content-Type, got %q, want %q", got, want[i])
+		}
+	}
+}
 
-	if err == nil {
-		t.Errorf("SetEncoding(%s) should have returned an error", Unencoded)
+func TestCharset(t *testing.T) {
+	for _, charset := range []string{"", "UTF-8\r\nX-Injected: yes"} {
+		msg := NewMessage(SetCharset(charset))
+		if got := msg.partContentType(part{contentType: "text/plain"}); got != "text/plain; charset=UTF-8" {
+			t.Errorf("Invalid Content-Type with charset %q, got %q", charset, got)
+		}
 	}
 
-	err = file1.SetEncoding(Base64PreEncoded)
+	msg := NewMessage(SetCharset("latin1"))
+	if msg.charset != "ISO-8859-1" {
+		t.Errorf("Invalid charset, got %q, want ISO-8859-1", msg.charset)
+	}
+	if err := msg.SetCharset("x-mac-roman"); err != nil {
+		t.Error(err)
+	}
+	if err := msg.SetCharset("utf 8"); err == nil {
+		t.Error("SetCharset should reject invalid names")
+	}
+	if msg.charset != "x-mac-roman" {
+		t.Errorf("Charset should be left unchanged, got %q", msg.charset)
+	}
+	if err := msg.SetCharset("utf8"); err != nil {
+		t.Error(err)
+	}
+	msg.SetHeader("Subject", "Café")
+	assertHeader(t, msg, "Subject", "=?UTF-8?Q?Caf=C3=A9?=")
+
+	tests := map[string]string{
+		"text/html":                     "text/html; charset=UTF-8",
+		"Text/Plain":                    "Text/Plain; charset=UTF-8",
+		"text/plain; charset=Shift_JIS": "text/plain; charset=Shift_JIS",
+		"application/json":              "application/json",
+		"image/svg+xml":                 "image/svg+xml",
+	}
+	for contentType, want := range tests {
+		if got := msg.partContentType(part{contentType: contentType}); got != want {
+			t.Errorf("Invalid Content-Type for %s, got %q, want %q", contentType, got, want)
+		}
+	}
+}
 
-	if err != nil {
-		t.Errorf("SetEncoding(%s) should not have returned an error: %s", Base64PreEncoded, err.Error())
+func TestResetMessageWriter(t *testing.T) {
+	msg := NewMessage()
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("Bcc", "bcc@example.com")
+	msg.SetBody("text/plain", "Test")
+	msg.AddAlternative("text/html", "<p>Test</p>")
+	msg.Attach(CreateFile("test.pdf", []byte("Content")))
+	if m := msg.Export(); m == nil {
+		t.Fatal("Export failed")
 	}
+	msg.Reset()
 
-	file2 := CreateFile("test.zip", []byte(content2))
-	file2.SetEncoding(Base64PreEncoded)
-	file3 := CreateFile("test.png", []byte(content3))
-	msg.Attach(file1)
-	msg.Attach(file2)
-	msg.Attach(file3)
+	// The pooled writer must not leak the state of the previous message.
+	for i := 0; i < 2; i++ {
+		msg.SetHeader("From", "from@example.com")
+		msg.SetBody("text/plain", "Test")
+		m := msg.Export()
+		if m == nil {
+			t.Fatal("Export failed")
+		}
+		if got := m.Header.Get("Content-Type"); got != "text/plain; charset=UTF-8" {
+			t.Errorf("Invalid Content-Type, got %q", got)
+		}
+		if got := m.Header.Get("Bcc"); got != "" {
+			t.Errorf("Bcc leaked from the previous message: %q", got)
+		}
+		msg.Reset()
+	}
+}
+
+func TestClone(t *testing.T) {
+	base := NewMessage(SetCharset("ISO-8859-1"), SetEncoding(Base64))
+	base.SetHeader("From", "from@example.com")
+	base.SetHeader("To", "to1@example.com", "to2@example.com")
+	base.SetBody("text/plain", "Test")
+	base.Embed(CreateFile("logo.png", []byte("Logo")))
+	base.Attach(CreateFile("test.pdf", []byte("Content")))
+	want := withoutBoundaries(sendToString(t, base))
+
+	msg := base.Clone()
+	msg.AddHeader("To", "to3@example.com")
+	msg.SetHeader("Subject", "Clone")
+	msg.AddAlternative("text/html", "<p>Test</p>")
+	msg.parts[0].body.WriteString(" modified")
+	msg.embedded[0].Content[0] = 'l'
+	msg.attachments[0].Name = "clone.pdf"
+	msg.Attach(CreateFile("other.pdf", []byte("Other")))
+
+	if got := withoutBoundaries(sendToString(t, base)); got != want {
+		t.Errorf("Base message modified by its clone, got:\n%s\nwant:\n%s", got, want)
+	}
+	if got := structure(t, msg); got != "multipart/mixed(multipart/related(multipart/alternative(text/plain,text/html),image/png),application/pdf,application/pdf)" {
+		t.Errorf("Invalid structure of the clone: %s", got)
+	}
+	assertHeader(t, msg, "To", "to1@example.com", "to2@example.com", "to3@example.com")
+
+	msg.Reset()
+	if got := withoutBoundaries(sendToString(t, base)); got != want {
+		t.Errorf("Base message modified by Reset on its clone, got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestConcurrentExport(t *testing.T) {
+	msg := NewMessage(SetContentIDRewriting(true))
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.SetHeader("Subject", "¡Hola, señor!")
+	msg.SetBody("text/plain", "¡Hola, señor!")
+	msg.AddAlternative("text/html", `<img src="cid:image.jpg">`)
+	msg.Attach(CreateFile("test.pdf", []byte("Content 1")))
+	msg.EmbedInline(CreateFile("image.jpg", []byte("Content 2")))
+	want := structure(t, msg)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if m := msg.Export(); m == nil {
+				errs <- errors.New("Export failed")
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := msg.WriteTo(ioutil.Discard); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if got := structure(t, msg); got != want {
+		t.Errorf("The message was modified, got structure %s, want %s", got, want)
+	}
+}
+
+func TestAttachmentOnly(t *testing.T) {
+	readFile = func(filename string) ([]byte, error) {
+		return []byte("Content of " + filepath.Base(filename)), nil
+	}
+
+	msg := NewMessage()
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	f, err := OpenFile("/tmp/test.pdf")
+	if err != nil {
+		panic(err)
+	}
+	msg.Attach(f)
+
+	want := message{
+		from: "from@example.com",
+		to:   []string{"to@example.com"},
+		content: "From: from@example.com\r\n" +
+			"To: to@example.com\r\n" +
+			"Content-Type: application/pdf; name=\"test.pdf\"\r\n" +
+			"Content-Disposition: attachment; filename=\"test.pdf\"\r\n" +
+			"Content-Transfer-Encoding: base64\r\n" +
+			"\r\n" +
+			base64.StdEncoding.EncodeToString([]byte("Content of test.pdf")),
+	}
+
+	testMessage(t, msg, 0, want)
+}
+
+func TestAttachment(t *testing.T) {
+	msg := NewMessage()
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.SetBody("text/plain", "Test")
+	msg.Attach(CreateFile("test.pdf", []byte("Content")))
+
+	want := message{
+		from: "from@example.com",
+		to:   []string{"to@example.com"},
+		content: "From: from@example.com\r\n" +
+			"To: to@example.com\r\n" +
+			"Content-Type: multipart/mixed; boundary=_BOUNDARY_1_\r\n" +
+			"\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			"Content-Type: text/plain; charset=UTF-8\r\n" +
+			"Content-Transfer-Encoding: quoted-printable\r\n" +
+			"Mime-Version: 1.0\r\n" +
+			"\r\n" +
+			"Test\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			"Content-Type: application/pdf; name=\"test.pdf\"\r\n" +
+			"Content-Disposition: attachment; filename=\"test.pdf\"\r\n" +
+			"Content-Transfer-Encoding: base64\r\n" +
+			"\r\n" +
+			base64.StdEncoding.EncodeToString([]byte("Content")) + "\r\n" +
+			"--_BOUNDARY_1_--\r\n",
+	}
+
+	testMessage(t, msg, 1, want)
+}
+
+func TestAttachmentsOnly(t *testing.T) {
+	msg := NewMessage()
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.Attach(CreateFile("test.pdf", []byte("Content 1")))
+	msg.Attach(CreateFile("test.zip", []byte("Content 2")))
+
+	want := message{
+		from: "from@example.com",
+		to:   []string{"to@example.com"},
+		content: "From: from@example.com\r\n" +
+			"To: to@example.com\r\n" +
+			"Content-Type: multipart/mixed; boundary=_BOUNDARY_1_\r\n" +
+			"\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			"Content-Type: application/pdf; name=\"test.pdf\"\r\n" +
+			"Content-Disposition: attachment; filename=\"test.pdf\"\r\n" +
+			"Content-Transfer-Encoding: base64\r\n" +
+			"\r\n" +
+			base64.StdEncoding.EncodeToString([]byte("Content 1")) + "\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			"Content-Type: application/zip; name=\"test.zip\"\r\n" +
+			"Content-Disposition: attachment; filename=\"test.zip\"\r\n" +
+			"Content-Transfer-Encoding: base64\r\n" +
+			"\r\n" +
+			base64.StdEncoding.EncodeToString([]byte("Content 2")) + "\r\n" +
+			"--_BOUNDARY_1_--\r\n",
+	}
+
+	testMessage(t, msg, 1, want)
+}
+
+func TestDisposition(t *testing.T) {
+	msg := NewMessage()
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.SetBody("text/plain", "Test")
+	msg.Embed(CreateFile("image.jpg", []byte("Content 1"), SetDisposition("attachment")))
+	msg.Attach(CreateFile("test.pdf", []byte("Content 2"), SetDisposition("inline")))
+	inline := CreateFile("test.png", []byte("Content 3"), SetDisposition("Inline"))
+	inline.ContentID = "image@example.com"
+	msg.Attach(inline)
+	msg.Attach(CreateFile("test.zip", []byte("Content 4")))
+
+	want := message{
+		from: "from@example.com",
+		to:   []string{"to@example.com"},
+		content: "From: from@example.com\r\n" +
+			"To: to@example.com\r\n" +
+			"Content-Type: multipart/mixed; boundary=_BOUNDARY_1_\r\n" +
+			"\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			"Content-Type: multipart/related; boundary=_BOUNDARY_2_\r\n" +
+			"\r\n" +
+			"--_BOUNDARY_2_\r\n" +
+			"Content-Type: text/plain; charset=UTF-8\r\n" +
+			"Content-Transfer-Encoding: quoted-printable\r\n" +
+			"Mime-Version: 1.0\r\n" +
+			"\r\n" +
+			"Test\r\n" +
+			"--_BOUNDARY_2_\r\n" +
+			"Content-Type: image/jpeg; name=\"image.jpg\"\r\n" +
+			"Content-Disposition: attachment; filename=\"image.jpg\"\r\n" +
+			"Content-ID: <image.jpg>\r\n" +
+			"Content-Transfer-Encoding: base64\r\n" +
+			"\r\n" +
+			base64.StdEncoding.EncodeToString([]byte("Content 1")) + "\r\n" +
+			"--_BOUNDARY_2_--\r\n" +
+			"\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			"Content-Type: application/pdf; name=\"test.pdf\"\r\n" +
+			"Content-Disposition: inline; filename=\"test.pdf\"\r\n" +
+			"Content-Transfer-Encoding: base64\r\n" +
+			"\r\n" +
+			base64.StdEncoding.EncodeToString([]byte("Content 2")) + "\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			"Content-Type: image/png; name=\"test.png\"\r\n" +
+			"Content-Disposition: inline; filename=\"test.png\"\r\n" +
+			"Content-ID: <image@example.com>\r\n" +
+			"Content-Transfer-Encoding: base64\r\n" +
+			"\r\n" +
+			base64.StdEncoding.EncodeToString([]byte("Content 3")) + "\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			"Content-Type: application/zip; name=\"test.zip\"\r\n" +
+			"Content-Disposition: attachment; filename=\"test.zip\"\r\n" +
+			"Content-Transfer-Encoding: base64\r\n" +
+			"\r\n" +
+			base64.StdEncoding.EncodeToString([]byte("Content 4")) + "\r\n" +
+			"--_BOUNDARY_1_--\r\n",
+	}
+
+	testMessage(t, msg, 2, want)
+
+	for _, d := range []string{"attachment; x=y", "inline\r\nX-Injected: 1", "form-data"} {
+		if f := CreateFile("test.pdf", nil, SetDisposition(d)); f.disposition != "" {
+			t.Errorf("SetDisposition(%q) should be ignored, got %q", d, f.disposition)
+		}
+	}
+}
+
+func TestBase64EncodedAttachment(t *testing.T) {
+	msg := NewMessage()
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	content1 := "hello"
+	content2 := "goodbye"
+	content3 := "something"
+	content1Buf := make([]byte, base64.StdEncoding.EncodedLen(len(content1)))
+	base64.StdEncoding.Encode(content1Buf, []byte(content1))
+	file1 := CreateFile("test.pdf", content1Buf)
+	err := file1.SetEncoding("uuencode")
+
+	if err == nil {
+		t.Errorf("SetEncoding(%s) should have returned an error", "uuencode")
+	}
+
+	for _, enc := range []Encoding{QuotedPrintable, Unencoded, SevenBit, AutoQuotedPrintable} {
+		if err := file1.SetEncoding(enc); err != nil {
+			t.Errorf("SetEncoding(%s) should not have returned an error: %s", enc, err.Error())
+		}
+	}
+
+	err = file1.SetEncoding(Base64PreEncoded)
+
+	if err != nil {
+		t.Errorf("SetEncoding(%s) should not have returned an error: %s", Base64PreEncoded, err.Error())
+	}
+
+	file2 := CreateFile("test.zip", []byte(content2))
+	file2.SetEncoding(Base64PreEncoded)
+	file3 := CreateFile("test.png", []byte(content3))
+	msg.Attach(file1)
+	msg.Attach(file2)
+	msg.Attach(file3)
 
 	want := message{
 		from: "from@example.com",
@@ -362,6 +1097,91 @@ func TestBase64EncodedAttachment(t *testing.T) {
 	testMessage(t, msg, 1, want)
 }
 
+func TestPreEncoded(t *testing.T) {
+	png := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0, 1, 2, 3}, 40)...)
+	encoded := base64.StdEncoding.EncodeToString(png)
+
+	newMessage := func() *Message {
+		msg := NewMessage()
+		msg.SetHeader("From", "from@example.com")
+		msg.SetHeader("To", "to@example.com")
+		msg.SetBody("text/html", `<img src="cid:image.png">`)
+		return msg
+	}
+
+	want := newMessage()
+	want.Attach(CreateFile("report.bin", png, SetMimeType("application/octet-stream")))
+	want.Embed(CreateFile("image.png", png))
+
+	msg := newMessage()
+	if err := msg.AttachPreEncoded("report.bin", "application/octet-stream", []byte(encoded)); err != nil {
+		t.Fatal(err)
+	}
+	// The content type is detected and line breaks are ignored.
+	if err := msg.EmbedPreEncoded("image.png", "", []byte(encoded[:50]+"\r\n"+encoded[50:])); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := withoutBoundaries(sendToString(t, msg)), withoutBoundaries(sendToString(t, want)); got != want {
+		t.Errorf("Invalid message, got:\n%s\nwant:\n%s", got, want)
+	}
+
+	if err := msg.AttachPreEncoded("invalid.bin", "", []byte("not base64!")); err == nil {
+		t.Error("Invalid base64 content should be rejected")
+	}
+	if err := msg.EmbedPreEncoded("invalid.png", "image/png", []byte("iVBORw0KGgo")); err == nil {
+		t.Error("Truncated base64 content should be rejected")
+	}
+}
+
+func TestBase64PreEncodedRewrap(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789"), 30)
+	encoded := base64.StdEncoding.EncodeToString(payload)
+
+	// Wrap the content at irregular line lengths with mixed line breaks.
+	pre := new(bytes.Buffer)
+	for i, n := 0, 10; i < len(encoded); i, n = i+n, n+37 {
+		if i+n > len(encoded) {
+			n = len(encoded) - i
+		}
+		pre.WriteString(encoded[i : i+n])
+		if n%2 == 0 {
+			pre.WriteString("\r\n")
+		} else {
+			pre.WriteString(" \n\t")
+		}
+	}
+
+	msg := NewMessage()
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	f := CreateFile("test.bin", pre.Bytes())
+	if err := f.SetEncoding(Base64PreEncoded); err != nil {
+		t.Fatal(err)
+	}
+	msg.Attach(f)
+
+	out := sendToString(t, msg)
+	i := strings.Index(out, "\r\n\r\n")
+	if i < 0 {
+		t.Fatalf("Missing body in:\n%s", out)
+	}
+	body := strings.TrimSuffix(out[i+4:], "\r\n")
+
+	for _, line := range strings.Split(body, "\r\n") {
+		if len(line) > maxLineLen {
+			t.Errorf("Line of %d characters: %q", len(line), line)
+		}
+	}
+	got, err := base64.StdEncoding.DecodeString(strings.Replace(body, "\r\n", "", -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Errorf("Invalid decoded content, got %q, want %q", got, payload)
+	}
+}
+
 func TestAttachments(t *testing.T) {
 	msg := NewMessage()
 	msg.SetHeader("From", "from@example.com")
@@ -511,57 +1331,1654 @@ func TestFullMessage(t *testing.T) {
 	testMessage(t, msg, 3, want)
 }
 
-func TestQpLineLength(t *testing.T) {
+func TestEmptyBody(t *testing.T) {
 	msg := NewMessage()
 	msg.SetHeader("From", "from@example.com")
 	msg.SetHeader("To", "to@example.com")
-	msg.SetBody("text/plain",
-		strings.Repeat("0", 77)+"\r\n"+
-			strings.Repeat("0", 76)+"à\r\n"+
-			strings.Repeat("0", 75)+"à\r\n"+
-			strings.Repeat("0", 74)+"à\r\n"+
-			strings.Repeat("0", 73)+"à\r\n"+
-			strings.Repeat("0", 76)+"\r\n"+
-			strings.Repeat("0", 77)+"\n")
+	msg.SetHeader("Subject", "Ping")
 
 	want := message{
 		from: "from@example.com",
 		to:   []string{"to@example.com"},
 		content: "From: from@example.com\r\n" +
 			"To: to@example.com\r\n" +
+			"Subject: Ping\r\n" +
 			"Content-Type: text/plain; charset=UTF-8\r\n" +
-			"Content-Transfer-Encoding: quoted-printable\r\n" +
-			"\r\n" +
-			strings.Repeat("0", 76) + "=\r\n0\r\n" +
-			strings.Repeat("0", 76) + "=\r\n=C3=A0\r\n" +
-			strings.Repeat("0", 75) + "=\r\n=C3=A0\r\n" +
-			strings.Repeat("0", 74) + "=\r\n=C3=A0\r\n" +
-			strings.Repeat("0", 73) + "=C3=\r\n=A0\r\n" +
-			strings.Repeat("0", 76) + "\r\n" +
-			strings.Repeat("0", 76) + "=\r\n0\n",
+			"Content-Transfer-Encoding: 7bit\r\n" +
+			"\r\n",
 	}
-
 	testMessage(t, msg, 0, want)
-}
 
-func TestBase64LineLength(t *testing.T) {
+	m, err := mail.ReadMessage(strings.NewReader(sendToString(t, msg)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.Header.Get("Content-Type"); got != "text/plain; charset=UTF-8" {
+		t.Errorf("Invalid Content-Type, got %q", got)
+	}
+	if body, err := io.ReadAll(m.Body); err != nil || len(body) != 0 {
+		t.Errorf("The body should be empty, got %q (%v)", body, err)
+	}
+
+	// A reused message must not keep the structure of its previous content.
+	msg.SetBody("text/html", "<p>Hello</p>")
+	msg.Attach(CreateFile("test.pdf", []byte("Content")))
+	msg.Reset()
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.SetHeader("Subject", "Ping")
+	testMessage(t, msg, 0, want)
+
+	// An explicit Content-Type is kept.
+	msg.SetHeader("Content-Type", "message/delivery-status")
+	if got := msg.Export().Header.Get("Content-Type"); got != "message/delivery-status" {
+		t.Errorf("The Content-Type set in the header should be kept, got %q", got)
+	}
+}
+
+func TestAttachReader(t *testing.T) {
+	pdf := "%PDF-1.4\n" + strings.Repeat("0", 600)
+	zip := "\x00\x01\x02"
+
+	msg := NewMessage()
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	if err := msg.AttachReader("report", strings.NewReader(pdf)); err != nil {
+		t.Fatal(err)
+	}
+	if err := msg.AttachReader("archive.zip", strings.NewReader(zip)); err != nil {
+		t.Fatal(err)
+	}
+	if err := msg.EmbedReader("image.jpg", strings.NewReader("Content"), SetMimeType("image/png")); err != nil {
+		t.Fatal(err)
+	}
+
+	want := message{
+		from: "from@example.com",
+		to:   []string{"to@example.com"},
+		content: "From: from@example.com\r\n" +
+			"To: to@example.com\r\n" +
+			"Content-Type: multipart/mixed; boundary=_BOUNDARY_1_\r\n" +
+			"\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			"Content-Type: image/png; name=\"image.jpg\"\r\n" +
+			"Content-Disposition: inline; filename=\"image.jpg\"\r\n" +
+			"Content-ID: <image.jpg>\r\n" +
+			"Content-Transfer-Encoding: base64\r\n" +
+			"\r\n" +
+			base64.StdEncoding.EncodeToString([]byte("Content")) + "\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			"Content-Type: application/pdf; name=\"report\"\r\n" +
+			"Content-Disposition: attachment; filename=\"report\"\r\n" +
+			"Content-Transfer-Encoding: base64\r\n" +
+			"\r\n" +
+			wrapBase64(pdf) + "\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			"Content-Type: application/zip; name=\"archive.zip\"\r\n" +
+			"Content-Disposition: attachment; filename=\"archive.zip\"\r\n" +
+			"Content-Transfer-Encoding: base64\r\n" +
+			"\r\n" +
+			base64.StdEncoding.EncodeToString([]byte(zip)) + "\r\n" +
+			"--_BOUNDARY_1_--\r\n",
+	}
+
+	testMessage(t, msg, 1, want)
+}
+
+func wrapBase64(s string) string {
+	enc := base64.StdEncoding.EncodeToString([]byte(s))
+	var lines []string
+	for len(enc) > 76 {
+		lines = append(lines, enc[:76])
+		enc = enc[76:]
+	}
+
+	return strings.Join(append(lines, enc), "\r\n")
+}
+
+func TestFileEncoding(t *testing.T) {
+	msg := NewMessage()
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.Attach(CreateFile("test.csv", []byte("café,thé"), SetMimeType("text/csv"), SetFileEncoding(QuotedPrintable)))
+	msg.Attach(CreateFile("test.zip", []byte("Content"), SetFileEncoding(Unencoded)))
+
+	want := message{
+		from: "from@example.com",
+		to:   []string{"to@example.com"},
+		content: "From: from@example.com\r\n" +
+			"To: to@example.com\r\n" +
+			"Content-Type: multipart/mixed; boundary=_BOUNDARY_1_\r\n" +
+			"\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			"Content-Type: text/csv; name=\"test.csv\"\r\n" +
+			"Content-Disposition: attachment; filename=\"test.csv\"\r\n" +
+			"Content-Transfer-Encoding: quoted-printable\r\n" +
+			"\r\n" +
+			"caf=C3=A9,th=C3=A9\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			"Content-Type: application/zip; name=\"test.zip\"\r\n" +
+			"Content-Disposition: attachment; filename=\"test.zip\"\r\n" +
+			"Content-Transfer-Encoding: 8bit\r\n" +
+			"\r\n" +
+			"Content\r\n" +
+			"--_BOUNDARY_1_--\r\n",
+	}
+
+	testMessage(t, msg, 1, want)
+}
+
+func TestFileAutoQuotedPrintable(t *testing.T) {
+	csv := "name,city\r\nAlex,Zürich\r\nBob,Paris\r\n"
+	bin := []byte{0x89, 'P', 'N', 'G', 0, 1, 2, 0xff, 0xfe, 0xfd}
+
+	msg := NewMessage()
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.Attach(CreateFile("test.csv", []byte(csv), SetMimeType("text/csv"), SetFileEncoding(AutoQuotedPrintable)))
+	msg.Attach(CreateFile("test.png", bin, SetFileEncoding(AutoQuotedPrintable)))
+
+	m, err := mail.ReadMessage(strings.NewReader(sendToString(t, msg)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := multipart.NewReader(m.Body, params["boundary"])
+
+	tests := []struct {
+		encoding string
+		content  []byte
+	}{
+		{"quoted-printable", []byte(csv)},
+		{"base64", bin},
+	}
+	for _, test := range tests {
+		p, err := r.NextRawPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := p.Header.Get("Content-Transfer-Encoding"); got != test.encoding {
+			t.Errorf("Invalid encoding of %s, got %q, want %q", p.FileName(), got, test.encoding)
+		}
+		var dec io.Reader
+		if test.encoding == "base64" {
+			dec = base64.NewDecoder(base64.StdEncoding, p)
+		} else {
+			dec = qp.NewReader(p)
+		}
+		got, err := ioutil.ReadAll(dec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, test.content) {
+			t.Errorf("Invalid content of %s, got %q, want %q", p.FileName(), got, test.content)
+		}
+	}
+}
+
+func TestCRLFNormalization(t *testing.T) {
+	text := "line 1\nline 2\rline 3\r\n\nline 5"
+	want := "line 1\r\nline 2\r\nline 3\r\n\r\nline 5"
+
+	msg := NewMessage(SetCRLFNormalization(true))
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.SetBody("text/plain", text)
+	msg.AddAlternativeWriter("text/html", func(w io.Writer) error {
+		// The CRLF is split between two writes.
+		io.WriteString(w, "line 1\nline 2\r")
+		_, err := io.WriteString(w, "\nline 3\n")
+		return err
+	})
+	msg.Attach(CreateFile("test.txt", []byte(text)))
+	msg.Attach(CreateFile("test.bin", []byte(text)))
+	msg.Attach(CreateFile("test.log", []byte(text), SetMimeType("application/octet-stream"), SetFileCRLFNormalization(true)))
+	if err := msg.AttachReader("reader.txt", strings.NewReader(text), SetMimeType("text/plain")); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := mail.ReadMessage(strings.NewReader(sendToString(t, msg)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := multipart.NewReader(m.Body, params["boundary"])
+	alt, err := r.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, params, err = mime.ParseMediaType(alt.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ar := multipart.NewReader(alt, params["boundary"])
+
+	tests := []struct {
+		r    *multipart.Reader
+		want string
+	}{
+		{ar, want},
+		{ar, "line 1\r\nline 2\r\nline 3\r\n"},
+		{r, want},
+		{r, text},
+		{r, want},
+		{r, want},
+	}
+	for i, test := range tests {
+		// NextPart decodes quoted-printable but not base64.
+		p, err := test.r.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body io.Reader = p
+		if p.Header.Get("Content-Transfer-Encoding") == "base64" {
+			body = base64.NewDecoder(base64.StdEncoding, p)
+		}
+		got, err := ioutil.ReadAll(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != test.want {
+			t.Errorf("Invalid content of part %d, got %q, want %q", i, got, test.want)
+		}
+	}
+}
+
+func TestDispositionParams(t *testing.T) {
+	msg := NewMessage()
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.Attach(CreateFile("test.pdf", []byte("Content"),
+		SetFileSize(7),
+		SetFileCreationDate(stubNow()),
+		SetFileModDate(stubNow().Add(time.Hour)),
+	))
+
+	want := message{
+		from: "from@example.com",
+		to:   []string{"to@example.com"},
+		content: "From: from@example.com\r\n" +
+			"To: to@example.com\r\n" +
+			"Content-Type: application/pdf; name=\"test.pdf\"\r\n" +
+			"Content-Disposition: attachment; filename=\"test.pdf\"; size=7; " +
+			"creation-date=\"Wed, 25 Jun 2014 17:46:00 +0000\"; " +
+			"modification-date=\"Wed, 25 Jun 2014 18:46:00 +0000\"\r\n" +
+			"Content-Transfer-Encoding: base64\r\n" +
+			"\r\n" +
+			base64.StdEncoding.EncodeToString([]byte("Content")),
+	}
+
+	testMessage(t, msg, 0, want)
+
+	f := CreateFile("test.pdf", nil, SetFileSize(0))
+	if got, want := dispositionParams(f), "; size=0"; got != want {
+		t.Errorf("Invalid parameters, got %q, want %q", got, want)
+	}
+}
+
+func TestFileDescription(t *testing.T) {
+	msg := NewMessage()
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.Attach(CreateFile("test.pdf", []byte("Content"), SetDescription("Relevé de compte")))
+	msg.Attach(CreateFile("test.txt", []byte("Content"), SetDescription(
+		"A rather long description of the attachment\r\nthat does not fit on a single header line")))
+
+	want := message{
+		from: "from@example.com",
+		to:   []string{"to@example.com"},
+		content: "From: from@example.com\r\n" +
+			"To: to@example.com\r\n" +
+			"Content-Type: multipart/mixed; boundary=_BOUNDARY_1_\r\n" +
+			"\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			"Content-Type: application/pdf; name=\"test.pdf\"\r\n" +
+			"Content-Description: =?UTF-8?Q?Relev=C3=A9_de_compte?=\r\n" +
+			"Content-Disposition: attachment; filename=\"test.pdf\"\r\n" +
+			"Content-Transfer-Encoding: base64\r\n" +
+			"\r\n" +
+			base64.StdEncoding.EncodeToString([]byte("Content")) + "\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			"Content-Type: text/plain; charset=utf-8; name=\"test.txt\"\r\n" +
+			"Content-Description: A rather long description of the attachmentthat does not\r\n" +
+			" fit on a single header line\r\n" +
+			"Content-Disposition: attachment; filename=\"test.txt\"\r\n" +
+			"Content-Transfer-Encoding: base64\r\n" +
+			"\r\n" +
+			base64.StdEncoding.EncodeToString([]byte("Content")) + "\r\n" +
+			"--_BOUNDARY_1_--\r\n",
+	}
+
+	testMessage(t, msg, 1, want)
+}
+
+func TestFileHeader(t *testing.T) {
+	msg := NewMessage()
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.SetBody("text/plain", "Test")
+	msg.Attach(CreateFile("test.pdf", []byte("Content"),
+		SetFileHeader("content-description", "Relevé de compte"),
+		SetFileHeader("X-Attachment-Id", "1", "2"),
+		SetFileHeader("Content-Transfer-Encoding", "8bit"),
+		SetFileHeader("Content-Disposition", "inline"),
+	))
+
+	want := message{
+		from: "from@example.com",
+		to:   []string{"to@example.com"},
+		content: "From: from@example.com\r\n" +
+			"To: to@example.com\r\n" +
+			"Content-Type: multipart/mixed; boundary=_BOUNDARY_1_\r\n" +
+			"\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			"Content-Type: text/plain; charset=UTF-8\r\n" +
+			"Content-Transfer-Encoding: quoted-printable\r\n" +
+			"Mime-Version: 1.0\r\n" +
+			"\r\n" +
+			"Test\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			"Content-Type: application/pdf; name=\"test.pdf\"\r\n" +
+			"Content-Description: =?UTF-8?Q?Relev=C3=A9_de_compte?=\r\n" +
+			"Content-Disposition: attachment; filename=\"test.pdf\"\r\n" +
+			"Content-Transfer-Encoding: base64\r\n" +
+			"X-Attachment-Id: 1\r\n" +
+			"X-Attachment-Id: 2\r\n" +
+			"\r\n" +
+			base64.StdEncoding.EncodeToString([]byte("Content")) + "\r\n" +
+			"--_BOUNDARY_1_--\r\n",
+	}
+
+	testMessage(t, msg, 1, want)
+}
+
+func TestFileNameEscaping(t *testing.T) {
+	msg := NewMessage()
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.Attach(CreateFile("evil\".pdf\r\nX-Injected: yes", []byte("Content")))
+
+	want := message{
+		from: "from@example.com",
+		to:   []string{"to@example.com"},
+		content: "From: from@example.com\r\n" +
+			"To: to@example.com\r\n" +
+			"Content-Type: application/octet-stream; name=\"evil\\\".pdfX-Injected: yes\"\r\n" +
+			"Content-Disposition: attachment; filename=\"evil\\\".pdfX-Injected: yes\"\r\n" +
+			"Content-Transfer-Encoding: base64\r\n" +
+			"\r\n" +
+			base64.StdEncoding.EncodeToString([]byte("Content")),
+	}
+
+	testMessage(t, msg, 0, want)
+}
+
+func TestLongFileName(t *testing.T) {
+	names := []string{
+		"report.pdf",
+		"Relevé de compte.pdf",
+		strings.Repeat("long \"quoted\" name ", 10) + "report.pdf",
+		strings.Repeat("très long nom ", 15) + ".pdf",
+	}
+
+	msg := NewMessage()
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.SetBody("text/plain", "Test")
+	for _, name := range names {
+		msg.Attach(CreateFile(name, []byte("Content"), SetMimeType("application/pdf")))
+	}
+	if len(names[2]) != 200 {
+		t.Fatalf("The file name should be 200 characters long, got %d", len(names[2]))
+	}
+
+	out := sendToString(t, msg)
+	for _, line := range strings.Split(out, "\r\n") {
+		if len(line) > maxHeaderLineLen && !strings.Contains(line, "boundary=") {
+			t.Errorf("Line of %d characters: %q", len(line), line)
+		}
+	}
+	if !strings.Contains(out, "Content-Type: application/pdf; name=\"report.pdf\"\r\n") {
+		t.Errorf("Short names should not be split:\n%s", out)
+	}
+	if !strings.Contains(out, "Content-Disposition: attachment;\r\n filename*=UTF-8''Relev%C3%A9%20de%20compte.pdf\r\n") {
+		t.Errorf("Non-ASCII names should be encoded:\n%s", out)
+	}
+
+	m, err := mail.ReadMessage(strings.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := multipart.NewReader(m.Body, params["boundary"])
+	if _, err := r.NextPart(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		p, err := r.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, field := range []string{"Content-Type", "Content-Disposition"} {
+			_, params, err := mime.ParseMediaType(p.Header.Get(field))
+			if err != nil {
+				t.Fatalf("Invalid %s header: %v", field, err)
+			}
+			if got := params["name"] + params["filename"]; got != name {
+				t.Errorf("Invalid file name in %s, got %q, want %q", field, got, name)
+			}
+		}
+	}
+}
+
+func TestStructure(t *testing.T) {
+	tests := []struct {
+		parts, embedded, attachments int
+		want                         string
+	}{
+		{2, 1, 1, "multipart/mixed(multipart/related(multipart/alternative(text/plain,text/html),image/jpeg),application/pdf)"},
+		{2, 2, 2, "multipart/mixed(multipart/related(multipart/alternative(text/plain,text/html),image/jpeg,image/jpeg),application/pdf,application/pdf)"},
+		{1, 1, 1, "multipart/mixed(multipart/related(text/plain,image/jpeg),application/pdf)"},
+		{0, 1, 1, "multipart/mixed(image/jpeg,application/pdf)"},
+		{0, 2, 1, "multipart/mixed(multipart/related(image/jpeg,image/jpeg),application/pdf)"},
+		{2, 0, 1, "multipart/mixed(multipart/alternative(text/plain,text/html),application/pdf)"},
+	}
+
+	for _, test := range tests {
+		msg := NewMessage()
+		msg.SetHeader("From", "from@example.com")
+		msg.SetHeader("To", "to@example.com")
+		addContent(msg, test.parts, test.embedded, test.attachments)
+
+		if got := structure(t, msg); got != test.want {
+			t.Errorf("Invalid structure for %d parts, %d embedded and %d attachments,\ngot  %s\nwant %s",
+				test.parts, test.embedded, test.attachments, got, test.want)
+		}
+	}
+}
+
+func TestAddPart(t *testing.T) {
+	msg := NewMessage()
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.SetBody("text/plain", "See the forwarded message.")
+	msg.AddPart("message/rfc822", []byte("Subject: Café\r\n\r\nTest"), map[string][]string{
+		"content-description":       {"Message transféré"},
+		"Content-Type":              {"text/plain"},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	msg.AddPart("application/json", []byte(`{"id":1}`), nil, SetPartEncoding(Base64))
+
+	want := message{
+		from: "from@example.com",
+		to:   []string{"to@example.com"},
+		content: "From: from@example.com\r\n" +
+			"To: to@example.com\r\n" +
+			"Content-Type: multipart/mixed; boundary=_BOUNDARY_1_\r\n" +
+			"\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			"Content-Type: text/plain; charset=UTF-8\r\n" +
+			"Content-Transfer-Encoding: quoted-printable\r\n" +
+			"Mime-Version: 1.0\r\n" +
+			"\r\n" +
+			"See the forwarded message.\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			"Content-Type: message/rfc822\r\n" +
+			"Content-Description: =?UTF-8?Q?Message_transf=C3=A9r=C3=A9?=\r\n" +
+			"Content-Transfer-Encoding: 8bit\r\n" +
+			"Mime-Version: 1.0\r\n" +
+			"\r\n" +
+			"Subject: Café\r\n" +
+			"\r\n" +
+			"Test\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			"Content-Type: application/json\r\n" +
+			"Content-Transfer-Encoding: base64\r\n" +
+			"Mime-Version: 1.0\r\n" +
+			"\r\n" +
+			base64.StdEncoding.EncodeToString([]byte(`{"id":1}`)) + "\r\n" +
+			"--_BOUNDARY_1_--\r\n",
+	}
+
+	testMessage(t, msg, 1, want)
+
+	msg.Embed(CreateFile("image.jpg", []byte("Content")))
+	msg.Attach(CreateFile("test.pdf", []byte("Content")))
+	want2 := "multipart/mixed(multipart/related(text/plain,image/jpeg),message/rfc822,application/json,application/pdf)"
+	if got := structure(t, msg); got != want2 {
+		t.Errorf("Invalid structure, got %s, want %s", got, want2)
+	}
+}
+
+func TestAddRawPart(t *testing.T) {
+	msg := NewMessage()
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.SetBody("text/plain", "See the invitation.")
+	invite := "BEGIN:VCALENDAR\r\n" +
+		"SUMMARY:Caf=C3=A9 =\r\n" +
+		"at noon\r\n" +
+		"DESCRIPTION:" + strings.Repeat("a", 1200) + "\r\n" +
+		"END:VCALENDAR"
+	msg.AddRawPart(map[string][]string{
+		"content-type": {"text/calendar; method=REQUEST; charset=UTF-8"},
+		"X-Custom":     {"a  b\t=?c?="},
+	}, []byte(invite), QuotedPrintable)
+
+	want := message{
+		from: "from@example.com",
+		to:   []string{"to@example.com"},
+		content: "From: from@example.com\r\n" +
+			"To: to@example.com\r\n" +
+			"Content-Type: multipart/mixed; boundary=_BOUNDARY_1_\r\n" +
+			"\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			"Content-Type: text/plain; charset=UTF-8\r\n" +
+			"Content-Transfer-Encoding: quoted-printable\r\n" +
+			"Mime-Version: 1.0\r\n" +
+			"\r\n" +
+			"See the invitation.\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			"Content-Transfer-Encoding: quoted-printable\r\n" +
+			"Content-Type: text/calendar; method=REQUEST; charset=UTF-8\r\n" +
+			"X-Custom: a  b\t=?c?=\r\n" +
+			"\r\n" +
+			invite + "\r\n" +
+			"--_BOUNDARY_1_--\r\n",
+	}
+
+	testMessage(t, msg, 1, want)
+
+	parts := msg.Parts()
+	if len(parts) != 2 || parts[1].ContentType != "text/calendar; method=REQUEST; charset=UTF-8" || parts[1].Encoding != QuotedPrintable {
+		t.Errorf("Invalid parts, got %+v", parts)
+	}
+}
+
+func TestAttachMessage(t *testing.T) {
+	original := NewMessage()
+	original.SetHeader("From", "alex@example.com")
+	original.SetHeader("To", "bob@example.com")
+	original.SetHeader("Bcc", "secret@example.com")
+	original.SetHeader("Subject", "Café")
+	original.SetBody("text/plain", "Hello Bob!")
+	original.AddAlternative("text/html", "<p>Hello Bob!</p>")
+
+	msg := NewMessage()
+	msg.SetHeader("From", "bob@example.com")
+	msg.SetHeader("To", "cora@example.com")
+	msg.SetHeader("Subject", "Fwd: Café")
+	if err := msg.AttachMessage("original.eml", original); err != nil {
+		t.Fatal(err)
+	}
+	if got := structure(t, msg); got != "multipart/mixed(message/rfc822)" {
+		t.Errorf("Invalid structure: %s", got)
+	}
+	msg.SetBody("text/plain", "See the forwarded message.")
+
+	m, err := mail.ReadMessage(strings.NewReader(sendToString(t, msg)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := multipart.NewReader(m.Body, params["boundary"])
+	var nested *mail.Message
+	for {
+		p, err := r.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Header.Get("Content-Type") != "message/rfc822" {
+			continue
+		}
+		if got := p.Header.Get("Content-Transfer-Encoding"); got != "7bit" {
+			t.Errorf("Invalid Content-Transfer-Encoding, got %q, want 7bit", got)
+		}
+		if got := p.Header.Get("Content-Disposition"); got != `attachment; filename="original.eml"` {
+			t.Errorf("Invalid Content-Disposition, got %q", got)
+		}
+		if nested, err = mail.ReadMessage(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if nested == nil {
+		t.Fatal("The forwarded message is missing")
+	}
+
+	dec := new(mime.WordDecoder)
+	subject, err := dec.DecodeHeader(nested.Header.Get("Subject"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"From":    "alex@example.com",
+		"To":      "bob@example.com",
+		"Bcc":     "",
+		"Subject": "Café",
+	}
+	got := map[string]string{
+		"From":    nested.Header.Get("From"),
+		"To":      nested.Header.Get("To"),
+		"Bcc":     nested.Header.Get("Bcc"),
+		"Subject": subject,
+	}
+	for field := range want {
+		if got[field] != want[field] {
+			t.Errorf("Invalid %s header of the forwarded message, got %q, want %q", field, got[field], want[field])
+		}
+	}
+	if !strings.HasPrefix(nested.Header.Get("Content-Type"), "multipart/alternative;") {
+		t.Errorf("Invalid Content-Type of the forwarded message, got %q", nested.Header.Get("Content-Type"))
+	}
+}
+
+func TestSetHTMLBody(t *testing.T) {
+	msg := NewMessage()
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.SetHTMLBody(`<html><head><title>Title</title><style>p { color: red; }</style></head>
+<body>
+  <h1>Hello&nbsp;Bob</h1>
+  <!-- A <b>comment</b> -->
+  <p>Welcome to <b>gomail</b> &amp;
+     enjoy!<br>See you.</p>
+  <ul><li>One</li><li>Two</li></ul>
+  <script type="text/javascript">if (a < b) {}</script>
+</body></html>`)
+
+	if got := structure(t, msg); got != "multipart/alternative(text/plain,text/html)" {
+		t.Errorf("Invalid structure: %s", got)
+	}
+	text := msg.parts[0].body.String()
+	if strings.ContainsAny(text, "<>") {
+		t.Errorf("The text body should not have tags:\n%s", text)
+	}
+	want := "Hello\u00a0Bob\n\nWelcome to gomail & enjoy!\nSee you.\n\n- One\n- Two"
+	if text != want {
+		t.Errorf("Invalid text body, got:\n%q\nwant:\n%q", text, want)
+	}
+
+	msg.SetBody("text/plain", "Custom text")
+	msg.AddAlternative("text/html", "<p>Old</p>")
+	msg.SetHTMLBody("<p>New</p>")
+	if got := structure(t, msg); got != "multipart/alternative(text/plain,text/html)" {
+		t.Errorf("Invalid structure: %s", got)
+	}
+	if got := msg.parts[0].body.String(); got != "Custom text" {
+		t.Errorf("The text body should be kept, got %q", got)
+	}
+	if got := msg.parts[1].body.String(); got != "<p>New</p>" {
+		t.Errorf("Invalid HTML body, got %q", got)
+	}
+}
+
+func TestSetFlowedBody(t *testing.T) {
+	msg := NewMessage()
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.SetFlowedBody("Lorem ipsum dolor sit amet, consectetur adipiscing elit, sed do eiusmod tempor incididunt ut labore.   \n" +
+		"> Not a quote\n" +
+		"From here\n" +
+		"  Indented\n" +
+		"Café " + strings.Repeat("é", 80) + " end\n" +
+		"\n" +
+		"-- \n" +
+		"Bob")
+
+	want := "Lorem ipsum dolor sit amet, consectetur adipiscing elit, sed do eiusmod \r\n" +
+		"tempor incididunt ut labore.\r\n" +
+		" > Not a quote\r\n" +
+		" From here\r\n" +
+		"   Indented\r\n" +
+		"Café \r\n" +
+		strings.Repeat("é", 80) + " \r\n" +
+		"end\r\n" +
+		"\r\n" +
+		"-- \r\n" +
+		"Bob"
+	if got := msg.parts[0].body.String(); got != want {
+		t.Errorf("Invalid flowed body, got:\n%q\nwant:\n%q", got, want)
+	}
+
+	m, err := msg.ExportWithError()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := m.Header.Get("Content-Type"), "text/plain; charset=UTF-8; format=flowed"; got != want {
+		t.Errorf("Invalid Content-Type, got %q, want %q", got, want)
+	}
+	if got := m.Header.Get("Content-Transfer-Encoding"); got != "quoted-printable" {
+		t.Errorf("Invalid Content-Transfer-Encoding, got %q", got)
+	}
+	body, err := ioutil.ReadAll(qp.NewReader(m.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != want {
+		t.Errorf("The trailing spaces should be kept, got:\n%q\nwant:\n%q", body, want)
+	}
+}
+
+func TestMultipartStructure(t *testing.T) {
+	tests := []struct {
+		parts, embedded, attachments int
+		multipart                    Multipart
+		want                         string
+	}{
+		{0, 1, 0, MultipartRelated, "multipart/related(image/jpeg)"},
+		{1, 0, 0, MultipartAlternative, "multipart/alternative(text/plain)"},
+		{1, 0, 0, MultipartMixed, "multipart/mixed(text/plain)"},
+		{1, 0, 0, MultipartMixed | MultipartRelated | MultipartAlternative, "multipart/mixed(multipart/related(multipart/alternative(text/plain)))"},
+		{1, 1, 0, MultipartMixed, "multipart/mixed(multipart/related(text/plain,image/jpeg))"},
+		{2, 0, 1, MultipartRelated, "multipart/mixed(multipart/related(multipart/alternative(text/plain,text/html)),application/pdf)"},
+		{2, 1, 1, 0, "multipart/mixed(multipart/related(multipart/alternative(text/plain,text/html),image/jpeg),application/pdf)"},
+	}
+
+	for _, test := range tests {
+		msg := NewMessage()
+		msg.SetHeader("From", "from@example.com")
+		msg.SetHeader("To", "to@example.com")
+		addContent(msg, test.parts, test.embedded, test.attachments)
+		msg.SetMultipartStructure(test.multipart)
+
+		if got := structure(t, msg); got != test.want {
+			t.Errorf("Invalid structure for %d parts, %d embedded, %d attachments and %d,\ngot  %s\nwant %s",
+				test.parts, test.embedded, test.attachments, test.multipart, got, test.want)
+		}
+	}
+}
+
+func TestSetBoundaries(t *testing.T) {
+	msg := NewMessage()
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	addContent(msg, 2, 0, 1)
+	if got := msg.Boundaries(); got != nil {
+		t.Errorf("Boundaries before the export should be nil, got %q", got)
+	}
+	if err := msg.SetBoundaries("mixed"); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	if _, err := msg.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	got := msg.Boundaries()
+	if len(got) != 2 || got[0] != "mixed" || got[1] == "mixed" {
+		t.Fatalf("Invalid boundaries, got %q", got)
+	}
+	for _, b := range got {
+		if !strings.Contains(buf.String(), "boundary="+b+"\r\n") {
+			t.Errorf("Boundary %q is not used in the message:\n%s", b, buf.String())
+		}
+	}
+	if !strings.Contains(buf.String(), "\r\n--mixed--\r\n") {
+		t.Errorf("The mixed part should be closed with its boundary:\n%s", buf.String())
+	}
+
+	msg.SetSignature(&stubSigner{sig: &Signature{Protocol: pgpSignature, Micalg: "pgp-sha256"}})
+	if err := msg.SetBoundaries("signed", "mixed", "alternative"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := msg.WriteTo(new(bytes.Buffer)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(msg.Boundaries(), ","), "signed,mixed,alternative"; got != want {
+		t.Errorf("Invalid boundaries of the signed message, got %q, want %q", got, want)
+	}
+	if c := msg.Clone(); c.Boundaries() != nil {
+		t.Errorf("A clone should not have boundaries before its export, got %q", c.Boundaries())
+	}
+
+	for _, boundaries := range [][]string{{""}, {"a b"}, {strings.Repeat("a", 70)}, {"a", "b", "a"}} {
+		if err := msg.SetBoundaries(boundaries...); err == nil {
+			t.Errorf("Boundaries %q should be rejected", boundaries)
+		}
+	}
+}
+
+func TestSetPartHook(t *testing.T) {
+	msg := NewMessage()
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.SetBody("text/plain", "Hello")
+	msg.AddAlternative("text/html", "<p>Hello</p>")
+	msg.Attach(CreateFile("secret.pdf", []byte("Content")))
+
+	var types []string
+	msg.SetPartHook(func(h map[string][]string) {
+		mediaType, _, _ := mime.ParseMediaType(h["Content-Type"][0])
+		types = append(types, mediaType)
+		h["X-Scanned"] = []string{"yes"}
+		if _, ok := h["Content-Disposition"]; ok {
+			h["Content-Disposition"] = []string{"attachment"}
+			h["Content-Type"] = []string{"application/pdf"}
+		}
+	})
+	out := sendToString(t, msg)
+
+	want := "multipart/mixed,multipart/alternative,text/plain,text/html,application/pdf"
+	if got := strings.Join(types, ","); got != want {
+		t.Errorf("Invalid order of the parts, got %q, want %q", got, want)
+	}
+	if got := strings.Count(out, "X-Scanned: yes\r\n"); got != 5 {
+		t.Errorf("Every part should be stamped, got %d stamps:\n%s", got, out)
+	}
+	if strings.Contains(out, "secret.pdf") {
+		t.Errorf("The file name should be redacted:\n%s", out)
+	}
+
+	types = nil
+	msg.SetSignature(&stubSigner{sig: &Signature{Protocol: pgpSignature, Micalg: "pgp-sha256"}})
+	sendToString(t, msg)
+	want = "multipart/mixed,multipart/alternative,text/plain,text/html,application/pdf,multipart/signed,application/pgp-signature"
+	if got := strings.Join(types, ","); got != want {
+		t.Errorf("Invalid order of the parts of the signed message, got %q, want %q", got, want)
+	}
+
+	types = nil
+	msg.SetPartHook(nil)
+	sendToString(t, msg)
+	if types != nil {
+		t.Errorf("The hook should be removed, got %q", types)
+	}
+}
+
+func TestMultipartTruthTable(t *testing.T) {
+	tests := []struct {
+		parts, attachments, embedded int
+		want                         string
+		// disposition is the disposition type of the top-level header,
+		// only set when the message is a single file.
+		disposition string
+	}{
+		{0, 0, 1, "image/jpeg", "inline"},
+		{0, 0, 2, "multipart/related(image/jpeg,image/jpeg)", ""},
+		{0, 1, 0, "application/pdf", "attachment"},
+		{0, 1, 1, "multipart/mixed(image/jpeg,application/pdf)", ""},
+		{0, 1, 2, "multipart/mixed(multipart/related(image/jpeg,image/jpeg),application/pdf)", ""},
+		{0, 2, 0, "multipart/mixed(application/pdf,application/pdf)", ""},
+		{0, 2, 1, "multipart/mixed(image/jpeg,application/pdf,application/pdf)", ""},
+		{0, 2, 2, "multipart/mixed(multipart/related(image/jpeg,image/jpeg),application/pdf,application/pdf)", ""},
+		{1, 0, 0, "text/plain", ""},
+		{1, 0, 1, "multipart/related(text/plain,image/jpeg)", ""},
+		{1, 0, 2, "multipart/related(text/plain,image/jpeg,image/jpeg)", ""},
+		{1, 1, 0, "multipart/mixed(text/plain,application/pdf)", ""},
+		{1, 1, 1, "multipart/mixed(multipart/related(text/plain,image/jpeg),application/pdf)", ""},
+		{1, 1, 2, "multipart/mixed(multipart/related(text/plain,image/jpeg,image/jpeg),application/pdf)", ""},
+		{1, 2, 0, "multipart/mixed(text/plain,application/pdf,application/pdf)", ""},
+		{1, 2, 1, "multipart/mixed(multipart/related(text/plain,image/jpeg),application/pdf,application/pdf)", ""},
+		{1, 2, 2, "multipart/mixed(multipart/related(text/plain,image/jpeg,image/jpeg),application/pdf,application/pdf)", ""},
+		{2, 0, 0, "multipart/alternative(text/plain,text/html)", ""},
+		{2, 0, 1, "multipart/related(multipart/alternative(text/plain,text/html),image/jpeg)", ""},
+		{2, 0, 2, "multipart/related(multipart/alternative(text/plain,text/html),image/jpeg,image/jpeg)", ""},
+		{2, 1, 0, "multipart/mixed(multipart/alternative(text/plain,text/html),application/pdf)", ""},
+		{2, 1, 1, "multipart/mixed(multipart/related(multipart/alternative(text/plain,text/html),image/jpeg),application/pdf)", ""},
+		{2, 1, 2, "multipart/mixed(multipart/related(multipart/alternative(text/plain,text/html),image/jpeg,image/jpeg),application/pdf)", ""},
+		{2, 2, 0, "multipart/mixed(multipart/alternative(text/plain,text/html),application/pdf,application/pdf)", ""},
+		{2, 2, 1, "multipart/mixed(multipart/related(multipart/alternative(text/plain,text/html),image/jpeg),application/pdf,application/pdf)", ""},
+		{2, 2, 2, "multipart/mixed(multipart/related(multipart/alternative(text/plain,text/html),image/jpeg,image/jpeg),application/pdf,application/pdf)", ""},
+	}
+
+	for _, test := range tests {
+		msg := NewMessage()
+		msg.SetHeader("From", "from@example.com")
+		addContent(msg, test.parts, test.embedded, test.attachments)
+
+		if got := structure(t, msg); got != test.want {
+			t.Errorf("Invalid structure for %d parts, %d attachments and %d embedded,\ngot  %s\nwant %s",
+				test.parts, test.attachments, test.embedded, got, test.want)
+		}
+		h := msg.Export().Header
+		disposition, _, _ := mime.ParseMediaType(h.Get("Content-Disposition"))
+		if disposition != test.disposition {
+			t.Errorf("Invalid top-level disposition for %d parts, %d attachments and %d embedded, got %q, want %q",
+				test.parts, test.attachments, test.embedded, disposition, test.disposition)
+		}
+		if cid := h["Content-ID"]; (len(cid) > 0) != (test.disposition == "inline") {
+			t.Errorf("Invalid top-level Content-ID for %d parts, %d attachments and %d embedded: %q",
+				test.parts, test.attachments, test.embedded, cid)
+		}
+	}
+
+	if h := NewMessage().Export().Header; h.Get("Content-Type") != "text/plain; charset=UTF-8" || h.Get("Content-Disposition") != "" {
+		t.Errorf("An empty message should have an empty text/plain body, got %q", h)
+	}
+}
+
+func addContent(msg *Message, parts, embedded, attachments int) {
+	contentTypes := []string{"text/plain", "text/html"}
+	for i := 0; i < parts; i++ {
+		msg.AddAlternative(contentTypes[i], "Test")
+	}
+	for i := 0; i < embedded; i++ {
+		msg.Embed(CreateFile("image"+strconv.Itoa(i)+".jpg", []byte("Content")))
+	}
+	for i := 0; i < attachments; i++ {
+		msg.Attach(CreateFile("test"+strconv.Itoa(i)+".pdf", []byte("Content")))
+	}
+}
+
+// structure returns the MIME tree of the exported message.
+func structure(t *testing.T, msg *Message) string {
+	m := msg.Export()
+	body, err := ioutil.ReadAll(m.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return partStructure(t, m.Header.Get("Content-Type"), body)
+}
+
+func partStructure(t *testing.T, contentType string, body []byte) string {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		t.Fatalf("Invalid Content-Type %q: %v", contentType, err)
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		return mediaType
+	}
+
+	var children []string
+	r := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		p, err := r.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		children = append(children, partStructure(t, p.Header.Get("Content-Type"), b))
+	}
+
+	return mediaType + "(" + strings.Join(children, ",") + ")"
+}
+
+func TestQpLineLength(t *testing.T) {
+	msg := NewMessage()
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.SetBody("text/plain",
+		strings.Repeat("0", 77)+"\r\n"+
+			strings.Repeat("0", 76)+"à\r\n"+
+			strings.Repeat("0", 75)+"à\r\n"+
+			strings.Repeat("0", 74)+"à\r\n"+
+			strings.Repeat("0", 73)+"à\r\n"+
+			strings.Repeat("0", 76)+"\r\n"+
+			strings.Repeat("0", 77)+"\n")
+
+	want := message{
+		from: "from@example.com",
+		to:   []string{"to@example.com"},
+		content: "From: from@example.com\r\n" +
+			"To: to@example.com\r\n" +
+			"Content-Type: text/plain; charset=UTF-8\r\n" +
+			"Content-Transfer-Encoding: quoted-printable\r\n" +
+			"\r\n" +
+			strings.Repeat("0", 76) + "=\r\n0\r\n" +
+			strings.Repeat("0", 76) + "=\r\n=C3=A0\r\n" +
+			strings.Repeat("0", 75) + "=\r\n=C3=A0\r\n" +
+			strings.Repeat("0", 74) + "=\r\n=C3=A0\r\n" +
+			strings.Repeat("0", 73) + "=C3=\r\n=A0\r\n" +
+			strings.Repeat("0", 76) + "\r\n" +
+			strings.Repeat("0", 76) + "=\r\n0\n",
+	}
+
+	testMessage(t, msg, 0, want)
+}
+
+func TestQpTrailingSpaces(t *testing.T) {
+	msg := NewMessage()
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.SetBodyWriter("text/plain", func(w io.Writer) error {
+		// The spaces are split between several writes.
+		io.WriteString(w, strings.Repeat("0", 73)+"  ")
+		io.WriteString(w, " \r\n"+strings.Repeat("0", 74)+" \t\r\n")
+		_, err := io.WriteString(w, "a b\tc \t")
+		return err
+	})
+
+	want := message{
+		from: "from@example.com",
+		to:   []string{"to@example.com"},
+		content: "From: from@example.com\r\n" +
+			"To: to@example.com\r\n" +
+			"Content-Type: text/plain; charset=UTF-8\r\n" +
+			"Content-Transfer-Encoding: quoted-printable\r\n" +
+			"\r\n" +
+			strings.Repeat("0", 73) + "=20=\r\n=20=20\r\n" +
+			strings.Repeat("0", 74) + "=\r\n=20=09\r\n" +
+			"a b\tc=20=09",
+	}
+
+	testMessage(t, msg, 0, want)
+}
+
+func TestBase64LineLength(t *testing.T) {
 	msg := NewMessage(SetCharset("UTF-8"), SetEncoding(Base64))
 	msg.SetHeader("From", "from@example.com")
 	msg.SetHeader("To", "to@example.com")
-	msg.SetBody("text/plain", strings.Repeat("0", 58))
+	msg.SetBody("text/plain", strings.Repeat("0", 58))
+
+	want := message{
+		from: "from@example.com",
+		to:   []string{"to@example.com"},
+		content: "From: from@example.com\r\n" +
+			"To: to@example.com\r\n" +
+			"Content-Type: text/plain; charset=UTF-8\r\n" +
+			"Content-Transfer-Encoding: base64\r\n" +
+			"\r\n" +
+			strings.Repeat("MDAw", 19) + "\r\nMA==",
+	}
+
+	testMessage(t, msg, 0, want)
+}
+
+func TestUnwrappedBase64(t *testing.T) {
+	msg := NewMessage(SetEncoding(Base64), SetUnwrappedBase64(true))
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.SetBody("text/plain", strings.Repeat("0", 300))
+	pre := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("1"), 300))
+	f := CreateFile("test.bin", []byte(pre[:100]+"\r\n"+pre[100:]))
+	f.SetEncoding(Base64PreEncoded)
+	msg.Attach(f)
+	msg.Attach(CreateFile("test.pdf", bytes.Repeat([]byte("2"), 300)))
+
+	want := message{
+		from: "from@example.com",
+		to:   []string{"to@example.com"},
+		content: "From: from@example.com\r\n" +
+			"To: to@example.com\r\n" +
+			"Content-Type: multipart/mixed; boundary=_BOUNDARY_1_\r\n" +
+			"\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			"Content-Type: text/plain; charset=UTF-8\r\n" +
+			"Content-Transfer-Encoding: base64\r\n" +
+			"Mime-Version: 1.0\r\n" +
+			"\r\n" +
+			strings.Repeat("MDAw", 100) + "\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			"Content-Type: application/octet-stream; name=\"test.bin\"\r\n" +
+			"Content-Disposition: attachment; filename=\"test.bin\"\r\n" +
+			"Content-Transfer-Encoding: base64\r\n" +
+			"\r\n" +
+			pre + "\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			"Content-Type: application/pdf; name=\"test.pdf\"\r\n" +
+			"Content-Disposition: attachment; filename=\"test.pdf\"\r\n" +
+			"Content-Transfer-Encoding: base64\r\n" +
+			"\r\n" +
+			strings.Repeat("MjIy", 100) + "\r\n" +
+			"--_BOUNDARY_1_--\r\n",
+	}
+
+	testMessage(t, msg, 1, want)
+}
+
+func TestEightBitLineLength(t *testing.T) {
+	line := strings.Repeat("a", 998)
+	tests := []struct {
+		enc        Encoding
+		body, want string
+	}{
+		{Unencoded, line + "\r\n" + line, line + "\r\n" + line},
+		{Unencoded, line + "b\r\nc", line + "\r\nb\r\nc"},
+		{EightBit, line + line + "ü", line + "\r\n" + line + "\r\nü"},
+		{Binary, line + "b\r\nc", line + "b\r\nc"},
+	}
+
+	for _, test := range tests {
+		msg := NewMessage(SetEncoding(test.enc))
+		msg.SetHeader("From", "from@example.com")
+		msg.SetHeader("To", "to@example.com")
+		msg.SetBody("text/plain", test.body)
+
+		want := message{
+			from: "from@example.com",
+			to:   []string{"to@example.com"},
+			content: "From: from@example.com\r\n" +
+				"To: to@example.com\r\n" +
+				"Content-Type: text/plain; charset=UTF-8\r\n" +
+				"Content-Transfer-Encoding: " + string(test.enc) + "\r\n" +
+				"\r\n" +
+				test.want,
+		}
+
+		testMessage(t, msg, 0, want)
+	}
+}
+
+func TestAutoQuotedPrintable(t *testing.T) {
+	tests := []struct {
+		body string
+		want Encoding
+	}{
+		{"<p>Hello, world!</p>\r\n", QuotedPrintable},
+		{"<p>Bonjour, ça va très bien !</p>", QuotedPrintable},
+		{"日本語の本文です。", Base64},
+		{"a\x00b\x01c\x02d\x1be\x7f", Base64},
+		{"", QuotedPrintable},
+	}
+
+	for _, test := range tests {
+		msg := NewMessage(SetEncoding(AutoQuotedPrintable))
+		msg.SetHeader("From", "from@example.com")
+		msg.SetHeader("To", "to@example.com")
+		msg.SetBody("text/html", test.body)
+		msg.Attach(CreateFile("test.txt", []byte("Hello")))
+
+		got := sendToString(t, msg)
+		want := "Content-Transfer-Encoding: " + string(test.want) + "\r\n" +
+			"Content-Type: text/html; charset=UTF-8\r\n"
+		if !strings.Contains(got, want) {
+			t.Errorf("Invalid encoding of %q, want %s:\n%s", test.body, test.want, got)
+		}
+		// Attachments still use base64.
+		if !strings.Contains(got, "Content-Transfer-Encoding: base64\r\n"+
+			"Content-Type: text/plain; charset=utf-8; name=\"test.txt\"\r\n") {
+			t.Errorf("The attachment should be encoded in base64:\n%s", got)
+		}
+	}
+
+	// A third of the bytes are escaped.
+	body := []byte("ça va")
+	if got := NewMessage().resolveEncoding(AutoQuotedPrintable, body, false); got != Base64 {
+		t.Errorf("Invalid encoding with the default threshold, got %s, want %s", got, Base64)
+	}
+	msg := NewMessage(SetQuotedPrintableThreshold(0.5))
+	if got := msg.resolveEncoding(AutoQuotedPrintable, body, false); got != QuotedPrintable {
+		t.Errorf("Invalid encoding with a threshold of 0.5, got %s, want %s", got, QuotedPrintable)
+	}
+
+	// Files use the threshold of the message too.
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.SetBody("text/plain", "Hello")
+	msg.Attach(CreateFile("test.txt", body, SetFileEncoding(AutoQuotedPrintable)))
+	if got := sendToString(t, msg); !strings.Contains(got, "\r\n\r\n=C3=A7a va\r\n") {
+		t.Errorf("The file should be encoded in quoted-printable with a threshold of 0.5:\n%s", got)
+	}
+}
 
-	want := message{
-		from: "from@example.com",
-		to:   []string{"to@example.com"},
-		content: "From: from@example.com\r\n" +
-			"To: to@example.com\r\n" +
-			"Content-Type: text/plain; charset=UTF-8\r\n" +
-			"Content-Transfer-Encoding: base64\r\n" +
-			"\r\n" +
-			strings.Repeat("MDAw", 19) + "\r\nMA==",
+func TestSevenBit(t *testing.T) {
+	tests := []struct {
+		enc     Encoding
+		body    string
+		wantEnc Encoding
+		wantErr error
+	}{
+		{SevenBit, "Hello\r\n" + strings.Repeat("a", 998), SevenBit, nil},
+		{SevenBit, "¡Hola!", "", errNot7bit},
+		{SevenBit, "Hello\x00", "", errNot7bit},
+		{SevenBit, strings.Repeat("a", 999), "", errLongLine7bit},
+		{AutoEncoding, "Hello", SevenBit, nil},
+		{AutoEncoding, "¡Hola!", EightBit, nil},
+		{AutoEncoding, strings.Repeat("a", 999), EightBit, nil},
 	}
 
-	testMessage(t, msg, 0, want)
+	for _, test := range tests {
+		msg := NewMessage(SetEncoding(test.enc))
+		msg.SetHeader("From", "from@example.com")
+		msg.SetBody("text/plain", test.body)
+
+		m, err := msg.export()
+		if err != test.wantErr {
+			t.Errorf("Invalid error for %q, got %v, want %v", test.body, err, test.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if got := m.Header.Get("Content-Transfer-Encoding"); got != string(test.wantEnc) {
+			t.Errorf("Invalid Content-Transfer-Encoding for %q, got %q, want %q", test.body, got, test.wantEnc)
+		}
+	}
+}
+
+func TestBase64LineWriterChunks(t *testing.T) {
+	for _, size := range []int{0, 1, 56, 57, 58, 113, 114, 115, 170, 171, 172} {
+		payload := bytes.Repeat([]byte{0xFB}, size)
+
+		want := new(bytes.Buffer)
+		enc := base64.NewEncoder(base64.StdEncoding, newBase64LineWriter(want))
+		enc.Write(payload)
+		enc.Close()
+
+		if bytes.HasSuffix(want.Bytes(), crlf) {
+			t.Errorf("Output of %d bytes ends with a line break", size)
+		}
+		for _, line := range bytes.Split(want.Bytes(), crlf) {
+			if len(line) > maxLineLen || (len(line) == 0 && size > 0) {
+				t.Errorf("Invalid line of %d characters with %d bytes", len(line), size)
+			}
+		}
+
+		for _, chunk := range []int{1, 3, 57} {
+			got := new(bytes.Buffer)
+			enc := base64.NewEncoder(base64.StdEncoding, newBase64LineWriter(got))
+			for p := payload; len(p) > 0; {
+				n := chunk
+				if n > len(p) {
+					n = len(p)
+				}
+				enc.Write(p[:n])
+				p = p[n:]
+			}
+			enc.Close()
+
+			if !bytes.Equal(got.Bytes(), want.Bytes()) {
+				t.Errorf("Invalid output with %d bytes in chunks of %d, got:\n%s\nwant:\n%s", size, chunk, got, want)
+			}
+		}
+	}
+}
+
+func TestBase64LineWriterErrors(t *testing.T) {
+	tests := []struct {
+		limit, want int
+	}{
+		{limit: 10, want: 10},
+		{limit: 76, want: 76},
+		{limit: 77, want: 76},
+		{limit: 78, want: 76},
+		{limit: 100, want: 98},
+		{limit: 200, want: 100},
+	}
+
+	p := bytes.Repeat([]byte("A"), 100)
+	for _, test := range tests {
+		w := newBase64LineWriter(&limitedWriter{n: test.limit})
+		n, err := w.Write(p)
+		if n != test.want {
+			t.Errorf("Invalid count with a limit of %d, got %d, want %d", test.limit, n, test.want)
+		}
+		if n < len(p) && err != errLimitReached {
+			t.Errorf("Invalid error with a limit of %d, got %v, want %v", test.limit, err, errLimitReached)
+		}
+		if n == len(p) && err != nil {
+			t.Errorf("Write returned an error with a limit of %d: %v", test.limit, err)
+		}
+	}
+}
+
+func TestBytes(t *testing.T) {
+	now = stubNow
+	msg := NewMessage()
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.SetHeader("Subject", "¡Hola, señor!")
+	msg.SetBody("text/plain", "Test")
+
+	want := "Content-Transfer-Encoding: quoted-printable\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"Date: Wed, 25 Jun 2014 17:46:00 +0000\r\n" +
+		"From: from@example.com\r\n" +
+		"Mime-Version: 1.0\r\n" +
+		"Subject: =?UTF-8?Q?=C2=A1Hola,_se=C3=B1or!?=\r\n" +
+		"To: to@example.com\r\n" +
+		"\r\n" +
+		"Test"
+
+	got, err := msg.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("Invalid message, got:\n%s\nwant:\n%s", got, want)
+	}
+	if sent := sendToString(t, msg); sent != want {
+		t.Errorf("Bytes does not match the sent message, got:\n%s\nwant:\n%s", sent, want)
+	}
+
+	msg.SetHeader("Bcc", "bcc@example.com")
+	got, err = msg.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("Bcc should not be written, got:\n%s", got)
+	}
+}
+
+func TestSize(t *testing.T) {
+	now = stubNow
+	msg := NewMessage()
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.SetBody("text/plain", strings.Repeat("¡Hola, señor! ", 20))
+	msg.AddAlternative("text/html", "<p>¡Hola, señor!</p>")
+	msg.Attach(CreateFile("test.bin", bytes.Repeat([]byte{0xFF}, 1000)))
+
+	size, err := msg.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(len(sendToString(t, msg))); size != want {
+		t.Errorf("Invalid size, got %d, want %d", size, want)
+	}
+}
+
+func TestWriteToFile(t *testing.T) {
+	now = stubNow
+	msg := NewMessage()
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.SetBody("text/plain", "Test")
+
+	name := filepath.Join(t.TempDir(), "message.eml")
+	if err := msg.WriteToFile(name); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := msg.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = append(want, "\r\n"...)
+	if !bytes.Equal(got, want) {
+		t.Errorf("Invalid file content, got:\n%s\nwant:\n%s", got, want)
+	}
+
+	msg.SetSignature(&stubSigner{err: errors.New("gomail: test error")})
+	if err := msg.WriteToFile(name); err == nil {
+		t.Error("WriteToFile should fail when the message cannot be signed")
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("The partial file should be removed, got %v", err)
+	}
+}
+
+func TestPartContentLength(t *testing.T) {
+	msg := NewMessage(SetPartContentLength(true))
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.SetBody("text/plain", "Café")
+	msg.AddAlternativeWriter("text/html", func(w io.Writer) error {
+		_, err := io.WriteString(w, "<p>"+strings.Repeat("Café ", 40)+"</p>")
+		return err
+	})
+	msg.Attach(CreateFile("test.pdf", bytes.Repeat([]byte("Content"), 100)))
+
+	buf := new(bytes.Buffer)
+	if _, err := msg.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	m, err := mail.ReadMessage(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.Header.Get("Content-Length"); got != "" {
+		t.Errorf("The message should not have a Content-Length, got %q", got)
+	}
+
+	var count int
+	var checkParts func(contentType string, body io.Reader)
+	checkParts = func(contentType string, body io.Reader) {
+		_, params, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := multipart.NewReader(body, params["boundary"])
+		for {
+			p, err := r.NextRawPart()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			contentType := p.Header.Get("Content-Type")
+			if strings.HasPrefix(contentType, "multipart/") {
+				if got := p.Header.Get("Content-Length"); got != "" {
+					t.Errorf("A multipart part should not have a Content-Length, got %q", got)
+				}
+				checkParts(contentType, p)
+				continue
+			}
+			b, err := ioutil.ReadAll(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := p.Header.Get("Content-Length"), strconv.Itoa(len(b)); got != want {
+				t.Errorf("Invalid Content-Length of %s, got %q, want %q", contentType, got, want)
+			}
+			count++
+		}
+	}
+	checkParts(m.Header.Get("Content-Type"), m.Body)
+	if count != 3 {
+		t.Errorf("Invalid number of parts, got %d, want 3", count)
+	}
+}
+
+func TestExportWithError(t *testing.T) {
+	msg := NewMessage(SetCharset("ISO-8859-1"), SetTranscoding(true))
+	msg.SetHeader("From", "from@example.com")
+	msg.SetBody("text/plain", "Café")
+	m, err := msg.ExportWithError()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := ioutil.ReadAll(m.Body); string(body) != "Caf=E9" {
+		t.Errorf("Invalid body, got %q", body)
+	}
+
+	msg.SetBody("text/plain", "10 €")
+	if m, err := msg.ExportWithError(); m != nil || err == nil {
+		t.Errorf("ExportWithError should fail, got %v, %v", m, err)
+	}
+	if m := msg.Export(); m != nil {
+		t.Error("Export should return nil")
+	}
+
+	msg = NewMessage(SetMaxSize(10))
+	msg.SetHeader("From", "from@example.com")
+	msg.SetBody("text/plain", strings.Repeat("a", 20))
+	if _, err := msg.ExportWithError(); err != ErrMessageTooLarge {
+		t.Errorf("Invalid error, got %v, want %v", err, ErrMessageTooLarge)
+	}
+}
+
+func TestMaxSize(t *testing.T) {
+	newMessage := func(size int64) *Message {
+		msg := NewMessage(SetMaxSize(size))
+		msg.SetHeader("From", "from@example.com")
+		msg.SetHeader("To", "to@example.com")
+		msg.SetBody("text/plain", "Test")
+		msg.Attach(CreateFile("test.bin", bytes.Repeat([]byte{0xFF}, 10000)))
+		return msg
+	}
+
+	size, err := newMessage(0).Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, limit := range []int64{10, 500, size - 1} {
+		n, err := newMessage(limit).WriteTo(ioutil.Discard)
+		if err != ErrMessageTooLarge {
+			t.Errorf("Invalid error with a limit of %d, got %v, want %v", limit, err, ErrMessageTooLarge)
+		}
+		if n != limit {
+			t.Errorf("Invalid count with a limit of %d, got %d", limit, n)
+		}
+
+		mailer := NewMailer("host", "username", "password", 587, SetSendMail(stubSendMail(t, 0)))
+		if err := mailer.Send(newMessage(limit)); err != ErrMessageTooLarge {
+			t.Errorf("Invalid Send error with a limit of %d, got %v, want %v", limit, err, ErrMessageTooLarge)
+		}
+	}
+
+	if n, err := newMessage(size).WriteTo(ioutil.Discard); err != nil || n != size {
+		t.Errorf("WriteTo(%d) = %d, %v, want %d, nil", size, n, err, size)
+	}
+}
+
+func TestMaxSizeWhileBuilding(t *testing.T) {
+	msg := NewMessage(SetMaxSize(1000))
+	msg.SetHeader("From", "from@example.com")
+	for i := 0; i < 2; i++ {
+		// 300 bytes are 412 bytes once encoded in base64.
+		if err := msg.AttachReader("test.bin", bytes.NewReader(make([]byte, 300))); err != nil {
+			t.Fatalf("AttachReader error: %v", err)
+		}
+	}
+	if err := msg.AttachReader("test.bin", bytes.NewReader(make([]byte, 300))); err != ErrMessageTooLarge {
+		t.Errorf("Invalid AttachReader error, got %v, want %v", err, ErrMessageTooLarge)
+	}
+	if err := msg.EmbedPreEncoded("image.png", "image/png", []byte(base64.StdEncoding.EncodeToString(make([]byte, 300)))); err != ErrMessageTooLarge {
+		t.Errorf("Invalid EmbedPreEncoded error, got %v, want %v", err, ErrMessageTooLarge)
+	}
+	if n := len(msg.Attachments()) + len(msg.Embedded()); n != 2 {
+		t.Errorf("The files exceeding the limit should not be added, got %d files", n)
+	}
+
+	path := filepath.Join(t.TempDir(), "large.bin")
+	if err := ioutil.WriteFile(path, make([]byte, 1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewMessage(SetMaxSize(1000)).AttachFile(path); err != ErrMessageTooLarge {
+		t.Errorf("Invalid AttachFile error, got %v, want %v", err, ErrMessageTooLarge)
+	}
+}
+
+// zeroReader returns n zero bytes and counts the bytes read.
+type zeroReader struct {
+	n, read int
+}
+
+func (r *zeroReader) Read(p []byte) (int, error) {
+	if r.n == 0 {
+		return 0, io.EOF
+	}
+	if len(p) > r.n {
+		p = p[:r.n]
+	}
+	for i := range p {
+		p[i] = 0
+	}
+	r.n -= len(p)
+	r.read += len(p)
+	return len(p), nil
+}
+
+func TestMaxSizeStream(t *testing.T) {
+	msg := NewMessage(SetMaxSize(1 << 20))
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	r := &zeroReader{n: 100 << 20}
+	if err := msg.AttachReader("test.bin", r, SetMimeType("application/octet-stream")); err != nil {
+		t.Fatalf("The size of a reader is unknown, AttachReader should succeed, got %v", err)
+	}
+
+	if _, err := msg.WriteTo(ioutil.Discard); err != ErrMessageTooLarge {
+		t.Errorf("Invalid error, got %v, want %v", err, ErrMessageTooLarge)
+	}
+	if r.read >= 2<<20 {
+		t.Errorf("Writing should stop at the limit, %d bytes were read", r.read)
+	}
+}
+
+func TestAttachSized(t *testing.T) {
+	tests := []struct {
+		content string
+		size    int64
+		err     string
+	}{
+		{"Content", 7, ""},
+		{"Content", 10, `gomail: "test.txt" is 7 bytes long, shorter than its declared size of 10 bytes`},
+		{"Content", 4, `gomail: "test.txt" is longer than its declared size of 4 bytes`},
+		{"", 0, ""},
+	}
+
+	for _, test := range tests {
+		msg := NewMessage()
+		msg.SetHeader("From", "from@example.com")
+		msg.SetBody("text/plain", "Test")
+		if err := msg.AttachSized("test.txt", strings.NewReader(test.content), test.size); err != nil {
+			t.Fatal(err)
+		}
+		if files := msg.Attachments(); len(files) != 1 || files[0].Size != test.size {
+			t.Errorf("Invalid attachments, got %+v", files)
+		}
+
+		buf := new(bytes.Buffer)
+		_, err := msg.WriteTo(buf)
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("WriteTo error with %q of %d bytes: %v", test.content, test.size, err)
+			} else if !strings.Contains(buf.String(), base64.StdEncoding.EncodeToString([]byte(test.content))+"\r\n--") {
+				t.Errorf("The content is not in the message:\n%s", buf.String())
+			}
+		} else if err == nil || err.Error() != test.err {
+			t.Errorf("Invalid error with %q of %d bytes, got %v, want %s", test.content, test.size, err, test.err)
+		}
+	}
+
+	msg := NewMessage(SetMaxSize(1000))
+	if err := msg.AttachSized("test.bin", &zeroReader{n: 1 << 20}, 1<<20); err != ErrMessageTooLarge {
+		t.Errorf("Invalid error, got %v, want %v", err, ErrMessageTooLarge)
+	}
+	if err := msg.EmbedSized("image.png", strings.NewReader(""), -1); err == nil {
+		t.Error("A negative size should be rejected")
+	}
+}
+
+func TestWriteToError(t *testing.T) {
+	msg := NewMessage()
+	msg.SetHeader("From", "from@example.com")
+	msg.SetBody("text/plain", "Test")
+
+	n, err := msg.WriteTo(&limitedWriter{n: 10})
+	if err != errLimitReached {
+		t.Errorf("Invalid error, got %v, want %v", err, errLimitReached)
+	}
+	if n != 10 {
+		t.Errorf("Invalid count, got %d, want 10", n)
+	}
+}
+
+var errLimitReached = errors.New("gomail: test limit reached")
+
+// limitedWriter fails once n bytes were written.
+type limitedWriter struct {
+	n int
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		n := w.n
+		w.n = 0
+		return n, errLimitReached
+	}
+	w.n -= len(p)
+	return len(p), nil
 }
 
 func testMessage(t *testing.T, msg *Message, bCount int, emails ...message) {
@@ -689,6 +3106,16 @@ func getBoundaries(t *testing.T, count int, msg string) []string {
 
 var boundaryRegExp = regexp.MustCompile("boundary=(\\w+)")
 
+// withoutBoundaries replaces the random boundaries of msg so that two exports of
+// the same message can be compared.
+func withoutBoundaries(msg string) string {
+	for i, match := range boundaryRegExp.FindAllStringSubmatch(msg, -1) {
+		msg = strings.Replace(msg, match[1], "_BOUNDARY_"+strconv.Itoa(i+1)+"_", -1)
+	}
+
+	return msg
+}
+
 func BenchmarkFull(b *testing.B) {
 	emptyFunc := func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
 		return nil
@@ -715,3 +3142,111 @@ func BenchmarkFull(b *testing.B) {
 		msg.Reset()
 	}
 }
+
+func BenchmarkBase64Attachment(b *testing.B) {
+	content := bytes.Repeat([]byte{0xFF}, 1<<20)
+	emptyFunc := func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		return nil
+	}
+	mailer := NewMailer("host", "username", "password", 587, SetSendMail(emptyFunc))
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(content)))
+	for n := 0; n < b.N; n++ {
+		msg := NewMessage()
+		msg.SetHeader("From", "from@example.com")
+		msg.SetHeader("To", "to@example.com")
+		msg.Attach(CreateFile("benchmark.bin", content))
+		if err := mailer.Send(msg); err != nil {
+			panic(err)
+		}
+		msg.Reset()
+	}
+}
+
+func TestBufferPoolSize(t *testing.T) {
+	emptyFunc := func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		return nil
+	}
+	mailer := NewMailer("host", "username", "password", 587, SetSendMail(emptyFunc))
+
+	send := func(size int) {
+		msg := NewMessage()
+		msg.SetHeader("From", "from@example.com")
+		msg.SetHeader("To", "to@example.com")
+		msg.SetBody("text/plain", strings.Repeat("a", size))
+		msg.Attach(CreateFile("test.bin", bytes.Repeat([]byte{0xFF}, size)))
+		if err := mailer.Send(msg); err != nil {
+			t.Fatal(err)
+		}
+		msg.Reset()
+	}
+	send(8 << 20)
+	for i := 0; i < 10; i++ {
+		send(100)
+	}
+
+	var bufs []*bytes.Buffer
+	for i := 0; i < 100; i++ {
+		buf := getBuffer()
+		if buf.Cap() > MaxPooledBufferSize {
+			t.Errorf("A buffer of %d bytes was kept in the pool", buf.Cap())
+		}
+		bufs = append(bufs, buf)
+	}
+	for _, buf := range bufs {
+		putBuffer(buf)
+	}
+}
+
+// BenchmarkAfterLargeMessage sends small messages after a large one and
+// reports the heap in use, which should not include the buffers of the large
+// message.
+func BenchmarkAfterLargeMessage(b *testing.B) {
+	emptyFunc := func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		return nil
+	}
+	mailer := NewMailer("host", "username", "password", 587, SetSendMail(emptyFunc))
+	send := func(content []byte) {
+		msg := NewMessage()
+		msg.SetHeader("From", "from@example.com")
+		msg.SetHeader("To", "to@example.com")
+		msg.SetBody("text/plain", "Test")
+		msg.Attach(CreateFile("benchmark.bin", content))
+		if err := mailer.Send(msg); err != nil {
+			panic(err)
+		}
+		msg.Reset()
+	}
+
+	send(bytes.Repeat([]byte{0xFF}, 50<<20))
+	content := []byte("Benchmark")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		send(content)
+	}
+	b.StopTimer()
+
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	b.ReportMetric(float64(stats.HeapInuse)/(1<<20), "heap-MB")
+}
+
+func BenchmarkExport(b *testing.B) {
+	msg := NewMessage()
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		msg.SetHeaders(map[string][]string{
+			"From":    {"from@example.com"},
+			"To":      {"to@example.com"},
+			"Subject": {"Test"},
+		})
+		msg.SetBody("text/plain", "Test")
+		if m := msg.Export(); m == nil {
+			b.Fatal("Export failed")
+		}
+		msg.Reset()
+	}
+}
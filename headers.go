@@ -0,0 +1,301 @@
+package gomail
+
+import (
+	"net/mail"
+	"net/textproto"
+	"strings"
+)
+
+// A HeaderEncoding is the way the values of a header field containing non-ASCII
+// characters are encoded. In the address fields, only the names are encoded.
+type HeaderEncoding int
+
+const (
+	// AutoHeaderEncoding uses the Q encoding, or the B encoding if the message
+	// is encoded in base64, unless most characters are not ASCII in which case
+	// the shorter B encoding is used. It is the default.
+	AutoHeaderEncoding HeaderEncoding = iota
+	// QEncoding always uses the Q encoding, except for the names of addresses
+	// containing special characters that can only be B-encoded.
+	QEncoding
+	// BEncoding always uses the B encoding. Unlike the Q encoding, it leaves
+	// no room for the spaces of the value to be mangled.
+	BEncoding
+	// NoHeaderEncoding never encodes the values, for example when the message
+	// is sent with the SMTPUTF8 extension. The names of addresses are still
+	// quoted if needed.
+	NoHeaderEncoding
+)
+
+// SetHeaderEncoding sets how the values of the given header field are encoded.
+// It only applies to the values set after the call. The values that are pure
+// ASCII, such as addresses without a name, are never encoded.
+//
+// Example:
+//
+//	msg.SetHeaderEncoding("Subject", gomail.BEncoding)
+//	msg.SetHeader("Subject", "Rendez-vous à la gare")
+func (msg *Message) SetHeaderEncoding(field string, mode HeaderEncoding) {
+	if msg.headerEncodings == nil {
+		msg.headerEncodings = make(map[string]HeaderEncoding)
+	}
+	msg.headerEncodings[textproto.CanonicalMIMEHeaderKey(field)] = mode
+}
+
+func (msg *Message) headerEncoding(field string) HeaderEncoding {
+	return msg.headerEncodings[textproto.CanonicalMIMEHeaderKey(field)]
+}
+
+// CanonicalHeaderOrder is an order of the header fields putting the most
+// important ones first, as recommended by RFC 5322, 3.6.
+var CanonicalHeaderOrder = []string{"From", "To", "Cc", "Subject", "Date", "Message-ID"}
+
+// SetHeaderOrder sets the order in which the header fields are written: the
+// given fields come first, in that order, followed by the other fields sorted
+// by name. Field names are compared case-insensitively. By default, all the
+// fields are sorted by name so that the output is deterministic.
+//
+// The order only applies to the messages written by WriteTo or sent by a
+// Mailer. The header of the net/mail.Message returned by Export is a map.
+//
+// Example:
+//
+//	msg.SetHeaderOrder(gomail.CanonicalHeaderOrder)
+func (msg *Message) SetHeaderOrder(fields []string) {
+	msg.headerOrder = append([]string(nil), fields...)
+}
+
+// Priority represents the importance of an email.
+type Priority int
+
+const (
+	// PriorityNormal is the default priority of an email.
+	PriorityNormal Priority = iota
+	// PriorityHigh marks an email as important.
+	PriorityHigh
+	// PriorityLow marks an email as not important.
+	PriorityLow
+)
+
+// SetPriority sets the X-Priority, Importance and Priority header fields which
+// are read differently by email clients. PriorityNormal removes these fields
+// since clients treat their absence as a normal priority.
+//
+// Example:
+//
+//	msg.SetPriority(gomail.PriorityHigh)
+func (msg *Message) SetPriority(p Priority) {
+	var xPriority, importance, priority string
+	switch p {
+	case PriorityHigh:
+		xPriority, importance, priority = "1 (Highest)", "High", "urgent"
+	case PriorityLow:
+		xPriority, importance, priority = "5 (Lowest)", "Low", "non-urgent"
+	default:
+		delete(msg.header, "X-Priority")
+		delete(msg.header, "Importance")
+		delete(msg.header, "Priority")
+		return
+	}
+
+	msg.header["X-Priority"] = []string{xPriority}
+	msg.header["Importance"] = []string{importance}
+	msg.header["Priority"] = []string{priority}
+}
+
+// SetReplyTo sets the Reply-To header field to the given addresses. The names
+// are encoded like in SetAddressHeader. It returns a *ValidationError if an
+// address cannot be parsed, in which case the field is left unchanged.
+//
+// Example:
+//
+//	if err := msg.SetReplyTo("support@example.com", "Sales <sales@example.com>"); err != nil {
+//		panic(err)
+//	}
+func (msg *Message) SetReplyTo(addresses ...string) error {
+	list := make([]*mail.Address, len(addresses))
+	for i, addr := range addresses {
+		a, err := mail.ParseAddress(addr)
+		if err != nil {
+			return invalidAddress("Reply-To", addr, err)
+		}
+		list[i] = a
+	}
+	msg.SetReplyToAddresses(list...)
+
+	return nil
+}
+
+// SetReplyToAddresses sets the Reply-To header field to the given addresses.
+// The names are encoded like in SetAddressHeader.
+//
+// Example:
+//
+//	msg.SetReplyToAddresses(
+//		&mail.Address{Name: "Support", Address: "support@example.com"},
+//		&mail.Address{Name: "Sales", Address: "sales@example.com"},
+//	)
+func (msg *Message) SetReplyToAddresses(addresses ...*mail.Address) {
+	value := make([]string, len(addresses))
+	for i, a := range addresses {
+		value[i] = msg.formatAddress(a.Address, a.Name, msg.headerEncoding("Reply-To"))
+	}
+	msg.header["Reply-To"] = value
+}
+
+// SetListUnsubscribe sets the List-Unsubscribe header field, defined in
+// RFC 2369, to the given mailto: or https: URLs.
+//
+// Example:
+//
+//	msg.SetListUnsubscribe("mailto:unsubscribe@example.com", "https://example.com/unsubscribe?id=42")
+func (msg *Message) SetListUnsubscribe(urls ...string) {
+	value := make([]string, len(urls))
+	for i, url := range urls {
+		value[i] = "<" + stripNewlines(url) + ">"
+	}
+	msg.header["List-Unsubscribe"] = value
+}
+
+// SetOneClickUnsubscribe sets the List-Unsubscribe-Post header field defined in
+// RFC 8058 so that recipients can unsubscribe with a single click. It must be
+// called after SetListUnsubscribe and returns a *ValidationError if none of the
+// URLs is an https: URL since one-click unsubscription requires one.
+//
+// Example:
+//
+//	msg.SetListUnsubscribe("https://example.com/unsubscribe?id=42")
+//	if err := msg.SetOneClickUnsubscribe(); err != nil {
+//		panic(err)
+//	}
+func (msg *Message) SetOneClickUnsubscribe() error {
+	for _, url := range msg.header["List-Unsubscribe"] {
+		if strings.HasPrefix(strings.ToLower(url), "<https:") {
+			msg.header["List-Unsubscribe-Post"] = []string{"List-Unsubscribe=One-Click"}
+			return nil
+		}
+	}
+
+	return &ValidationError{Field: "List-Unsubscribe", Reason: "has no https: URL, it is required for one-click unsubscription"}
+}
+
+// Values of the Auto-Submitted header field defined in RFC 3834 and RFC 5436.
+const (
+	// AutoGenerated marks an email generated by an automatic process, such
+	// as a notification or a transactional email.
+	AutoGenerated = "auto-generated"
+	// AutoReplied marks an automatic response to another email.
+	AutoReplied = "auto-replied"
+	// AutoNotified marks a notification sent by a Sieve notify action.
+	AutoNotified = "auto-notified"
+	// NotAutoSubmitted marks an email sent by a person.
+	NotAutoSubmitted = "no"
+)
+
+// SetAutoSubmitted sets the Auto-Submitted header field so that automatic
+// responders do not reply to the email. It returns a *ValidationError if value
+// is not one of the values defined above.
+//
+// Example:
+//
+//	msg.SetAutoSubmitted(gomail.AutoGenerated)
+func (msg *Message) SetAutoSubmitted(value string) error {
+	switch value {
+	case AutoGenerated, AutoReplied, AutoNotified, NotAutoSubmitted:
+		if value == NotAutoSubmitted && msg.hasPrecedence() {
+			return &ValidationError{Field: "Auto-Submitted", Reason: "contradicts the Precedence field"}
+		}
+		msg.header["Auto-Submitted"] = []string{value}
+		return nil
+	}
+
+	return &ValidationError{Field: "Auto-Submitted", Reason: "has an unknown value " + value}
+}
+
+// Values of the Precedence header field. It is not standard but is honored
+// by most automatic responders.
+const (
+	// PrecedenceBulk marks an email sent in bulk, such as a newsletter.
+	PrecedenceBulk = "bulk"
+	// PrecedenceList marks an email sent to a mailing list.
+	PrecedenceList = "list"
+	// PrecedenceJunk marks an email that is not important.
+	PrecedenceJunk = "junk"
+)
+
+// SetPrecedence sets the Precedence header field so that automatic responders
+// do not reply to the email. It returns a *ValidationError if value is not one
+// of the values defined above or if the email is marked as sent by a person
+// with SetAutoSubmitted(NotAutoSubmitted).
+//
+// Example:
+//
+//	msg.SetPrecedence(gomail.PrecedenceBulk)
+func (msg *Message) SetPrecedence(value string) error {
+	switch value {
+	case PrecedenceBulk, PrecedenceList, PrecedenceJunk:
+		if msg.autoSubmitted() == NotAutoSubmitted {
+			return &ValidationError{Field: "Precedence", Reason: "contradicts Auto-Submitted: " + NotAutoSubmitted}
+		}
+		msg.header["Precedence"] = []string{value}
+		return nil
+	}
+
+	return &ValidationError{Field: "Precedence", Reason: "has an unknown value " + value}
+}
+
+// IsAutoSubmitted reports whether the email was sent by an automatic process
+// or in bulk, according to its Auto-Submitted and Precedence header fields.
+// Automatic responders must not reply to such emails to avoid mail loops, as
+// required by RFC 3834.
+//
+// Example:
+//
+//	msg, err := gomail.ReadMessage(r)
+//	if err == nil && !msg.IsAutoSubmitted() {
+//		// Send the automatic reply
+//	}
+func (msg *Message) IsAutoSubmitted() bool {
+	if value := msg.autoSubmitted(); value != "" && value != NotAutoSubmitted {
+		return true
+	}
+
+	return msg.hasPrecedence()
+}
+
+// autoSubmitted returns the lowercase value of the Auto-Submitted header
+// field without its parameters.
+func (msg *Message) autoSubmitted() string {
+	value := msg.getHeaderValue("Auto-Submitted")
+	if i := strings.IndexByte(value, ';'); i >= 0 {
+		value = value[:i]
+	}
+
+	return strings.ToLower(strings.TrimSpace(value))
+}
+
+// hasPrecedence reports whether the Precedence header field marks the email as
+// sent in bulk.
+func (msg *Message) hasPrecedence() bool {
+	switch strings.ToLower(strings.TrimSpace(msg.getHeaderValue("Precedence"))) {
+	case PrecedenceBulk, PrecedenceList, PrecedenceJunk:
+		return true
+	}
+
+	return false
+}
+
+// getHeaderValue returns the first value of field. The name is compared
+// case-insensitively since SetHeader keeps the names as given.
+func (msg *Message) getHeaderValue(field string) string {
+	if v, ok := msg.header[field]; ok && len(v) > 0 {
+		return v[0]
+	}
+	for k, v := range msg.header {
+		if strings.EqualFold(k, field) && len(v) > 0 {
+			return v[0]
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,278 @@
+package gomail
+
+import (
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestSetPriority(t *testing.T) {
+	tests := []struct {
+		level                           Priority
+		xPriority, importance, priority []string
+	}{
+		{PriorityHigh, []string{"1 (Highest)"}, []string{"High"}, []string{"urgent"}},
+		{PriorityNormal, nil, nil, nil},
+		{PriorityLow, []string{"5 (Lowest)"}, []string{"Low"}, []string{"non-urgent"}},
+	}
+
+	for _, test := range tests {
+		msg := NewMessage()
+		msg.SetPriority(test.level)
+		assertHeader(t, msg, "X-Priority", test.xPriority...)
+		assertHeader(t, msg, "Importance", test.importance...)
+		assertHeader(t, msg, "Priority", test.priority...)
+	}
+
+	msg := NewMessage()
+	msg.SetPriority(PriorityHigh)
+	msg.SetPriority(PriorityNormal)
+	assertHeader(t, msg, "X-Priority")
+	assertHeader(t, msg, "Importance")
+	assertHeader(t, msg, "Priority")
+}
+
+func assertHeader(t *testing.T, msg *Message, field string, want ...string) {
+	got := msg.GetHeader(field)
+	if len(got) != len(want) {
+		t.Errorf("Invalid %s header, got %q, want %q", field, got, want)
+		return
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Invalid %s header, got %q, want %q", field, got, want)
+			return
+		}
+	}
+}
+
+func TestSetReplyTo(t *testing.T) {
+	msg := NewMessage()
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	if err := msg.SetReplyTo("support@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	assertHeader(t, msg, "Reply-To", "support@example.com")
+	if err := msg.SetReplyTo("sales@example.com", "support"); err == nil {
+		t.Error("SetReplyTo should fail with an invalid address")
+	}
+	assertHeader(t, msg, "Reply-To", "support@example.com")
+	if err := msg.SetReplyTo("Jürgen Müller <j@example.com>", "sales@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	assertHeader(t, msg, "Reply-To", "=?UTF-8?Q?J=C3=BCrgen_M=C3=BCller?= <j@example.com>", "sales@example.com")
+
+	msg.SetReplyToAddresses(
+		&mail.Address{Name: "Señor Support", Address: "support@example.com"},
+		&mail.Address{Name: "Sales, Inc", Address: "sales@example.com"},
+		&mail.Address{Address: "a-very-long-address-for-the-billing-department@example.com"},
+	)
+	msg.SetBody("text/plain", "Test")
+
+	want := message{
+		from: "from@example.com",
+		to:   []string{"to@example.com"},
+		content: "From: from@example.com\r\n" +
+			"To: to@example.com\r\n" +
+			"Reply-To: =?UTF-8?Q?Se=C3=B1or_Support?= <support@example.com>,\r\n" +
+			" \"Sales, Inc\" <sales@example.com>,\r\n" +
+			" a-very-long-address-for-the-billing-department@example.com\r\n" +
+			"Content-Type: text/plain; charset=UTF-8\r\n" +
+			"Content-Transfer-Encoding: quoted-printable\r\n" +
+			"\r\n" +
+			"Test",
+	}
+
+	testMessage(t, msg, 0, want)
+}
+
+func TestListUnsubscribe(t *testing.T) {
+	msg := NewMessage()
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.SetListUnsubscribe(
+		"mailto:unsubscribe@example.com?subject=unsubscribe",
+		"https://example.com/unsubscribe?list=newsletter&id=42",
+	)
+	if err := msg.SetOneClickUnsubscribe(); err != nil {
+		t.Fatal(err)
+	}
+	msg.SetBody("text/plain", "Test")
+
+	want := message{
+		from: "from@example.com",
+		to:   []string{"to@example.com"},
+		content: "From: from@example.com\r\n" +
+			"To: to@example.com\r\n" +
+			"List-Unsubscribe: <mailto:unsubscribe@example.com?subject=unsubscribe>,\r\n" +
+			" <https://example.com/unsubscribe?list=newsletter&id=42>\r\n" +
+			"List-Unsubscribe-Post: List-Unsubscribe=One-Click\r\n" +
+			"Content-Type: text/plain; charset=UTF-8\r\n" +
+			"Content-Transfer-Encoding: quoted-printable\r\n" +
+			"\r\n" +
+			"Test",
+	}
+
+	testMessage(t, msg, 0, want)
+}
+
+func TestOneClickUnsubscribeWithoutHTTPS(t *testing.T) {
+	msg := NewMessage()
+	if err := msg.SetOneClickUnsubscribe(); err == nil {
+		t.Error("SetOneClickUnsubscribe should fail without List-Unsubscribe")
+	}
+
+	msg.SetListUnsubscribe("mailto:unsubscribe@example.com", "http://example.com/unsubscribe")
+	err := msg.SetOneClickUnsubscribe()
+	if e, ok := err.(*ValidationError); !ok || e.Field != "List-Unsubscribe" {
+		t.Errorf("Invalid error, got %v", err)
+	}
+	assertHeader(t, msg, "List-Unsubscribe-Post")
+}
+
+func TestSetAutoSubmitted(t *testing.T) {
+	msg := NewMessage()
+	for _, value := range []string{AutoGenerated, AutoReplied, AutoNotified, NotAutoSubmitted} {
+		if err := msg.SetAutoSubmitted(value); err != nil {
+			t.Errorf("SetAutoSubmitted(%q) returned an error: %v", value, err)
+		}
+		assertHeader(t, msg, "Auto-Submitted", value)
+	}
+
+	if err := msg.SetAutoSubmitted("Auto-Generated"); err == nil {
+		t.Error("SetAutoSubmitted should reject unknown values")
+	}
+	assertHeader(t, msg, "Auto-Submitted", NotAutoSubmitted)
+}
+
+func TestSetPrecedence(t *testing.T) {
+	msg := NewMessage()
+	for _, value := range []string{PrecedenceBulk, PrecedenceList, PrecedenceJunk} {
+		if err := msg.SetPrecedence(value); err != nil {
+			t.Errorf("SetPrecedence(%q) returned an error: %v", value, err)
+		}
+		assertHeader(t, msg, "Precedence", value)
+	}
+
+	if err := msg.SetPrecedence("bogus"); err == nil {
+		t.Error("SetPrecedence should reject unknown values")
+	}
+	assertHeader(t, msg, "Precedence", PrecedenceJunk)
+
+	if err := msg.SetAutoSubmitted(NotAutoSubmitted); err == nil {
+		t.Error("Auto-Submitted: no should be rejected with a Precedence field")
+	}
+	assertHeader(t, msg, "Auto-Submitted")
+
+	msg = NewMessage()
+	if err := msg.SetAutoSubmitted(NotAutoSubmitted); err != nil {
+		t.Fatal(err)
+	}
+	if err, ok := msg.SetPrecedence(PrecedenceBulk).(*ValidationError); !ok || err.Field != "Precedence" {
+		t.Errorf("Invalid error, got %v, want a *ValidationError on Precedence", err)
+	}
+	assertHeader(t, msg, "Precedence")
+}
+
+func TestIsAutoSubmitted(t *testing.T) {
+	tests := []struct {
+		header map[string][]string
+		want   bool
+	}{
+		{map[string][]string{}, false},
+		{map[string][]string{"Auto-Submitted": {"no"}}, false},
+		{map[string][]string{"Auto-Submitted": {"auto-generated"}}, true},
+		{map[string][]string{"Auto-Submitted": {"Auto-Replied; owner-email=\"a@example.com\""}}, true},
+		{map[string][]string{"Precedence": {"bulk"}}, true},
+		{map[string][]string{"precedence": {" List "}}, true},
+		{map[string][]string{"Precedence": {"first-class"}}, false},
+		{map[string][]string{"Auto-Submitted": {"no"}, "Precedence": {"junk"}}, true},
+	}
+
+	for _, test := range tests {
+		msg := NewMessage()
+		msg.SetHeaders(test.header)
+		if got := msg.IsAutoSubmitted(); got != test.want {
+			t.Errorf("IsAutoSubmitted() with %q = %v, want %v", test.header, got, test.want)
+		}
+	}
+}
+
+func TestSetHeaderEncoding(t *testing.T) {
+	msg := NewMessage()
+	msg.SetHeaderEncoding("subject", BEncoding)
+	msg.SetHeader("Subject", "Rendez-vous à la gare")
+	assertHeader(t, msg, "Subject", "=?UTF-8?B?UmVuZGV6LXZvdXMgw6AgbGEgZ2FyZQ==?=")
+
+	msg.SetHeaderEncoding("Subject", QEncoding)
+	msg.SetHeader("Subject", "日本")
+	assertHeader(t, msg, "Subject", "=?UTF-8?Q?=E6=97=A5=E6=9C=AC?=")
+
+	msg.SetHeaderEncoding("X-Note", NoHeaderEncoding)
+	msg.SetHeader("X-Note", "Café")
+	assertHeader(t, msg, "X-Note", "Café")
+
+	msg.SetHeaderEncoding("To", BEncoding)
+	msg.SetHeader("To", `"bob@example.com" <bob@example.com>, José <jose@example.com>, josé@example.com`)
+	assertHeader(t, msg, "To", `"bob@example.com" <bob@example.com>, =?UTF-8?B?Sm9zw6k=?= <jose@example.com>, josé@example.com`)
+	msg.SetAddressHeader("To", "bob@example.com", "bob@example.com")
+	assertHeader(t, msg, "To", `"bob@example.com" <bob@example.com>`)
+
+	msg.SetHeaderEncoding("From", NoHeaderEncoding)
+	msg.SetAddressHeader("From", "jose@example.com", "José, Jr.")
+	assertHeader(t, msg, "From", `"José, Jr." <jose@example.com>`)
+
+	msg.SetHeaderEncoding("Reply-To", QEncoding)
+	msg.SetReplyToAddresses(&mail.Address{Name: "José", Address: "jose@example.com"})
+	assertHeader(t, msg, "Reply-To", "=?UTF-8?Q?Jos=C3=A9?= <jose@example.com>")
+	if err := msg.SetReplyTo("José <jose@example.com>"); err != nil {
+		t.Fatal(err)
+	}
+	assertHeader(t, msg, "Reply-To", "=?UTF-8?Q?Jos=C3=A9?= <jose@example.com>")
+}
+
+func TestSetHeaderOrder(t *testing.T) {
+	msg := NewMessage()
+	msg.SetHeader("X-Mailer", "gomail")
+	msg.SetHeader("Subject", "Hello")
+	msg.SetHeader("To", "to@example.com")
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("Message-ID", "<1@example.com>")
+	msg.SetBody("text/plain", "Hello")
+
+	fields := func() []string {
+		b, err := msg.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fields []string
+		for _, line := range strings.Split(string(b), "\r\n") {
+			if line == "" {
+				break
+			}
+			fields = append(fields, line[:strings.Index(line, ":")])
+		}
+		return fields
+	}
+
+	want := []string{"Content-Transfer-Encoding", "Content-Type", "Date", "From", "Message-ID", "Mime-Version", "Subject", "To", "X-Mailer"}
+	if got := fields(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Invalid default order, got %q, want %q", got, want)
+	}
+
+	msg.SetHeaderOrder(CanonicalHeaderOrder)
+	want = []string{"From", "To", "Subject", "Date", "Message-ID", "Content-Transfer-Encoding", "Content-Type", "Mime-Version", "X-Mailer"}
+	if got := fields(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Invalid canonical order, got %q, want %q", got, want)
+	}
+
+	msg.SetHeaderOrder([]string{"x-mailer", "mime-version"})
+	want = []string{"X-Mailer", "Mime-Version", "Content-Transfer-Encoding", "Content-Type", "Date", "From", "Message-ID", "Subject", "To"}
+	if got := fields(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Invalid custom order, got %q, want %q", got, want)
+	}
+	if got := sendToString(t, msg); !strings.HasPrefix(got, "X-Mailer: gomail\r\nMime-Version: 1.0\r\n") {
+		t.Errorf("The order should be used when sending, got:\n%s", got)
+	}
+}
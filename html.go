@@ -0,0 +1,148 @@
+package gomail
+
+import (
+	"html"
+	"strings"
+)
+
+// SetHTMLBody sets the HTML body of the message and, unless the message already
+// has a text/plain body, adds a text/plain alternative derived from it: the
+// tags are removed, the entities decoded and the white space collapsed. The
+// plain text body comes first so that the HTML body is preferred by the email
+// clients supporting it.
+//
+// Example:
+//
+//	msg.SetHTMLBody("<h1>Hello</h1><p>Welcome to <b>gomail</b>!</p>")
+func (msg *Message) SetHTMLBody(body string, settings ...PartSetting) {
+	var parts []part
+	for _, p := range msg.parts {
+		if isPlainText(p.contentType) {
+			parts = append(parts, p)
+		} else if p.body != nil {
+			putBuffer(p.body)
+		}
+	}
+	if len(parts) == 0 {
+		buf := getBuffer()
+		buf.WriteString(htmlToText(body))
+		parts = append(parts, newPart("text/plain", buf, settings))
+	}
+
+	buf := getBuffer()
+	buf.WriteString(body)
+	msg.parts = append(parts, newPart("text/html", buf, settings))
+}
+
+func isPlainText(contentType string) bool {
+	return len(contentType) >= 10 && strings.EqualFold(contentType[:10], "text/plain")
+}
+
+// blockTags are the HTML elements that start on a new line.
+var blockTags = map[string]bool{
+	"address": true, "blockquote": true, "br": true, "div": true, "dl": true,
+	"dt": true, "dd": true, "h1": true, "h2": true, "h3": true, "h4": true,
+	"h5": true, "h6": true, "hr": true, "li": true, "ol": true, "p": true,
+	"pre": true, "section": true, "table": true, "tr": true, "ul": true,
+}
+
+// hiddenTags are the HTML elements whose content is not displayed.
+var hiddenTags = map[string]bool{
+	"head": true, "script": true, "style": true, "title": true,
+}
+
+// htmlToText returns a plain text version of the HTML document s.
+func htmlToText(s string) string {
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	for len(s) > 0 {
+		i := strings.IndexByte(s, '<')
+		if i < 0 {
+			i = len(s)
+		}
+		// Line breaks in the text are displayed as spaces.
+		buf.WriteString(strings.Map(func(r rune) rune {
+			if isHTMLSpace(r) {
+				return ' '
+			}
+			return r
+		}, html.UnescapeString(s[:i])))
+		s = s[i:]
+		if len(s) == 0 {
+			break
+		}
+
+		if strings.HasPrefix(s, "<!--") {
+			s = skipPast(s, "-->")
+			continue
+		}
+		name, closing := tagName(s)
+		s = skipPast(s, ">")
+		if hiddenTags[name] && !closing {
+			s = skipPast(s, "</"+name)
+			s = skipPast(s, ">")
+			continue
+		}
+		switch {
+		case name == "li":
+			// List items are written on their own line with a dash.
+			if !closing {
+				buf.WriteString("\n- ")
+			}
+		case blockTags[name]:
+			buf.WriteByte('\n')
+		}
+	}
+
+	// Trim the lines and keep at most one empty line between paragraphs.
+	lines := strings.Split(buf.String(), "\n")
+	text := make([]string, 0, len(lines))
+	for _, line := range lines {
+		line = strings.Join(strings.FieldsFunc(line, isHTMLSpace), " ")
+		if line == "" && (len(text) == 0 || text[len(text)-1] == "") {
+			continue
+		}
+		text = append(text, line)
+	}
+
+	return strings.TrimSuffix(strings.Join(text, "\n"), "\n")
+}
+
+// isHTMLSpace reports whether r is an HTML white space character. The
+// non-breaking spaces are kept.
+func isHTMLSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n' || r == '\r' || r == '\f'
+}
+
+// tagName returns the lowercase name of the tag starting s and whether it is a
+// closing tag.
+func tagName(s string) (name string, closing bool) {
+	s = s[1:]
+	if strings.HasPrefix(s, "/") {
+		closing = true
+		s = s[1:]
+	}
+	end := 0
+	for end < len(s) && isTagNameChar(s[end]) {
+		end++
+	}
+
+	return strings.ToLower(s[:end]), closing
+}
+
+func isTagNameChar(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+// skipPast returns s after the first occurrence of sep, compared
+// case-insensitively, or an empty string if sep is not found.
+func skipPast(s, sep string) string {
+	for i := 0; i+len(sep) <= len(s); i++ {
+		if strings.EqualFold(s[i:i+len(sep)], sep) {
+			return s[i+len(sep):]
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,33 @@
+package gomail
+
+import "testing"
+
+func TestASCIIAddress(t *testing.T) {
+	tests := []struct {
+		addr, want string
+		err        bool
+	}{
+		{"bob@example.com", "bob@example.com", false},
+		{"bob@bücher.de", "bob@xn--bcher-kva.de", false},
+		{"bob@MÜNCHEN.de", "bob@xn--mnchen-3ya.de", false},
+		{"bob@例子.中国", "bob@xn--fsqu00a.xn--fiqs8s", false},
+		{"müller@example.com", "", true},
+		{"用户@例子.中国", "", true},
+	}
+
+	for _, test := range tests {
+		got, err := asciiAddress(test.addr)
+		if test.err {
+			if err == nil {
+				t.Errorf("asciiAddress(%q) should return an error", test.addr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("asciiAddress(%q) returned an error: %v", test.addr, err)
+		}
+		if got != test.want {
+			t.Errorf("asciiAddress(%q) = %q, want %q", test.addr, got, test.want)
+		}
+	}
+}
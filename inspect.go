@@ -0,0 +1,92 @@
+package gomail
+
+// PartInfo describes a part of the body of a message.
+type PartInfo struct {
+	ContentType string
+	Charset     string
+	Encoding    Encoding
+	// Size is the size in bytes of the unencoded body, or -1 if the body is
+	// written at export time by a function set with SetBodyWriter.
+	Size int
+}
+
+// FileInfo describes a file attached or embedded to a message.
+type FileInfo struct {
+	Name      string
+	MimeType  string
+	ContentID string
+	// Size is the size in bytes of the content, or -1 if it is unknown because
+	// the content is read when the message is exported.
+	Size int64
+}
+
+// Parts returns a description of the parts of the body of the message, in the
+// order they were added: the body first, then the alternatives and then the
+// parts added with AddPart.
+//
+// Example:
+//
+//	for _, p := range msg.Parts() {
+//		fmt.Println(p.ContentType, p.Size)
+//	}
+func (msg *Message) Parts() []PartInfo {
+	parts := append(msg.parts[:len(msg.parts):len(msg.parts)], msg.mixedParts...)
+	infos := make([]PartInfo, len(parts))
+	for i, p := range parts {
+		info := PartInfo{
+			ContentType: p.contentType,
+			Charset:     msg.charset,
+			Encoding:    msg.encoding,
+			Size:        -1,
+		}
+		if p.charset != "" {
+			info.Charset = p.charset
+		}
+		if p.encoding != "" {
+			info.Encoding = p.encoding
+		}
+		if p.write == nil {
+			info.Size = p.body.Len()
+		}
+		infos[i] = info
+	}
+
+	return infos
+}
+
+// Attachments returns a description of the files attached to the message.
+func (msg *Message) Attachments() []FileInfo {
+	return fileInfos(msg.attachments, false)
+}
+
+// Embedded returns a description of the files embedded in the message. Their
+// ContentID is the one used when the message is exported.
+func (msg *Message) Embedded() []FileInfo {
+	return fileInfos(msg.embedded, true)
+}
+
+func fileInfos(files []*File, embedded bool) []FileInfo {
+	infos := make([]FileInfo, len(files))
+	for i, f := range files {
+		info := FileInfo{
+			Name:      f.Name,
+			MimeType:  f.MimeType,
+			ContentID: f.ContentID,
+			Size:      int64(len(f.Content)),
+		}
+		if embedded {
+			info.ContentID = contentID(f)
+		}
+		if f.isStream() {
+			info.Size = -1
+			if f.hasSize {
+				info.Size = f.size
+			} else if _, ok := f.reader.(*sizedReader); ok {
+				info.Size = f.streamSize
+			}
+		}
+		infos[i] = info
+	}
+
+	return infos
+}
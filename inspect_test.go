@@ -0,0 +1,55 @@
+package gomail
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInspect(t *testing.T) {
+	msg := NewMessage()
+	msg.SetBody("text/plain", "Hello")
+	msg.AddAlternativeWriter("text/html", func(w io.Writer) error {
+		_, err := io.WriteString(w, "<p>Hello</p>")
+		return err
+	}, SetPartEncoding(Base64))
+	msg.Attach(CreateFile("report.pdf", []byte("Content")))
+	if err := msg.AttachReader("data.csv", strings.NewReader("a,b"), SetFileSize(3), SetMimeType("text/csv")); err != nil {
+		t.Fatal(err)
+	}
+	if err := msg.AttachReader("log.txt", strings.NewReader("log")); err != nil {
+		t.Fatal(err)
+	}
+	msg.Embed(CreateFile("logo.png", []byte("PNG")))
+
+	wantParts := []PartInfo{
+		{ContentType: "text/plain", Charset: "UTF-8", Encoding: QuotedPrintable, Size: 5},
+		{ContentType: "text/html", Charset: "UTF-8", Encoding: Base64, Size: -1},
+	}
+	if got := msg.Parts(); !reflect.DeepEqual(got, wantParts) {
+		t.Errorf("Invalid parts, got %+v, want %+v", got, wantParts)
+	}
+
+	wantAttachments := []FileInfo{
+		{Name: "report.pdf", MimeType: "application/pdf", Size: 7},
+		{Name: "data.csv", MimeType: "text/csv", Size: 3},
+		{Name: "log.txt", MimeType: "text/plain; charset=utf-8", Size: -1},
+	}
+	if got := msg.Attachments(); !reflect.DeepEqual(got, wantAttachments) {
+		t.Errorf("Invalid attachments, got %+v, want %+v", got, wantAttachments)
+	}
+
+	wantEmbedded := []FileInfo{
+		{Name: "logo.png", MimeType: "image/png", ContentID: "logo.png", Size: 3},
+	}
+	if got := msg.Embedded(); !reflect.DeepEqual(got, wantEmbedded) {
+		t.Errorf("Invalid embedded files, got %+v, want %+v", got, wantEmbedded)
+	}
+
+	// The descriptions are copies.
+	msg.Attachments()[0].Name = "changed.pdf"
+	if name := msg.Attachments()[0].Name; name != "report.pdf" {
+		t.Errorf("The attachments should not be modified, got name %q", name)
+	}
+}
@@ -1,6 +1,8 @@
 package gomail
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
@@ -8,16 +10,32 @@ import (
 	"net"
 	"net/mail"
 	"net/smtp"
+	"sort"
+	"strconv"
 	"strings"
 )
 
 // A Mailer represents an SMTP server.
 type Mailer struct {
-	addr   string
-	host   string
-	config *tls.Config
-	auth   smtp.Auth
-	send   SendMailFunc
+	addr     string
+	host     string
+	config   *tls.Config
+	auth     smtp.Auth
+	send     SendMailFunc
+	ssl      bool
+	validate bool
+	// requireTLS makes the connection fail if STARTTLS is not supported.
+	requireTLS bool
+	// localName is the host name sent with the EHLO command.
+	localName string
+	// maxRecipients is the maximum number of recipients per transaction.
+	maxRecipients int
+	// retry sets how emails are sent again after a temporary failure.
+	retry RetryPolicy
+	// netDialer establishes the connections to the SMTP server.
+	netDialer NetDialer
+	// defaultSend is true when send was not set with SetSendMail.
+	defaultSend bool
 }
 
 // A MailerSetting can be used in a mailer constructor to configure it.
@@ -37,24 +55,151 @@ func SetSendMail(s SendMailFunc) MailerSetting {
 	}
 }
 
+// A NetDialer establishes network connections. It is implemented by
+// *net.Dialer and by the dialers of golang.org/x/net/proxy.
+type NetDialer interface {
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// SetNetDialer allows to set the dialer used to connect to the SMTP server
+// before TLS or STARTTLS is used, for example to go through a SOCKS5 proxy or
+// to bind a local address. A zero net.Dialer is used by default.
+//
+// Example:
+//
+//	d := &net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1")}}
+//	mailer := gomail.NewMailer("host", "user", "pwd", 587, gomail.SetNetDialer(d))
+func SetNetDialer(d NetDialer) MailerSetting {
+	return func(m *Mailer) {
+		m.netDialer = d
+	}
+}
+
 // SetTLSConfig allows to set the TLS configuration used to connect the SMTP
-// server.
+// server, with both implicit TLS and STARTTLS. If its ServerName is empty, the
+// host of the mailer is used.
 func SetTLSConfig(c *tls.Config) MailerSetting {
 	return func(m *Mailer) {
 		m.config = c
 	}
 }
 
+// SetSSL allows to choose whether the mailer connects to the SMTP server with
+// implicit TLS or with STARTTLS. By default, implicit TLS is only used on port
+// 465.
+func SetSSL(ssl bool) MailerSetting {
+	return func(m *Mailer) {
+		m.ssl = ssl
+	}
+}
+
+// SetRequireTLS allows to make the mailer fail with ErrTLSUnsupported instead of
+// sending emails in clear text when the SMTP server does not support STARTTLS.
+// It has no effect with implicit TLS.
+//
+// Example:
+//
+//	mailer := gomail.NewMailer("host", "user", "pwd", 587, gomail.SetRequireTLS(true))
+func SetRequireTLS(require bool) MailerSetting {
+	return func(m *Mailer) {
+		m.requireTLS = require
+	}
+}
+
+// SetLocalName allows to set the host name sent to the SMTP server with the EHLO
+// command. It must be a valid domain name or an address literal, such as
+// "[192.0.2.1]", otherwise sending fails. By default, "localhost" is used, which
+// some SMTP servers reject.
+//
+// Example:
+//
+//	mailer := gomail.NewMailer("host", "user", "pwd", 587, gomail.SetLocalName("mail.example.com"))
+func SetLocalName(name string) MailerSetting {
+	return func(m *Mailer) {
+		m.localName = name
+	}
+}
+
+// ErrTLSUnsupported is returned when the SMTP server does not support STARTTLS
+// and the mailer was created with SetRequireTLS(true).
+var ErrTLSUnsupported = errors.New("gomail: the SMTP server does not support STARTTLS")
+
+// SetMaxRecipients allows to set the maximum number of recipients of an SMTP
+// transaction. Emails to more recipients are sent in several transactions,
+// each with the same content. It is 100 by default, the minimum that SMTP
+// servers must accept according to RFC 5321, and 0 means no limit.
+//
+// Example:
+//
+//	mailer := gomail.NewMailer("host", "user", "pwd", 587, gomail.SetMaxRecipients(50))
+func SetMaxRecipients(n int) MailerSetting {
+	return func(m *Mailer) {
+		m.maxRecipients = n
+	}
+}
+
+// A RecipientError is the error of one of the recipients of an email.
+type RecipientError struct {
+	Recipient string
+	Err       error
+}
+
+func (e *RecipientError) Error() string {
+	return e.Recipient + ": " + e.Err.Error()
+}
+
+func (e *RecipientError) Unwrap() error {
+	return e.Err
+}
+
+// RecipientErrors is returned by Mailer.Send when an email sent in several
+// transactions, because of SetMaxRecipients or of Bcc recipients, could not be
+// sent to some recipients. It was sent to the others.
+type RecipientErrors struct {
+	Errors []*RecipientError
+}
+
+func (e *RecipientErrors) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+
+	return "gomail: the email could not be sent to " + strconv.Itoa(len(e.Errors)) + " recipients: " + strings.Join(msgs, "; ")
+}
+
+func (e *RecipientErrors) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, err := range e.Errors {
+		errs[i] = err
+	}
+
+	return errs
+}
+
+// ErrNoRecipient is returned when sending a message without any recipient,
+// for example when SetExcludeFrom removes its only one.
+var ErrNoRecipient = errors.New("gomail: invalid message, there is no recipient")
+
+// SetMessageValidation allows to make the mailer validate the messages with
+// Message.Validate before sending them.
+func SetMessageValidation(validate bool) MailerSetting {
+	return func(m *Mailer) {
+		m.validate = validate
+	}
+}
+
 // A SendMailFunc is a function to send emails with the same signature than
 // smtp.SendMail.
 type SendMailFunc func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
 
 // NewMailer returns a mailer. The given parameters are used to connect to the
-// SMTP server via a PLAIN authentication mechanism.
+// SMTP server with the strongest authentication mechanism it supports, see
+// NegotiateAuth.
 func NewMailer(host string, username string, password string, port int, settings ...MailerSetting) *Mailer {
 	return NewCustomMailer(
 		fmt.Sprintf("%s:%d", host, port),
-		smtp.PlainAuth("", username, password, host),
+		NegotiateAuth(username, password, host),
 		settings...,
 	)
 }
@@ -69,73 +214,146 @@ func NewCustomMailer(addr string, auth smtp.Auth, settings ...MailerSetting) *Ma
 	host, port, _ := net.SplitHostPort(addr)
 
 	m := &Mailer{
-		addr: addr,
-		host: host,
-		auth: auth,
+		addr:          addr,
+		host:          host,
+		auth:          auth,
+		maxRecipients: 100,
 	}
 
+	// Implicit TLS is used on port 465 unless SetSSL says otherwise.
+	m.ssl = port == "465"
 	for _, s := range settings {
 		s(m)
 	}
 
 	if m.config == nil {
 		m.config = &tls.Config{ServerName: host}
+	} else if m.config.ServerName == "" {
+		// The configuration given by the user must not be modified.
+		m.config = m.config.Clone()
+		m.config.ServerName = host
+	}
+	if m.netDialer == nil {
+		m.netDialer = new(net.Dialer)
 	}
 	if m.send == nil {
-		m.send = m.getSendMailFunc(port == "465")
+		m.send = m.getSendMailFunc(m.ssl, nil)
+		m.defaultSend = true
 	}
 
 	return m
 }
 
 // Send sends the emails to all the recipients of the message.
-func (m *Mailer) Send(msg *Message) error {
-	message := msg.Export()
+//
+// Example:
+//
+//	err := mailer.Send(msg, gomail.WithDSN(gomail.DSNOptions{Return: "HDRS"}))
+func (m *Mailer) Send(msg *Message, opts ...SendOption) error {
+	send := m.send
+	if m.defaultSend {
+		send = m.getSendMailFunc(m.ssl, msg.sendOptions(opts))
+	}
+
+	return m.sendMessage(msg, send)
+}
+
+func (m *Mailer) sendMessage(msg *Message, send SendMailFunc) error {
+	if m.validate {
+		if err := msg.Validate(); err != nil {
+			return err
+		}
+	}
+
+	w, err := msg.exportWriter()
+	if err != nil {
+		return err
+	}
+	defer putMessageWriter(w)
+	message := w.export()
 
-	from, err := getFrom(message)
+	from, err := msg.getEnvelopeFrom(message)
 	if err != nil {
 		return err
 	}
-	recipients, bcc, err := getRecipients(message)
+	recipients, bcc, err := getRecipients(message.Header, msg.excludeFrom)
 	if err != nil {
 		return err
 	}
 
-	h := flattenHeader(message, "")
+	h := flattenHeader(message, "", msg.headerOrder)
 	body, err := ioutil.ReadAll(message.Body)
 	if err != nil {
 		return err
 	}
 
-	mail := append(h, body...)
-	if err := m.send(m.addr, m.auth, from, recipients, mail); err != nil {
-		return err
+	if msg.maxSize > 0 && int64(len(h)+len(body)) > msg.maxSize {
+		return ErrMessageTooLarge
 	}
 
+	// A transaction is made for each group of recipients and for each Bcc
+	// recipient since they get their own header.
+	type transaction struct {
+		to   []string
+		mail []byte
+	}
+	var txs []transaction
+	mail := append(h, body...)
+	for len(recipients) > 0 {
+		n := len(recipients)
+		if m.maxRecipients > 0 && n > m.maxRecipients {
+			n = m.maxRecipients
+		}
+		txs = append(txs, transaction{recipients[:n], mail})
+		recipients = recipients[n:]
+	}
 	for _, to := range bcc {
-		h = flattenHeader(message, to)
-		mail = append(h, body...)
-		if err := m.send(m.addr, m.auth, from, []string{to}, mail); err != nil {
-			return err
+		h = flattenHeader(message, to, msg.headerOrder)
+		txs = append(txs, transaction{[]string{to}, append(h, body...)})
+	}
+	if len(txs) == 0 {
+		return ErrNoRecipient
+	}
+
+	if len(txs) == 1 {
+		return m.sendWithRetry(send, from, txs[0].to, txs[0].mail)
+	}
+	var errs []*RecipientError
+	for _, tx := range txs {
+		if err := m.sendWithRetry(send, from, tx.to, tx.mail); err != nil {
+			for _, to := range tx.to {
+				errs = append(errs, &RecipientError{Recipient: to, Err: err})
+			}
 		}
 	}
+	if len(errs) > 0 {
+		return &RecipientErrors{Errors: errs}
+	}
 
 	return nil
 }
 
-func flattenHeader(msg *mail.Message, bcc string) []byte {
+// flattenHeader writes the header fields of msg, the fields listed in order
+// first, as set with Message.SetHeaderOrder. Bcc is only written if bcc is not
+// empty, with the values holding the address bcc, as returned by
+// getRecipients.
+func flattenHeader(msg *mail.Message, bcc string, order []string) []byte {
 	buf := getBuffer()
 	defer putBuffer(buf)
 
-	for field, value := range msg.Header {
+	fields := make([]string, 0, len(msg.Header))
+	for field := range msg.Header {
+		fields = append(fields, field)
+	}
+	sortHeaderFields(fields, order)
+
+	for _, field := range fields {
+		value := msg.Header[field]
 		if field != "Bcc" {
-			buf.WriteString(field)
-			buf.WriteString(": ")
-			buf.WriteString(strings.Join(value, ", "))
-			buf.WriteString("\r\n")
+			writeHeaderField(buf, field, value)
 		} else if bcc != "" {
 			for _, to := range value {
-				if strings.Contains(to, bcc) {
+				if addr, err := parseAddress(to); err == nil && normalizeAddress(addr) == bcc {
 					buf.WriteString(field)
 					buf.WriteString(": ")
 					buf.WriteString(to)
@@ -146,24 +364,152 @@ func flattenHeader(msg *mail.Message, bcc string) []byte {
 	}
 	buf.WriteString("\r\n")
 
-	return buf.Bytes()
+	// The buffer goes back to the pool so its content must be copied.
+	return append([]byte(nil), buf.Bytes()...)
+}
+
+// sortHeaderFields sorts fields so that the fields listed in order come first,
+// in that order, followed by the others sorted by name. The output then does
+// not depend on the map iteration order.
+func sortHeaderFields(fields, order []string) {
+	rank := func(field string) int {
+		for i, f := range order {
+			if strings.EqualFold(f, field) {
+				return i
+			}
+		}
+		return len(order)
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		ri, rj := rank(fields[i]), rank(fields[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return fields[i] < fields[j]
+	})
+}
+
+// maxHeaderLineLen is the length after which header lines are folded as
+// recommended by RFC 5322, 2.1.1.
+const maxHeaderLineLen = 78
+
+// writeHeaderField writes a header field whose values are separated by commas.
+// The line is folded between two values when it gets too long, and between two
+// words of the values containing encoded-words.
+func writeHeaderField(buf *bytes.Buffer, field string, value []string) {
+	buf.WriteString(field)
+	buf.WriteString(":")
+	lineLen := len(field) + 1
+	for i, v := range value {
+		if i > 0 {
+			buf.WriteByte(',')
+			lineLen++
+		}
+		if strings.Contains(v, "=?") {
+			// RFC 2047, 2. requires short lines around encoded-words so the
+			// line may even be folded before the first one.
+			lineLen = writeFolded(buf, v, lineLen, true)
+			continue
+		}
+		if i > 0 && lineLen+1+len(v) > maxHeaderLineLen {
+			buf.WriteString("\r\n")
+			lineLen = 0
+		}
+		buf.WriteByte(' ')
+		buf.WriteString(v)
+		lineLen += 1 + len(v)
+	}
+	buf.WriteString("\r\n")
+}
+
+// writeFolded writes the words of value to buf, each one preceded by a space.
+// The line, whose length is lineLen, is folded before a word that would make it
+// longer than maxHeaderLineLen, except before the first word unless foldFirst
+// is true. It returns the length of the last line.
+func writeFolded(buf *bytes.Buffer, value string, lineLen int, foldFirst bool) int {
+	for i, word := range strings.Split(value, " ") {
+		if (i > 0 || foldFirst) && lineLen+1+len(word) > maxHeaderLineLen {
+			buf.WriteString("\r\n")
+			lineLen = 0
+		}
+		buf.WriteByte(' ')
+		buf.WriteString(word)
+		// The value may already be folded.
+		if j := strings.LastIndexByte(word, '\n'); j != -1 {
+			lineLen = len(word) - j - 1
+		} else {
+			lineLen += 1 + len(word)
+		}
+	}
+
+	return lineLen
+}
+
+// getEnvelopeFrom returns the envelope sender set with SetEnvelopeFrom or else
+// the sender of m.
+func (msg *Message) getEnvelopeFrom(m *mail.Message) (string, error) {
+	if msg.envelopeFrom == "" {
+		return getFrom(m)
+	}
+
+	addr, err := parseAddress(msg.envelopeFrom)
+	if err != nil {
+		return "", invalidAddress("Return-Path", msg.envelopeFrom, err)
+	}
+
+	return addr, nil
 }
 
 func getFrom(msg *mail.Message) (string, error) {
-	from := msg.Header.Get("Sender")
+	field := "Sender"
+	from := msg.Header.Get(field)
 	if from == "" {
-		from = msg.Header.Get("From")
+		field = "From"
+		from = msg.Header.Get(field)
 		if from == "" {
 			return "", errors.New("mailer: invalid message, \"From\" field is absent")
 		}
 	}
 
-	return parseAddress(from)
+	addr, err := parseAddress(from)
+	if err != nil {
+		return "", invalidAddress(field, from, err)
+	}
+
+	return addr, nil
+}
+
+// GetEnvelopeFrom returns the envelope sender of the message, the address given
+// to the MAIL command: the address set with SetEnvelopeFrom or else the address
+// of the Sender or From header field. If the address is invalid, it returns a
+// *ValidationError naming its header field, Return-Path for the address set
+// with SetEnvelopeFrom.
+func (msg *Message) GetEnvelopeFrom() (string, error) {
+	return msg.getEnvelopeFrom(&mail.Message{Header: mail.Header(msg.header)})
 }
 
-func getRecipients(msg *mail.Message) (recipients, bcc []string, err error) {
+// GetRecipients returns the addresses of the To, Cc and Bcc header fields
+// without duplicates, their domains in lowercase. These are the addresses the
+// message is sent to. The addresses of the From header field are left out if
+// the message is created with SetExcludeFrom. If an address is invalid, it
+// returns a *ValidationError naming its header field.
+func (msg *Message) GetRecipients() ([]string, error) {
+	recipients, bcc, err := getRecipients(msg.header, msg.excludeFrom)
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range bcc {
+		recipients, _ = addAdress(recipients, addr)
+	}
+
+	return recipients, nil
+}
+
+// getRecipients returns the addresses of the To and Cc header fields and the
+// ones only found in the Bcc header field, which get their own transaction.
+func getRecipients(h map[string][]string, excludeFrom bool) (recipients, bcc []string, err error) {
 	for _, field := range []string{"Bcc", "To", "Cc"} {
-		if addresses, ok := msg.Header[field]; ok {
+		if addresses, ok := h[field]; ok {
 			for _, addr := range addresses {
 				switch field {
 				case "Bcc":
@@ -172,11 +518,24 @@ func getRecipients(msg *mail.Message) (recipients, bcc []string, err error) {
 					recipients, err = addAdress(recipients, addr)
 				}
 				if err != nil {
-					return recipients, bcc, err
+					return recipients, bcc, invalidAddress(field, addr, err)
 				}
 			}
 		}
 	}
+	// A Bcc recipient also in To or Cc already gets the message.
+	bcc = removeAddresses(bcc, recipients)
+	if excludeFrom {
+		var from []string
+		for _, v := range h["From"] {
+			list, _ := mail.ParseAddressList(v)
+			for _, a := range list {
+				from = append(from, normalizeAddress(a.Address))
+			}
+		}
+		recipients = removeAddresses(recipients, from)
+		bcc = removeAddresses(bcc, from)
+	}
 
 	return recipients, bcc, nil
 }
@@ -186,6 +545,7 @@ func addAdress(list []string, addr string) ([]string, error) {
 	if err != nil {
 		return list, err
 	}
+	addr = normalizeAddress(addr)
 	for _, a := range list {
 		if addr == a {
 			return list, nil
@@ -195,6 +555,39 @@ func addAdress(list []string, addr string) ([]string, error) {
 	return append(list, addr), nil
 }
 
+// removeAddresses returns list without the addresses in remove.
+func removeAddresses(list, remove []string) []string {
+	kept := list[:0]
+	for _, addr := range list {
+		found := false
+		for _, r := range remove {
+			if addr == r {
+				found = true
+				break
+			}
+		}
+		if !found {
+			kept = append(kept, addr)
+		}
+	}
+	if len(kept) == 0 {
+		return nil
+	}
+
+	return kept
+}
+
+// normalizeAddress lowercases the domain of addr. The local part is kept as
+// is since it may be case-sensitive.
+func normalizeAddress(addr string) string {
+	i := strings.LastIndexByte(addr, '@')
+	if i < 0 {
+		return addr
+	}
+
+	return addr[:i+1] + strings.ToLower(addr[i+1:])
+}
+
 func parseAddress(field string) (string, error) {
 	a, err := mail.ParseAddress(field)
 	if a == nil {
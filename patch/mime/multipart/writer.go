@@ -120,6 +120,30 @@ func (w *Writer) CreatePart(header textproto.MIMEHeader) (io.Writer, error) {
 	return p, nil
 }
 
+// CreateRawPart creates a new multipart section without writing any header.
+// The caller is responsible for writing the complete part, header included,
+// to the returned Writer. After calling CreateRawPart, any previous part may
+// no longer be written to.
+func (w *Writer) CreateRawPart() (io.Writer, error) {
+	if w.lastpart != nil {
+		if err := w.lastpart.close(); err != nil {
+			return nil, err
+		}
+		if _, err := fmt.Fprintf(w.w, "\r\n--%s\r\n", w.boundary); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := fmt.Fprintf(w.w, "--%s\r\n", w.boundary); err != nil {
+			return nil, err
+		}
+	}
+	p := &part{
+		mw: w,
+	}
+	w.lastpart = p
+	return p, nil
+}
+
 var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
 
 func escapeQuotes(s string) string {
@@ -0,0 +1,176 @@
+package gomail
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/smtp"
+	"sync"
+)
+
+// A Pool sends emails using a pool of reusable connections to the SMTP server
+// of a Mailer. It is safe for concurrent use by multiple goroutines.
+//
+// Pools do not use the email-sending function set with SetSendMail.
+type Pool struct {
+	m     *Mailer
+	conns chan smtpClient
+	slots chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// ErrPoolClosed is returned when sending an email through a closed pool.
+var ErrPoolClosed = errors.New("gomail: pool is closed")
+
+// Pool returns a pool of at most size connections to the SMTP server.
+// Connections are opened when needed and kept open between emails.
+//
+// Example:
+//
+//	pool := mailer.Pool(4)
+//	defer pool.Close()
+//	for _, msg := range messages {
+//		if err := pool.Send(msg); err != nil {
+//			log.Print(err)
+//		}
+//	}
+func (m *Mailer) Pool(size int) *Pool {
+	if size < 1 {
+		size = 1
+	}
+
+	return &Pool{
+		m:     m,
+		conns: make(chan smtpClient, size),
+		slots: make(chan struct{}, size),
+	}
+}
+
+// Send sends the emails to all the recipients of the message. If the
+// connection fails, whether it was idle or has just been opened, the email is
+// sent again once using a new connection.
+func (p *Pool) Send(msg *Message, opts ...SendOption) error {
+	o := msg.sendOptions(opts)
+	return p.m.sendMessage(msg, func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		return p.sendMail(from, to, msg, o)
+	})
+}
+
+func (p *Pool) sendMail(from string, to []string, msg []byte, o *sendOptions) error {
+	c, reused, err := p.get()
+	if err != nil {
+		return err
+	}
+
+	err = p.send(c, reused, from, to, msg, o)
+	if err != nil && isConnError(err) {
+		// The server may have closed an idle connection or dropped this one,
+		// try a fresh one. The slot is kept so that another sender cannot take
+		// it in between.
+		c.Close()
+		if c, err = p.m.dial(p.m.addr, p.m.auth, p.m.ssl); err != nil {
+			p.release()
+			return err
+		}
+		err = p.send(c, false, from, to, msg, o)
+	}
+	if err != nil && isConnError(err) {
+		c.Close()
+		p.release()
+		return err
+	}
+	p.put(c)
+
+	return err
+}
+
+func (p *Pool) send(c smtpClient, reused bool, from string, to []string, msg []byte, o *sendOptions) error {
+	if reused {
+		if err := c.Reset(); err != nil {
+			return wrapSMTPError(err)
+		}
+	}
+
+	return sendMail(c, from, to, msg, o)
+}
+
+// get returns an idle connection or a new one if there is none and the pool
+// is not full.
+func (p *Pool) get() (c smtpClient, reused bool, err error) {
+	p.mu.Lock()
+	closed := p.closed
+	p.mu.Unlock()
+	if closed {
+		return nil, false, ErrPoolClosed
+	}
+
+	select {
+	case c := <-p.conns:
+		return c, true, nil
+	default:
+	}
+
+	select {
+	case c := <-p.conns:
+		return c, true, nil
+	case p.slots <- struct{}{}:
+		c, err := p.m.dial(p.m.addr, p.m.auth, p.m.ssl)
+		if err != nil {
+			p.release()
+			return nil, false, err
+		}
+		return c, false, nil
+	}
+}
+
+// put makes the connection available to other senders or closes it if the
+// pool was closed meanwhile.
+func (p *Pool) put(c smtpClient) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		c.Quit()
+		c.Close()
+		p.release()
+		return
+	}
+	p.conns <- c
+}
+
+func (p *Pool) release() {
+	<-p.slots
+}
+
+// Close closes the idle connections of the pool. Connections in use are closed
+// once their email is sent.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+
+	var err error
+	for {
+		select {
+		case c := <-p.conns:
+			if qErr := c.Quit(); qErr != nil && err == nil {
+				err = qErr
+			}
+			c.Close()
+			p.release()
+		default:
+			return err
+		}
+	}
+}
+
+// isConnError reports whether err is an error of the connection rather than an
+// error reply of the SMTP server.
+func isConnError(err error) bool {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
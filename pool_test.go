@@ -0,0 +1,305 @@
+package gomail
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyServer counts the emails sent through its clients. Every failEvery
+// DATA command, the connection is dropped. At the shutdownAt DATA command, the
+// server replies 421 and closes the connection.
+type flakyServer struct {
+	mu         sync.Mutex
+	dials      int
+	datas      int
+	sent       int
+	failEvery  int
+	shutdownAt int
+	open       int
+	maxOpen    int
+}
+
+type flakyClient struct {
+	s        *flakyServer
+	closed   bool
+	released bool
+	inTx     bool
+}
+
+func (s *flakyServer) dial(d NetDialer, addr string) (smtpClient, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dials++
+	s.open++
+	if s.open > s.maxOpen {
+		s.maxOpen = s.open
+	}
+	return &flakyClient{s: s}, nil
+}
+
+func (c *flakyClient) Hello(string) error              { return nil }
+func (c *flakyClient) Extension(string) (bool, string) { return false, "" }
+func (c *flakyClient) StartTLS(*tls.Config) error      { return nil }
+func (c *flakyClient) Auth(smtp.Auth) error            { return nil }
+
+func (c *flakyClient) Mail(from string) error {
+	if c.closed {
+		return io.EOF
+	}
+	if c.inTx {
+		return &textproto.Error{Code: 503, Msg: "nested MAIL command"}
+	}
+	c.inTx = true
+	return nil
+}
+
+func (c *flakyClient) Rcpt(to string) error {
+	if c.closed {
+		return io.EOF
+	}
+	if to == "reject@example.com" {
+		return &textproto.Error{Code: 550, Msg: "no such user"}
+	}
+	return nil
+}
+
+func (c *flakyClient) MailParams(from string, params ...string) error { return c.Mail(from) }
+func (c *flakyClient) RcptParams(to string, params ...string) error   { return c.Rcpt(to) }
+
+func (c *flakyClient) Bdat([]byte) error {
+	return &textproto.Error{Code: 502, Msg: "BDAT is not supported"}
+}
+
+func (c *flakyClient) Data() (io.WriteCloser, error) {
+	c.s.mu.Lock()
+	c.s.datas++
+	fail := c.s.failEvery > 0 && c.s.datas%c.s.failEvery == 0
+	shutdown := c.s.datas == c.s.shutdownAt
+	c.s.mu.Unlock()
+	if shutdown {
+		c.closed = true
+		return nil, &textproto.Error{Code: 421, Msg: "service shutting down"}
+	}
+	if c.closed || fail {
+		c.closed = true
+		return nil, io.EOF
+	}
+	return &flakyWriter{c: c}, nil
+}
+
+func (c *flakyClient) Reset() error {
+	if c.closed {
+		return io.EOF
+	}
+	c.inTx = false
+	return nil
+}
+
+func (c *flakyClient) Quit() error { return nil }
+
+func (c *flakyClient) Close() error {
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+	if !c.released {
+		c.s.open--
+	}
+	c.released = true
+	c.closed = true
+	return nil
+}
+
+type flakyWriter struct {
+	c *flakyClient
+}
+
+func (w *flakyWriter) Write(p []byte) (int, error) { return len(p), nil }
+
+func (w *flakyWriter) Close() error {
+	w.c.inTx = false
+	w.c.s.mu.Lock()
+	w.c.s.sent++
+	w.c.s.mu.Unlock()
+	return nil
+}
+
+func TestPool(t *testing.T) {
+	server := &flakyServer{failEvery: 7}
+	initSMTP = server.dial
+
+	mailer := NewMailer("host", "username", "password", 587)
+	pool := mailer.Pool(3)
+
+	const senders, perSender = 10, 10
+	var wg sync.WaitGroup
+	errs := make(chan error, senders*perSender)
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perSender; j++ {
+				msg := NewMessage()
+				msg.SetHeader("From", "from@example.com")
+				msg.SetHeader("To", "to@example.com")
+				msg.SetBody("text/plain", "Test")
+				if err := pool.Send(msg); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	failed := 0
+	for err := range errs {
+		if err != io.EOF {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		failed++
+	}
+	if err := pool.Close(); err != nil {
+		t.Error(err)
+	}
+
+	if server.sent+failed != senders*perSender {
+		t.Errorf("Invalid number of emails, got %d sent and %d failed, want %d", server.sent, failed, senders*perSender)
+	}
+	// An email only fails if the retry also fails.
+	if failed > server.datas/server.failEvery/2 {
+		t.Errorf("Too many failures, got %d for %d DATA commands", failed, server.datas)
+	}
+	if server.maxOpen > 3 {
+		t.Errorf("Too many open connections, got %d, want at most 3", server.maxOpen)
+	}
+	if server.dials >= senders*perSender {
+		t.Errorf("Connections are not reused, got %d dials", server.dials)
+	}
+}
+
+func TestPoolRetryNewConnection(t *testing.T) {
+	// The DATA command of the first connection fails.
+	server := &flakyServer{datas: 1, failEvery: 2}
+	initSMTP = server.dial
+
+	pool := NewMailer("host", "username", "password", 587).Pool(1)
+	defer pool.Close()
+
+	if err := pool.Send(newBatchMessage("to@example.com")); err != nil {
+		t.Fatal(err)
+	}
+	if server.dials != 2 || server.sent != 1 {
+		t.Errorf("Invalid counts, got %d dials and %d sent, want 2 and 1", server.dials, server.sent)
+	}
+}
+
+type slowCloseClient struct {
+	*flakyClient
+}
+
+func (c slowCloseClient) Close() error {
+	time.Sleep(10 * time.Millisecond)
+	return c.flakyClient.Close()
+}
+
+func TestPoolRetryConcurrent(t *testing.T) {
+	// Only the first DATA command fails and closing the connection lets the
+	// other sender wait for the slot.
+	server := &flakyServer{datas: 99, failEvery: 100}
+	initSMTP = func(d NetDialer, addr string) (smtpClient, error) {
+		c, err := server.dial(d, addr)
+		return slowCloseClient{c.(*flakyClient)}, err
+	}
+
+	pool := NewMailer("host", "username", "password", 587).Pool(1)
+	defer pool.Close()
+
+	// The retry must not wait for a slot taken by another sender whose
+	// connection is idle in the pool.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var wg sync.WaitGroup
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 5; j++ {
+					if err := pool.Send(newBatchMessage("to@example.com")); err != nil {
+						t.Error(err)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The senders are blocked")
+	}
+	if server.maxOpen > 1 {
+		t.Errorf("Too many open connections, got %d, want at most 1", server.maxOpen)
+	}
+}
+
+func TestIsConnError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{io.EOF, true},
+		{fmt.Errorf("gomail: %w", io.ErrUnexpectedEOF), true},
+		{fmt.Errorf("gomail: %w", &net.OpError{Op: "write", Err: errors.New("broken pipe")}), true},
+		{&textproto.Error{Code: 550, Msg: "no such user"}, false},
+	}
+	for _, test := range tests {
+		if got := isConnError(test.err); got != test.want {
+			t.Errorf("isConnError(%v) = %v, want %v", test.err, got, test.want)
+		}
+	}
+}
+
+func TestPoolServerError(t *testing.T) {
+	server := &flakyServer{}
+	initSMTP = server.dial
+
+	pool := NewMailer("host", "username", "password", 587).Pool(1)
+	defer pool.Close()
+
+	msg := NewMessage()
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "reject@example.com")
+	msg.SetBody("text/plain", "Test")
+	if err := pool.Send(msg); err == nil {
+		t.Fatal("Send should return the error of the server")
+	}
+
+	// The connection is reset and reused for the next email.
+	msg.SetHeader("To", "to@example.com")
+	if err := pool.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+	if server.dials != 1 || server.sent != 1 {
+		t.Errorf("Invalid counts, got %d dials and %d sent, want 1 and 1", server.dials, server.sent)
+	}
+}
+
+func TestPoolClosed(t *testing.T) {
+	pool := NewMailer("host", "username", "password", 587).Pool(1)
+	pool.Close()
+
+	msg := NewMessage()
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	if err := pool.Send(msg); err != ErrPoolClosed {
+		t.Errorf("Invalid error, got %v, want %v", err, ErrPoolClosed)
+	}
+}
@@ -0,0 +1,157 @@
+package gomail
+
+import (
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+)
+
+// ReadMessage parses an email, as defined in RFC 5322, read from r. The MIME
+// tree of the email is walked to rebuild the parts, the embedded files and the
+// attachments of the message so that the email can be modified and sent again.
+//
+// Example:
+//
+//	f, err := os.Open("message.eml")
+//	if err != nil {
+//		panic(err)
+//	}
+//	defer f.Close()
+//	msg, err := gomail.ReadMessage(f)
+//	if err != nil {
+//		panic(err)
+//	}
+//	msg.SetHeader("To", "bob@example.com")
+func ReadMessage(r io.Reader) (*Message, error) {
+	m, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := NewMessage()
+	for field, value := range m.Header {
+		switch field {
+		case "Content-Type", "Content-Transfer-Encoding", "Content-Disposition", "Content-Id", "Mime-Version":
+			continue
+		}
+		msg.header[field] = value
+	}
+
+	if err := msg.readPart(textproto.MIMEHeader(m.Header), m.Body, "text/plain"); err != nil {
+		msg.Reset()
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+// readPart adds the MIME part with the given header and body to the message.
+// Multipart parts are read recursively. defaultType is used when the part has
+// no Content-Type, as defined in RFC 2046, 5.1.
+func (msg *Message) readPart(h textproto.MIMEHeader, body io.Reader, defaultType string) error {
+	contentType := h.Get("Content-Type")
+	if contentType == "" {
+		contentType = defaultType
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return err
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		childType := "text/plain"
+		if mediaType == "multipart/digest" {
+			childType = "message/rfc822"
+		}
+		r := multipart.NewReader(body, params["boundary"])
+		for {
+			p, err := r.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := msg.readPart(p.Header, p, childType); err != nil {
+				return err
+			}
+		}
+	}
+
+	enc := readEncoding(h.Get("Content-Transfer-Encoding"))
+	content, err := ioutil.ReadAll(decodeBody(body, enc))
+	if err != nil {
+		return err
+	}
+
+	disposition, dParams, _ := mime.ParseMediaType(h.Get("Content-Disposition"))
+	contentID := strings.Trim(h.Get("Content-Id"), "<>")
+	isText := strings.HasPrefix(mediaType, "text/")
+	switch {
+	case disposition == "attachment" || (!isText && disposition != "inline" && contentID == ""):
+		msg.Attach(parsedFile(mediaType, params, dParams, contentID, content, enc))
+	case !isText || contentID != "":
+		msg.Embed(parsedFile(mediaType, params, dParams, contentID, content, enc))
+	default:
+		if len(msg.parts) == 0 {
+			msg.encoding = enc
+		}
+		buf := getBuffer()
+		buf.Write(content)
+		msg.parts = append(msg.parts, part{
+			contentType: mediaType,
+			body:        buf,
+			charset:     params["charset"],
+		})
+	}
+
+	return nil
+}
+
+func parsedFile(mediaType string, params, dParams map[string]string, contentID string, content []byte, enc Encoding) *File {
+	name := dParams["filename"]
+	if name == "" {
+		name = params["name"]
+	}
+	if (enc == Unencoded || enc == Binary) && !strings.HasPrefix(mediaType, "text/") {
+		enc = Base64
+	}
+
+	return &File{
+		Name:      name,
+		MimeType:  mediaType,
+		Content:   content,
+		ContentID: contentID,
+		encoding:  enc,
+	}
+}
+
+func readEncoding(cte string) Encoding {
+	switch strings.ToLower(strings.TrimSpace(cte)) {
+	case "base64":
+		return Base64
+	case "quoted-printable":
+		return QuotedPrintable
+	case "binary":
+		return Binary
+	default:
+		return Unencoded
+	}
+}
+
+func decodeBody(r io.Reader, enc Encoding) io.Reader {
+	switch enc {
+	case Base64:
+		return base64.NewDecoder(base64.StdEncoding, r)
+	case QuotedPrintable:
+		return quotedprintable.NewReader(r)
+	default:
+		return r
+	}
+}
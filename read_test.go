@@ -0,0 +1,139 @@
+package gomail
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadMessage(t *testing.T) {
+	msg := NewMessage()
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.SetHeader("Subject", "¡Hola, señor!")
+	msg.SetBody("text/plain", "¡Hola, señor!")
+	msg.AddAlternative("text/html", "¡<b>Hola</b>, <i>señor</i>!")
+	msg.Embed(CreateFile("image.jpg", []byte("Content 1")))
+	msg.Attach(CreateFile("test.pdf", []byte("Content 2")))
+	msg.Attach(CreateFile("test.csv", []byte("a,b"), SetMimeType("text/csv")))
+
+	read, err := ReadMessage(strings.NewReader(sendToString(t, msg)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertHeader(t, read, "From", "from@example.com")
+	assertHeader(t, read, "Subject", "=?UTF-8?Q?=C2=A1Hola,_se=C3=B1or!?=")
+	if len(read.parts) != 2 {
+		t.Fatalf("Invalid number of parts, got %d, want 2", len(read.parts))
+	}
+	for i, want := range []part{
+		{contentType: "text/plain", charset: "UTF-8"},
+		{contentType: "text/html", charset: "UTF-8"},
+	} {
+		p := read.parts[i]
+		if p.contentType != want.contentType || p.charset != want.charset {
+			t.Errorf("Invalid part #%d, got %s; charset=%s, want %s; charset=%s",
+				i, p.contentType, p.charset, want.contentType, want.charset)
+		}
+		if got, want := p.body.String(), msg.parts[i].body.String(); got != want {
+			t.Errorf("Invalid body of part #%d, got %q, want %q", i, got, want)
+		}
+	}
+	if read.encoding != QuotedPrintable {
+		t.Errorf("Invalid encoding, got %q, want %q", read.encoding, QuotedPrintable)
+	}
+
+	assertFiles(t, read.embedded, msg.embedded)
+	assertFiles(t, read.attachments, msg.attachments)
+
+	if got, want := structure(t, read), structure(t, msg); got != want {
+		t.Errorf("Invalid structure, got %s, want %s", got, want)
+	}
+}
+
+func TestReadMessageCharset(t *testing.T) {
+	raw := "From: from@example.com\r\n" +
+		"Content-Type: text/plain; charset=ISO-8859-1\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		"Q2Fm6Q==\r\n"
+
+	msg, err := ReadMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.parts) != 1 {
+		t.Fatalf("Invalid number of parts, got %d, want 1", len(msg.parts))
+	}
+	if got := msg.parts[0].body.String(); got != "Caf\xe9" {
+		t.Errorf("Invalid body, got %q, want %q", got, "Caf\xe9")
+	}
+	if msg.parts[0].charset != "ISO-8859-1" {
+		t.Errorf("Invalid charset, got %q, want %q", msg.parts[0].charset, "ISO-8859-1")
+	}
+	if msg.encoding != Base64 {
+		t.Errorf("Invalid encoding, got %q, want %q", msg.encoding, Base64)
+	}
+}
+
+func TestReadMessageDefaults(t *testing.T) {
+	raw := "From: from@example.com\r\n" +
+		"Content-Type: multipart/mixed; boundary=mixed\r\n" +
+		"\r\n" +
+		"--mixed\r\n" +
+		"Content-Type: multipart/alternative; boundary=alt\r\n" +
+		"\r\n" +
+		"--alt\r\n" +
+		"\r\n" +
+		"Hello!\r\n" +
+		"--alt\r\n" +
+		"Content-Type: text/html; charset=UTF-8\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"<p>Caf=C3=A9</p>\r\n" +
+		"--alt--\r\n" +
+		"--mixed\r\n" +
+		"Content-Type: multipart/digest; boundary=digest\r\n" +
+		"\r\n" +
+		"--digest\r\n" +
+		"\r\n" +
+		"Subject: Forwarded\r\n" +
+		"\r\n" +
+		"Hi\r\n" +
+		"--digest--\r\n" +
+		"--mixed--\r\n"
+
+	msg, err := ReadMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(msg.parts) != 2 {
+		t.Fatalf("Invalid number of parts, got %d, want 2", len(msg.parts))
+	}
+	if p := msg.parts[0]; p.contentType != "text/plain" || p.body.String() != "Hello!" {
+		t.Errorf("Invalid first part, got %s: %q", p.contentType, p.body.String())
+	}
+	if p := msg.parts[1]; p.contentType != "text/html" || p.body.String() != "<p>Café</p>" {
+		t.Errorf("Invalid second part, got %s: %q", p.contentType, p.body.String())
+	}
+	if len(msg.attachments) != 1 || msg.attachments[0].MimeType != "message/rfc822" {
+		t.Fatalf("The digest should contain a message/rfc822 attachment, got %d attachments", len(msg.attachments))
+	}
+	if got := string(msg.attachments[0].Content); got != "Subject: Forwarded\r\n\r\nHi" {
+		t.Errorf("Invalid forwarded message, got %q", got)
+	}
+}
+
+func assertFiles(t *testing.T, got, want []*File) {
+	if len(got) != len(want) {
+		t.Fatalf("Invalid number of files, got %d, want %d", len(got), len(want))
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if g.Name != w.Name || g.MimeType != w.MimeType || string(g.Content) != string(w.Content) {
+			t.Errorf("Invalid file #%d, got %q (%s): %q, want %q (%s): %q",
+				i, g.Name, g.MimeType, g.Content, w.Name, w.MimeType, w.Content)
+		}
+	}
+}
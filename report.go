@@ -0,0 +1,173 @@
+package gomail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// A DeliveryReport is a delivery status notification, as defined in RFC 3464,
+// reporting the delivery status of a message to some of its recipients.
+type DeliveryReport struct {
+	// ReportingMTA is the host name of the MTA generating the report.
+	ReportingMTA string
+	// OriginalEnvelopeID is the envelope identifier given with the ENVID
+	// parameter of the MAIL command, if any.
+	OriginalEnvelopeID string
+	// ArrivalDate is the time when the message arrived at the reporting MTA.
+	// It is omitted if zero.
+	ArrivalDate time.Time
+	Recipients  []RecipientStatus
+	// Returned is the returned message. It is omitted if empty.
+	Returned []byte
+	// HeadersOnly indicates that Returned only contains the header of the
+	// message.
+	HeadersOnly bool
+}
+
+// A RecipientStatus is the delivery status of a message to one recipient.
+type RecipientStatus struct {
+	// FinalRecipient is the address of the recipient.
+	FinalRecipient string
+	// OriginalRecipient is the address given with the ORCPT parameter of the
+	// RCPT command, if any.
+	OriginalRecipient string
+	// Action is one of "failed", "delayed", "delivered", "relayed" or
+	// "expanded".
+	Action string
+	// Status is the status code defined in RFC 3463, for example "5.1.1".
+	Status string
+	// RemoteMTA is the host name of the MTA that returned DiagnosticCode.
+	RemoteMTA string
+	// DiagnosticCode is the reply of the remote MTA, for example
+	// "550 5.1.1 User unknown".
+	DiagnosticCode string
+	// LastAttemptDate is the time of the last delivery attempt. It is omitted
+	// if zero.
+	LastAttemptDate time.Time
+}
+
+var (
+	dsnActions = map[string]bool{
+		"failed":    true,
+		"delayed":   true,
+		"delivered": true,
+		"relayed":   true,
+		"expanded":  true,
+	}
+	dsnStatus = regexp.MustCompile(`^[245]\.[0-9]{1,3}\.[0-9]{1,3}$`)
+)
+
+// SetDeliveryReport makes the message a delivery status notification. The
+// message becomes a multipart/report whose first part is the content of the
+// message, which should explain the report to humans, followed by the delivery
+// status of each recipient and by the returned message, if any.
+//
+// It returns an error if the report is not valid.
+//
+// Example:
+//
+//	msg := gomail.NewMessage()
+//	msg.SetHeader("From", "mailer-daemon@example.com")
+//	msg.SetHeader("To", "alex@example.com")
+//	msg.SetHeader("Subject", "Undelivered Mail Returned to Sender")
+//	msg.SetBody("text/plain", "Your message could not be delivered.")
+//	err := msg.SetDeliveryReport(&gomail.DeliveryReport{
+//		ReportingMTA: "mx.example.com",
+//		Recipients: []gomail.RecipientStatus{{
+//			FinalRecipient: "bob@example.org",
+//			Action:         "failed",
+//			Status:         "5.1.1",
+//			DiagnosticCode: "550 5.1.1 User unknown",
+//		}},
+//		Returned: original,
+//	})
+func (msg *Message) SetDeliveryReport(r *DeliveryReport) error {
+	if r.ReportingMTA == "" {
+		return errors.New("gomail: invalid delivery report, the reporting MTA is missing")
+	}
+	if len(r.Recipients) == 0 {
+		return errors.New("gomail: invalid delivery report, there is no recipient")
+	}
+	for _, rcpt := range r.Recipients {
+		if rcpt.FinalRecipient == "" {
+			return errors.New("gomail: invalid delivery report, a final recipient is missing")
+		}
+		if !dsnActions[rcpt.Action] {
+			return fmt.Errorf("gomail: invalid delivery report, invalid action %q for %s", rcpt.Action, rcpt.FinalRecipient)
+		}
+		if !dsnStatus.MatchString(rcpt.Status) {
+			return fmt.Errorf("gomail: invalid delivery report, invalid status %q for %s", rcpt.Status, rcpt.FinalRecipient)
+		}
+	}
+	msg.report = r
+
+	return nil
+}
+
+// deliveryStatus returns the content of the message/delivery-status part of the
+// report.
+func (msg *Message) deliveryStatus() []byte {
+	r := msg.report
+	buf := new(bytes.Buffer)
+	field := func(name, value string) {
+		if value != "" {
+			buf.WriteString(name + ": " + stripNewlines(value) + "\r\n")
+		}
+	}
+	date := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return msg.FormatDate(t)
+	}
+
+	field("Reporting-MTA", "dns; "+r.ReportingMTA)
+	field("Original-Envelope-Id", r.OriginalEnvelopeID)
+	field("Arrival-Date", date(r.ArrivalDate))
+	for _, rcpt := range r.Recipients {
+		buf.WriteString("\r\n")
+		if rcpt.OriginalRecipient != "" {
+			field("Original-Recipient", "rfc822; "+rcpt.OriginalRecipient)
+		}
+		field("Final-Recipient", "rfc822; "+rcpt.FinalRecipient)
+		field("Action", rcpt.Action)
+		field("Status", rcpt.Status)
+		if rcpt.RemoteMTA != "" {
+			field("Remote-MTA", "dns; "+rcpt.RemoteMTA)
+		}
+		if rcpt.DiagnosticCode != "" {
+			field("Diagnostic-Code", "smtp; "+rcpt.DiagnosticCode)
+		}
+		field("Last-Attempt-Date", date(rcpt.LastAttemptDate))
+	}
+
+	return buf.Bytes()
+}
+
+// writeReport writes the parts of the delivery report following the content of
+// the message.
+func (msg *Message) writeReport(w *messageWriter) {
+	status := msg.deliveryStatus()
+	enc := resolveEncoding(AutoEncoding, status, false)
+	h := make(map[string][]string)
+	h["Content-Type"] = []string{"message/delivery-status"}
+	h["Content-Transfer-Encoding"] = []string{transferEncoding(enc)}
+	w.write(h, status, enc)
+
+	if len(msg.report.Returned) == 0 {
+		return
+	}
+	// RFC 2046, 5.2.1. only allows the identity encodings for messages.
+	enc = resolveEncoding(AutoEncoding, msg.report.Returned, false)
+	h = make(map[string][]string)
+	if msg.report.HeadersOnly {
+		h["Content-Type"] = []string{"text/rfc822-headers"}
+	} else {
+		h["Content-Type"] = []string{"message/rfc822"}
+	}
+	h["Content-Transfer-Encoding"] = []string{transferEncoding(enc)}
+	w.write(h, msg.report.Returned, enc)
+}
@@ -0,0 +1,89 @@
+package gomail
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeliveryReport(t *testing.T) {
+	msg := NewMessage()
+	msg.SetHeader("From", "mailer-daemon@example.com")
+	msg.SetHeader("To", "from@example.com")
+	msg.SetBody("text/plain", "Your message could not be delivered.")
+	err := msg.SetDeliveryReport(&DeliveryReport{
+		ReportingMTA:       "mx.example.com",
+		OriginalEnvelopeID: "QQ314159",
+		ArrivalDate:        stubNow(),
+		Recipients: []RecipientStatus{{
+			FinalRecipient:    "to@example.org",
+			OriginalRecipient: "alias@example.org",
+			Action:            "failed",
+			Status:            "5.1.1",
+			RemoteMTA:         "mx.example.org",
+			DiagnosticCode:    "550 5.1.1 User unknown",
+		}, {
+			FinalRecipient:  "other@example.org",
+			Action:          "delayed",
+			Status:          "4.4.1",
+			LastAttemptDate: stubNow().Add(time.Hour),
+		}},
+		Returned: []byte("From: from@example.com\r\nTo: to@example.org\r\n\r\nHello"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "multipart/report(text/plain,message/delivery-status,message/rfc822)"
+	if got := structure(t, msg); got != want {
+		t.Errorf("Invalid structure, got %s, want %s", got, want)
+	}
+
+	got := sendToString(t, msg)
+	for _, want := range []string{
+		"Content-Type: multipart/report; report-type=delivery-status; boundary=",
+		"Content-Transfer-Encoding: 7bit\r\n" +
+			"Content-Type: message/delivery-status\r\n" +
+			"\r\n" +
+			"Reporting-MTA: dns; mx.example.com\r\n" +
+			"Original-Envelope-Id: QQ314159\r\n" +
+			"Arrival-Date: Wed, 25 Jun 2014 17:46:00 +0000\r\n" +
+			"\r\n" +
+			"Original-Recipient: rfc822; alias@example.org\r\n" +
+			"Final-Recipient: rfc822; to@example.org\r\n" +
+			"Action: failed\r\n" +
+			"Status: 5.1.1\r\n" +
+			"Remote-MTA: dns; mx.example.org\r\n" +
+			"Diagnostic-Code: smtp; 550 5.1.1 User unknown\r\n" +
+			"\r\n" +
+			"Final-Recipient: rfc822; other@example.org\r\n" +
+			"Action: delayed\r\n" +
+			"Status: 4.4.1\r\n" +
+			"Last-Attempt-Date: Wed, 25 Jun 2014 18:46:00 +0000\r\n",
+		"Content-Type: message/rfc822\r\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Message does not contain %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestInvalidDeliveryReport(t *testing.T) {
+	rcpt := RecipientStatus{FinalRecipient: "to@example.org", Action: "failed", Status: "5.1.1"}
+	tests := []*DeliveryReport{
+		{Recipients: []RecipientStatus{rcpt}},
+		{ReportingMTA: "mx.example.com"},
+		{ReportingMTA: "mx.example.com", Recipients: []RecipientStatus{{Action: "failed", Status: "5.1.1"}}},
+		{ReportingMTA: "mx.example.com", Recipients: []RecipientStatus{{FinalRecipient: "to@example.org", Action: "bounced", Status: "5.1.1"}}},
+		{ReportingMTA: "mx.example.com", Recipients: []RecipientStatus{{FinalRecipient: "to@example.org", Action: "failed", Status: "550"}}},
+	}
+	for _, r := range tests {
+		msg := NewMessage()
+		if err := msg.SetDeliveryReport(r); err == nil {
+			t.Errorf("SetDeliveryReport should fail with %+v", r)
+		}
+		if msg.report != nil {
+			t.Errorf("An invalid report should not be set: %+v", r)
+		}
+	}
+}
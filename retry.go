@@ -0,0 +1,78 @@
+package gomail
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// A RetryPolicy sets how an email is sent again after a temporary failure of
+// the SMTP server, that is an *SMTPError with a 4yz reply code such as 421,
+// 450 or 451. Permanent failures, with a 5yz reply code, and the other errors
+// are returned at once.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, the first one included.
+	MaxAttempts int
+	// BaseDelay is the delay before the second attempt. It doubles after each
+	// attempt.
+	BaseDelay time.Duration
+	// MaxDelay, if not zero, is the maximum delay between two attempts.
+	// Otherwise, the delay stops doubling before it overflows.
+	MaxDelay time.Duration
+}
+
+// SetRetryPolicy allows to send the emails again after a temporary failure of
+// the SMTP server. Each attempt goes through the whole MAIL, RCPT and DATA
+// sequence, on a new connection or, with Pool and SendBatch, after resetting
+// the current one. Emails are not sent again by default.
+//
+// Example:
+//
+//	mailer := gomail.NewMailer("host", "user", "pwd", 587, gomail.SetRetryPolicy(gomail.RetryPolicy{
+//		MaxAttempts: 4,
+//		BaseDelay:   time.Second,
+//		MaxDelay:    time.Minute,
+//	}))
+func SetRetryPolicy(p RetryPolicy) MailerSetting {
+	return func(m *Mailer) {
+		m.retry = p
+	}
+}
+
+// delay returns the delay to wait after the given failed attempt, starting
+// at 1.
+func (p *RetryPolicy) delay(attempt int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < attempt; i++ {
+		if d > math.MaxInt64/2 {
+			// Doubling would overflow.
+			d = math.MaxInt64
+			break
+		}
+		d *= 2
+		if p.MaxDelay > 0 && d >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		return p.MaxDelay
+	}
+
+	return d
+}
+
+// sendWithRetry sends an email with send, again after each temporary failure
+// as allowed by the retry policy of the mailer.
+func (m *Mailer) sendWithRetry(send SendMailFunc, from string, to []string, mail []byte) error {
+	for attempt := 1; ; attempt++ {
+		err := send(m.addr, m.auth, from, to, mail)
+		var smtpErr *SMTPError
+		if err == nil || attempt >= m.retry.MaxAttempts || !errors.As(err, &smtpErr) || !smtpErr.Temporary() {
+			return err
+		}
+		sleep(m.retry.delay(attempt))
+	}
+}
+
+// Stubbed out for testing.
+var sleep = time.Sleep
@@ -0,0 +1,151 @@
+package gomail
+
+import (
+	"errors"
+	"net/smtp"
+	"net/textproto"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// failingClient is an SMTP client rejecting the MAIL command with the errors
+// in errs, one per transaction, before accepting it.
+type failingClient struct {
+	*mockClient
+	errs []error
+}
+
+func (c *failingClient) Mail(from string) error {
+	c.mockClient.Mail(from)
+	if len(c.errs) == 0 {
+		return nil
+	}
+	err := c.errs[0]
+	c.errs = c.errs[1:]
+	return err
+}
+
+func stubSleep() (delays *[]time.Duration, restore func()) {
+	delays = new([]time.Duration)
+	sleep = func(d time.Duration) {
+		*delays = append(*delays, d)
+	}
+	return delays, func() {
+		sleep = time.Sleep
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	delays, restore := stubSleep()
+	defer restore()
+
+	failed := []string{"Extension STARTTLS", "StartTLS", "Extension AUTH", "Auth", "Mail " + testFrom, "Close"}
+	tests := []struct {
+		errs   []error
+		want   []string
+		delays []time.Duration
+		err    int
+	}{
+		{
+			errs: []error{
+				&textproto.Error{Code: 451, Msg: "4.3.0 Try again later"},
+				&textproto.Error{Code: 451, Msg: "4.3.0 Try again later"},
+			},
+			want: append(append(append([]string(nil), failed...), failed...),
+				"Extension STARTTLS", "StartTLS", "Extension AUTH", "Auth", "Mail "+testFrom,
+				"Rcpt "+testTo[0], "Rcpt "+testTo[1], "Data", "Write message", "Close writer", "Quit", "Close"),
+			delays: []time.Duration{time.Second, 2 * time.Second},
+		},
+		{
+			errs:   []error{&textproto.Error{Code: 550, Msg: "5.7.1 Rejected"}},
+			want:   failed,
+			delays: nil,
+			err:    550,
+		},
+		{
+			errs: []error{
+				&textproto.Error{Code: 421, Msg: "4.3.2 Shutting down"},
+				&textproto.Error{Code: 450, Msg: "4.2.0 Busy"},
+				&textproto.Error{Code: 451, Msg: "4.3.0 Try again later"},
+				&textproto.Error{Code: 451, Msg: "4.3.0 Try again later"},
+			},
+			want:   append(append(append(append([]string(nil), failed...), failed...), failed...), failed...),
+			delays: []time.Duration{time.Second, 2 * time.Second, 3 * time.Second},
+			err:    451,
+		},
+	}
+
+	for _, test := range tests {
+		*delays = nil
+		testClient := &failingClient{
+			mockClient: &mockClient{t: t, want: test.want, addr: testAddr, auth: testAuth},
+			errs:       test.errs,
+		}
+		initSMTP = func(d NetDialer, addr string) (smtpClient, error) {
+			return testClient, nil
+		}
+
+		msg := NewMessage()
+		msg.SetHeader("From", testFrom)
+		msg.SetHeader("To", testTo...)
+		msg.SetBody("text/plain", testBody)
+
+		mailer := NewCustomMailer(testAddr, testAuth, SetRetryPolicy(RetryPolicy{
+			MaxAttempts: 4,
+			BaseDelay:   time.Second,
+			MaxDelay:    3 * time.Second,
+		}))
+		err := mailer.Send(msg)
+		var smtpErr *SMTPError
+		if test.err == 0 && err != nil {
+			t.Errorf("Send error: %v", err)
+		} else if test.err != 0 && (!errors.As(err, &smtpErr) || smtpErr.Code != test.err) {
+			t.Errorf("Invalid error, got %v, want a %d reply", err, test.err)
+		}
+		if testClient.i != len(test.want) {
+			t.Errorf("Missing commands, got %d, want %d", testClient.i, len(test.want))
+		}
+		if !reflect.DeepEqual(*delays, test.delays) {
+			t.Errorf("Invalid delays, got %v, want %v", *delays, test.delays)
+		}
+	}
+}
+
+func TestNoRetryPolicy(t *testing.T) {
+	delays, restore := stubSleep()
+	defer restore()
+
+	calls := 0
+	mailer := NewMailer("host", "username", "password", 587, SetSendMail(
+		func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+			calls++
+			return wrapSMTPError(&textproto.Error{Code: 451, Msg: "4.3.0 Try again later"})
+		}))
+	msg := NewMessage()
+	msg.SetHeader("From", testFrom)
+	msg.SetHeader("To", testTo...)
+	if err := mailer.Send(msg); err == nil {
+		t.Error("Send should fail")
+	}
+	if calls != 1 || len(*delays) != 0 {
+		t.Errorf("Emails should not be sent again by default, got %d attempts", calls)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	p := RetryPolicy{BaseDelay: time.Second}
+	prev := time.Duration(0)
+	for attempt := 1; attempt <= 100; attempt++ {
+		d := p.delay(attempt)
+		if d < prev {
+			t.Fatalf("The delay after attempt %d decreased, got %v after %v", attempt, d, prev)
+		}
+		prev = d
+	}
+
+	p.MaxDelay = time.Minute
+	if got := p.delay(100); got != time.Minute {
+		t.Errorf("Invalid delay, got %v, want %v", got, time.Minute)
+	}
+}
@@ -1,102 +1,498 @@
 package gomail
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
+	"fmt"
 	"io"
 	"net"
 	"net/smtp"
+	"net/textproto"
+	"strings"
 )
 
-func (m *Mailer) getSendMailFunc(ssl bool) SendMailFunc {
+func (m *Mailer) getSendMailFunc(ssl bool, o *sendOptions) SendMailFunc {
 	return func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
-		var c smtpClient
-		var err error
-		if ssl {
-			c, err = sslDial(addr, m.host, m.config)
-		} else {
-			c, err = starttlsDial(addr, m.config)
-		}
+		c, err := m.dial(addr, a, ssl)
 		if err != nil {
 			return err
 		}
 		defer c.Close()
 
-		if a != nil {
-			if ok, _ := c.Extension("AUTH"); ok {
-				if err = c.Auth(a); err != nil {
-					return err
-				}
-			}
+		if err = sendMail(c, from, to, msg, o); err != nil {
+			return err
 		}
 
-		if err = c.Mail(from); err != nil {
-			return err
+		return wrapSMTPError(c.Quit())
+	}
+}
+
+// dial connects and authenticates to the SMTP server.
+func (m *Mailer) dial(addr string, a smtp.Auth, ssl bool) (smtpClient, error) {
+	if m.localName != "" && !isHostname(m.localName) {
+		return nil, fmt.Errorf("gomail: invalid local name %q", m.localName)
+	}
+
+	var c smtpClient
+	var err error
+	if ssl {
+		c, err = sslDial(m.netDialer, addr, m.host, m.localName, m.config)
+	} else {
+		c, err = starttlsDial(m.netDialer, addr, m.localName, m.config, m.requireTLS)
+	}
+	if err != nil {
+		return nil, wrapSMTPError(err)
+	}
+
+	if a != nil {
+		if ok, _ := c.Extension("AUTH"); ok {
+			if err = c.Auth(newSession(a)); err != nil {
+				c.Close()
+				return nil, wrapSMTPError(err)
+			}
 		}
+	}
 
-		for _, addr := range to {
-			if err = c.Rcpt(addr); err != nil {
+	return c, nil
+}
+
+// sendMail sends an email using an already connected client. o can be nil.
+// The error replies of the server are returned as *SMTPError.
+func sendMail(c smtpClient, from string, to []string, msg []byte, o *sendOptions) (err error) {
+	defer func() {
+		err = wrapSMTPError(err)
+	}()
+	if o == nil {
+		o = new(sendOptions)
+	}
+
+	if !isASCII(from) || !allASCII(to) {
+		// Without SMTPUTF8, addresses must be converted to ASCII.
+		if ok, _ := c.Extension("SMTPUTF8"); !ok {
+			if from, err = asciiAddress(from); err != nil {
+				return err
+			}
+			if to, err = asciiAddresses(to); err != nil {
 				return err
 			}
 		}
+	}
 
-		w, err := c.Data()
-		if err != nil {
-			return err
+	binary := false
+	switch o.body {
+	case "8BITMIME":
+		if ok, _ := c.Extension("8BITMIME"); !ok {
+			return errors.New("gomail: the SMTP server does not support 8bit messages")
 		}
-		_, err = w.Write(msg)
-		if err != nil {
+	case "BINARYMIME":
+		ok, _ := c.Extension("BINARYMIME")
+		chunking, _ := c.Extension("CHUNKING")
+		if !ok || !chunking {
+			return errors.New("gomail: the SMTP server does not support binary messages")
+		}
+		binary = true
+	}
+
+	var dsn *DSNOptions
+	if o.dsn != nil {
+		if ok, _ := c.Extension("DSN"); ok {
+			dsn = o.dsn
+		}
+	}
+
+	var mailParams []string
+	if binary {
+		mailParams = append(mailParams, "BODY=BINARYMIME")
+	}
+	if dsn != nil {
+		mailParams = append(mailParams, dsn.mailParams()...)
+	}
+
+	if pc, ok := c.(pipeliningClient); ok && hasPipelining(c) {
+		rcptParams := make([][]string, len(to))
+		if dsn != nil {
+			for i, addr := range to {
+				rcptParams[i] = dsn.rcptParams(addr)
+			}
+		}
+		if err = pc.Envelope(from, mailParams, to, rcptParams); err != nil {
 			return err
 		}
-		err = w.Close()
+	} else if err = sendEnvelope(c, from, mailParams, to, dsn); err != nil {
+		return err
+	}
+
+	if binary {
+		return c.Bdat(msg)
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(msg)
+	if err != nil {
+		return err
+	}
+
+	return w.Close()
+}
+
+// sendEnvelope sends the MAIL command and then the RCPT commands one by one.
+func sendEnvelope(c smtpClient, from string, mailParams []string, to []string, dsn *DSNOptions) error {
+	var err error
+	if len(mailParams) > 0 {
+		err = c.MailParams(from, mailParams...)
+	} else {
+		err = c.Mail(from)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, addr := range to {
+		if dsn != nil {
+			err = c.RcptParams(addr, dsn.rcptParams(addr)...)
+		} else {
+			err = c.Rcpt(addr)
+		}
 		if err != nil {
 			return err
 		}
+	}
+
+	return nil
+}
+
+// pipeliningClient is implemented by the clients able to send the envelope
+// of an email at once, see client.Envelope.
+type pipeliningClient interface {
+	Envelope(from string, mailParams []string, to []string, rcptParams [][]string) error
+}
+
+func hasPipelining(c smtpClient) bool {
+	ok, _ := c.Extension("PIPELINING")
+	return ok
+}
+
+func allASCII(addrs []string) bool {
+	for _, addr := range addrs {
+		if !isASCII(addr) {
+			return false
+		}
+	}
+
+	return true
+}
 
-		return c.Quit()
+func asciiAddresses(addrs []string) ([]string, error) {
+	converted := make([]string, len(addrs))
+	for i, addr := range addrs {
+		a, err := asciiAddress(addr)
+		if err != nil {
+			return nil, err
+		}
+		converted[i] = a
 	}
+
+	return converted, nil
 }
 
-func sslDial(addr, host string, config *tls.Config) (smtpClient, error) {
-	conn, err := initTLS("tcp", addr, config)
+func sslDial(d NetDialer, addr, host, localName string, config *tls.Config) (smtpClient, error) {
+	conn, err := initTLS(d, "tcp", addr, config)
 	if err != nil {
 		return nil, err
 	}
 
-	return newClient(conn, host)
+	c, err := newClient(conn, host)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, hello(c, localName)
 }
 
-func starttlsDial(addr string, config *tls.Config) (smtpClient, error) {
-	c, err := initSMTP(addr)
+func starttlsDial(d NetDialer, addr, localName string, config *tls.Config, requireTLS bool) (smtpClient, error) {
+	c, err := initSMTP(d, addr)
 	if err != nil {
 		return c, err
 	}
+	if err := hello(c, localName); err != nil {
+		return nil, err
+	}
 
 	if ok, _ := c.Extension("STARTTLS"); ok {
-		return c, c.StartTLS(config)
+		if err := c.StartTLS(config); err != nil {
+			c.Close()
+			return nil, err
+		}
+	} else if requireTLS {
+		c.Close()
+		return nil, ErrTLSUnsupported
 	}
 
 	return c, nil
 }
 
-var initSMTP = func(addr string) (smtpClient, error) {
-	return smtp.Dial(addr)
+// hello sends the EHLO command with localName, unless it is empty and the
+// default name of net/smtp should be used.
+func hello(c smtpClient, localName string) error {
+	if localName == "" {
+		return nil
+	}
+	if err := c.Hello(localName); err != nil {
+		c.Close()
+		return err
+	}
+
+	return nil
+}
+
+// isHostname reports whether name is a syntactically valid domain name or an
+// address literal such as "[192.0.2.1]", as accepted by the EHLO command.
+func isHostname(name string) bool {
+	if strings.HasPrefix(name, "[") && strings.HasSuffix(name, "]") {
+		ip := name[1 : len(name)-1]
+		if strings.HasPrefix(ip, "IPv6:") {
+			ip = ip[len("IPv6:"):]
+			return strings.Contains(ip, ":") && net.ParseIP(ip) != nil
+		}
+		return !strings.Contains(ip, ":") && net.ParseIP(ip) != nil
+	}
+
+	if len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			c := label[i]
+			if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+var initSMTP = func(d NetDialer, addr string) (smtpClient, error) {
+	conn, err := d.DialContext(context.Background(), "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	host, _, _ := net.SplitHostPort(addr)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &client{c}, nil
 }
 
-var initTLS = func(network, addr string, config *tls.Config) (*tls.Conn, error) {
-	return tls.Dial(network, addr, config)
+var initTLS = func(d NetDialer, network, addr string, config *tls.Config) (*tls.Conn, error) {
+	conn, err := d.DialContext(context.Background(), network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return tlsConn, nil
 }
 
 var newClient = func(conn net.Conn, host string) (smtpClient, error) {
-	return smtp.NewClient(conn, host)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return nil, err
+	}
+
+	return &client{c}, nil
 }
 
 type smtpClient interface {
+	Hello(string) error
 	Extension(string) (bool, string)
 	StartTLS(*tls.Config) error
 	Auth(smtp.Auth) error
 	Mail(string) error
+	MailParams(from string, params ...string) error
 	Rcpt(string) error
+	RcptParams(to string, params ...string) error
 	Data() (io.WriteCloser, error)
+	Bdat(msg []byte) error
+	Reset() error
 	Quit() error
 	Close() error
 }
+
+// client adds to smtp.Client the commands with ESMTP parameters.
+type client struct {
+	*smtp.Client
+}
+
+// MailParams is like smtp.Client.Mail but adds params to the MAIL command.
+func (c *client) MailParams(from string, params ...string) error {
+	cmd, err := c.mailCommand(from, params)
+	if err != nil {
+		return err
+	}
+
+	return c.cmd(250, cmd)
+}
+
+func (c *client) mailCommand(from string, params []string) (string, error) {
+	if err := validateLine(from); err != nil {
+		return "", err
+	}
+	// Extension says hello to the server if it was not done yet.
+	if ok, _ := c.Extension("8BITMIME"); ok && !hasBodyParam(params) {
+		params = append([]string{"BODY=8BITMIME"}, params...)
+	}
+	if ok, _ := c.Extension("SMTPUTF8"); ok {
+		params = append(params, "SMTPUTF8")
+	}
+
+	return command("MAIL FROM:<"+from+">", params)
+}
+
+// RcptParams is like smtp.Client.Rcpt but adds params to the RCPT command.
+func (c *client) RcptParams(to string, params ...string) error {
+	cmd, err := rcptCommand(to, params)
+	if err != nil {
+		return err
+	}
+
+	return c.cmd(25, cmd)
+}
+
+func rcptCommand(to string, params []string) (string, error) {
+	if err := validateLine(to); err != nil {
+		return "", err
+	}
+
+	return command("RCPT TO:<"+to+">", params)
+}
+
+// Envelope sends the MAIL command and all the RCPT commands in a single write,
+// as allowed by the PIPELINING extension defined in RFC 2920, and then reads
+// their replies in order. The error of the first rejected command is
+// returned, so the MAIL error or the error of the first rejected recipient.
+func (c *client) Envelope(from string, mailParams []string, to []string, rcptParams [][]string) error {
+	mail, err := c.mailCommand(from, mailParams)
+	if err != nil {
+		return err
+	}
+	cmds := []string{mail}
+	for i, addr := range to {
+		rcpt, err := rcptCommand(addr, rcptParams[i])
+		if err != nil {
+			return err
+		}
+		cmds = append(cmds, rcpt)
+	}
+
+	ids := make([]uint, len(cmds))
+	for i, cmd := range cmds {
+		ids[i] = c.Text.Next()
+		c.Text.StartRequest(ids[i])
+		c.Text.W.WriteString(cmd + "\r\n")
+		c.Text.EndRequest(ids[i])
+	}
+	if err := c.Text.W.Flush(); err != nil {
+		return err
+	}
+
+	var firstErr error
+	for i, id := range ids {
+		expectCode := 25
+		if i == 0 {
+			expectCode = 250
+		}
+		c.Text.StartResponse(id)
+		_, _, err := c.Text.ReadResponse(expectCode)
+		c.Text.EndResponse(id)
+		if err == nil {
+			continue
+		}
+		if _, ok := err.(*textproto.Error); !ok {
+			// The following replies cannot be read.
+			return err
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+func hasBodyParam(params []string) bool {
+	for _, p := range params {
+		if strings.HasPrefix(strings.ToUpper(p), "BODY=") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Bdat sends msg with a single BDAT command as defined in RFC 3030.
+func (c *client) Bdat(msg []byte) error {
+	id := c.Text.Next()
+	c.Text.StartRequest(id)
+	fmt.Fprintf(c.Text.W, "BDAT %d LAST\r\n", len(msg))
+	c.Text.W.Write(msg)
+	err := c.Text.W.Flush()
+	c.Text.EndRequest(id)
+	if err != nil {
+		return err
+	}
+
+	c.Text.StartResponse(id)
+	defer c.Text.EndResponse(id)
+	_, _, err = c.Text.ReadResponse(250)
+
+	return err
+}
+
+// command returns cmd followed by its params.
+func command(cmd string, params []string) (string, error) {
+	for _, p := range params {
+		if err := validateLine(p); err != nil {
+			return "", err
+		}
+		cmd += " " + p
+	}
+
+	return cmd, nil
+}
+
+func (c *client) cmd(expectCode int, cmd string) error {
+	id, err := c.Text.Cmd("%s", cmd)
+	if err != nil {
+		return err
+	}
+	c.Text.StartResponse(id)
+	defer c.Text.EndResponse(id)
+	_, _, err = c.Text.ReadResponse(expectCode)
+
+	return err
+}
+
+func validateLine(line string) error {
+	if strings.ContainsAny(line, "\r\n") {
+		return errors.New("gomail: a line must not contain CR or LF")
+	}
+
+	return nil
+}
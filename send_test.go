@@ -1,10 +1,16 @@
 package gomail
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
+	"fmt"
 	"io"
 	"net"
 	"net/smtp"
+	"net/textproto"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -20,6 +26,13 @@ var (
 	testBody    = "Test message"
 )
 
+// The connecting functions are stubbed out by most tests so the real ones are
+// kept here.
+var (
+	realInitSMTP = initSMTP
+	realInitTLS  = initTLS
+)
+
 const wantMsg = "To: to1@example.com, to2@example.com\r\n" +
 	"From: from@example.com\r\n" +
 	"Mime-Version: 1.0\r\n" +
@@ -93,17 +106,428 @@ func TestTLSConfigSSLSendMail(t *testing.T) {
 	})
 }
 
+func TestSetSSL(t *testing.T) {
+	testSendMail(t, testAddr, nil, []string{
+		"Extension AUTH",
+		"Auth",
+		"Mail " + testFrom,
+		"Rcpt " + testTo[0],
+		"Rcpt " + testTo[1],
+		"Data",
+		"Write message",
+		"Close writer",
+		"Quit",
+		"Close",
+	}, SetSSL(true))
+
+	testSendMail(t, testSSLAddr, nil, []string{
+		"Extension STARTTLS",
+		"StartTLS",
+		"Extension AUTH",
+		"Auth",
+		"Mail " + testFrom,
+		"Rcpt " + testTo[0],
+		"Rcpt " + testTo[1],
+		"Data",
+		"Write message",
+		"Close writer",
+		"Quit",
+		"Close",
+	}, SetSSL(false))
+}
+
+func TestRequireTLS(t *testing.T) {
+	testSendMail(t, testAddr, nil, []string{
+		"Extension STARTTLS",
+		"StartTLS",
+		"Extension AUTH",
+		"Auth",
+		"Mail " + testFrom,
+		"Rcpt " + testTo[0],
+		"Rcpt " + testTo[1],
+		"Data",
+		"Write message",
+		"Close writer",
+		"Quit",
+		"Close",
+	}, SetRequireTLS(true))
+
+	// Implicit TLS does not use STARTTLS.
+	testSendMail(t, testSSLAddr, nil, []string{
+		"Extension AUTH",
+		"Auth",
+		"Mail " + testFrom,
+		"Rcpt " + testTo[0],
+		"Rcpt " + testTo[1],
+		"Data",
+		"Write message",
+		"Close writer",
+		"Quit",
+		"Close",
+	}, SetRequireTLS(true))
+
+	testClient := &mockClient{
+		t:           t,
+		want:        []string{"Extension STARTTLS", "Close"},
+		unsupported: []string{"STARTTLS"},
+	}
+	initSMTP = func(d NetDialer, addr string) (smtpClient, error) {
+		return testClient, nil
+	}
+
+	mailer := NewCustomMailer(testAddr, testAuth, SetRequireTLS(true))
+	if err := mailer.send(testAddr, testAuth, testFrom, testTo, []byte(wantMsg)); err != ErrTLSUnsupported {
+		t.Errorf("Invalid error, got %v, want %v", err, ErrTLSUnsupported)
+	}
+	if testClient.i != len(testClient.want) {
+		t.Errorf("Missing commands, got %d, want %d", testClient.i, len(testClient.want))
+	}
+}
+
+func TestLocalName(t *testing.T) {
+	testSendMail(t, testAddr, nil, []string{
+		"Hello mail.example.com",
+		"Extension STARTTLS",
+		"StartTLS",
+		"Extension AUTH",
+		"Auth",
+		"Mail " + testFrom,
+		"Rcpt " + testTo[0],
+		"Rcpt " + testTo[1],
+		"Data",
+		"Write message",
+		"Close writer",
+		"Quit",
+		"Close",
+	}, SetLocalName("mail.example.com"))
+
+	testSendMail(t, testSSLAddr, nil, []string{
+		"Hello [192.0.2.1]",
+		"Extension AUTH",
+		"Auth",
+		"Mail " + testFrom,
+		"Rcpt " + testTo[0],
+		"Rcpt " + testTo[1],
+		"Data",
+		"Write message",
+		"Close writer",
+		"Quit",
+		"Close",
+	}, SetLocalName("[192.0.2.1]"))
+
+	initSMTP = func(d NetDialer, addr string) (smtpClient, error) {
+		t.Fatal("The SMTP server should not be dialed with an invalid local name")
+		return nil, nil
+	}
+	for _, name := range []string{"localhost\r\nRCPT TO:<evil@example.com>", "-example.com", "example..com", "[2001:db8::1]"} {
+		mailer := NewCustomMailer(testAddr, testAuth, SetLocalName(name))
+		if err := mailer.send(testAddr, testAuth, testFrom, testTo, []byte(wantMsg)); err == nil {
+			t.Errorf("Local name %q should be rejected", name)
+		}
+	}
+}
+
+func TestIsHostname(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"localhost", true},
+		{"mail.example.com", true},
+		{"mail-1.example.com", true},
+		{"[192.0.2.1]", true},
+		{"[IPv6:2001:db8::1]", true},
+		{"", false},
+		{"mail.example.com.", false},
+		{"mail_1.example.com", false},
+		{"mail-.example.com", false},
+		{strings.Repeat("a", 64) + ".com", false},
+		{"[2001:db8::1]", false},
+		{"[IPv6:192.0.2.1]", false},
+		{"[example.com]", false},
+	}
+	for _, test := range tests {
+		if got := isHostname(test.name); got != test.want {
+			t.Errorf("isHostname(%q) = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestTLSConfigServerName(t *testing.T) {
+	config := &tls.Config{ServerName: "relay.internal"}
+	testSendMail(t, testAddr, config, []string{
+		"Extension STARTTLS",
+		"StartTLS",
+		"Extension AUTH",
+		"Auth",
+		"Mail " + testFrom,
+		"Rcpt " + testTo[0],
+		"Rcpt " + testTo[1],
+		"Data",
+		"Write message",
+		"Close writer",
+		"Quit",
+		"Close",
+	})
+
+	testSendMail(t, testSSLAddr, testConfig, []string{
+		"Extension AUTH",
+		"Auth",
+		"Mail " + testFrom,
+		"Rcpt " + testTo[0],
+		"Rcpt " + testTo[1],
+		"Data",
+		"Write message",
+		"Close writer",
+		"Quit",
+		"Close",
+	})
+	if testConfig.ServerName != "" {
+		t.Errorf("The TLS configuration of the user should not be modified, got ServerName %q", testConfig.ServerName)
+	}
+}
+
+type recordingDialer struct {
+	network, addr string
+}
+
+var errDial = errors.New("dial refused")
+
+func (d *recordingDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	d.network, d.addr = network, addr
+	return nil, errDial
+}
+
+func TestEnvelopeFrom(t *testing.T) {
+	bounce := "bounces+user=abc@example.com"
+	testClient := &mockClient{
+		t: t,
+		want: []string{
+			"Extension STARTTLS",
+			"StartTLS",
+			"Extension AUTH",
+			"Auth",
+			"Mail " + bounce,
+			"Rcpt " + testTo[0],
+			"Rcpt " + testTo[1],
+			"Data",
+			"Write message",
+			"Close writer",
+			"Quit",
+			"Close",
+		},
+		addr: testAddr,
+		auth: testAuth,
+	}
+	initSMTP = func(d NetDialer, addr string) (smtpClient, error) {
+		return testClient, nil
+	}
+
+	msg := NewMessage()
+	msg.SetHeader("From", testFrom)
+	msg.SetHeader("To", testTo...)
+	msg.SetBody("text/plain", testBody)
+	msg.SetEnvelopeFrom(bounce)
+
+	mailer := NewCustomMailer(testAddr, testAuth)
+	if err := mailer.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+	if testClient.i != len(testClient.want) {
+		t.Errorf("Missing commands, got %d, want %d", testClient.i, len(testClient.want))
+	}
+
+	msg.SetEnvelopeFrom("bounces@")
+	if err, ok := mailer.Send(msg).(*ValidationError); !ok || err.Field != "Return-Path" {
+		t.Errorf("Invalid error, got %v, want a *ValidationError on Return-Path", err)
+	}
+}
+
+// rcptLimitClient is an SMTP client refusing the recipients of a transaction
+// after the third one.
+type rcptLimitClient struct {
+	*mockClient
+	rcpts int
+	msg   string
+}
+
+func (c *rcptLimitClient) Data() (io.WriteCloser, error) {
+	c.do("Data")
+	return &mockWriter{c: c.mockClient, want: c.msg}, nil
+}
+
+func (c *rcptLimitClient) Mail(from string) error {
+	c.rcpts = 0
+	return c.mockClient.Mail(from)
+}
+
+func (c *rcptLimitClient) Rcpt(to string) error {
+	c.mockClient.Rcpt(to)
+	if c.rcpts++; c.rcpts > 3 {
+		return &textproto.Error{Code: 452, Msg: "4.5.3 Too many recipients"}
+	}
+	return nil
+}
+
+func TestMaxRecipients(t *testing.T) {
+	var to []string
+	for i := 1; i <= 5; i++ {
+		to = append(to, fmt.Sprintf("to%d@example.com", i))
+	}
+	transaction := func(to ...string) []string {
+		cmds := []string{"Extension STARTTLS", "StartTLS", "Extension AUTH", "Auth", "Mail " + testFrom}
+		for _, addr := range to {
+			cmds = append(cmds, "Rcpt "+addr)
+		}
+		return append(cmds, "Data", "Write message", "Close writer", "Quit", "Close")
+	}
+
+	tests := []struct {
+		max    int
+		want   []string
+		failed []string
+	}{
+		{
+			max:  3,
+			want: append(transaction(to[:3]...), transaction(to[3:]...)...),
+		},
+		{
+			max: 4,
+			want: append(
+				[]string{"Extension STARTTLS", "StartTLS", "Extension AUTH", "Auth", "Mail " + testFrom,
+					"Rcpt " + to[0], "Rcpt " + to[1], "Rcpt " + to[2], "Rcpt " + to[3], "Close"},
+				transaction(to[4])...,
+			),
+			failed: to[:4],
+		},
+	}
+
+	for _, test := range tests {
+		msg := NewMessage()
+		msg.SetHeader("From", testFrom)
+		msg.SetHeader("To", to...)
+		msg.SetBody("text/plain", testBody)
+		b, err := msg.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		testClient := &rcptLimitClient{
+			mockClient: &mockClient{t: t, want: test.want, addr: testAddr, auth: testAuth},
+			msg:        string(b),
+		}
+		initSMTP = func(d NetDialer, addr string) (smtpClient, error) {
+			return testClient, nil
+		}
+
+		mailer := NewCustomMailer(testAddr, testAuth, SetMaxRecipients(test.max))
+		err = mailer.Send(msg)
+		if test.failed == nil {
+			if err != nil {
+				t.Errorf("Send error with %d recipients per transaction: %v", test.max, err)
+			}
+		} else if errs, ok := err.(*RecipientErrors); !ok || len(errs.Errors) != len(test.failed) {
+			t.Errorf("Invalid error with %d recipients per transaction, got %v", test.max, err)
+		} else {
+			for i, e := range errs.Errors {
+				if e.Recipient != test.failed[i] {
+					t.Errorf("Invalid failed recipient, got %q, want %q", e.Recipient, test.failed[i])
+				}
+				var tpErr *textproto.Error
+				if !errors.As(e.Err, &tpErr) || tpErr.Code != 452 {
+					t.Errorf("Invalid error for %s, got %v", e.Recipient, e.Err)
+				}
+				var smtpErr *SMTPError
+				if !errors.As(e.Err, &smtpErr) || !smtpErr.Temporary() || smtpErr.Enhanced != "4.5.3" {
+					t.Errorf("The error for %s should be a temporary *SMTPError, got %#v", e.Recipient, e.Err)
+				}
+			}
+		}
+		if testClient.i != len(test.want) {
+			t.Errorf("Missing commands, got %d, want %d", testClient.i, len(test.want))
+		}
+	}
+}
+
+func TestNoEmptyTransaction(t *testing.T) {
+	var txs []string
+	mailer := NewMailer("host", "username", "password", 587, SetMaxRecipients(2), SetSendMail(
+		func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+			txs = append(txs, strings.Join(to, ","))
+			if to[0] == "bcc1@example.com" {
+				return errors.New("rejected")
+			}
+			return nil
+		}))
+
+	msg := NewMessage()
+	msg.SetHeader("From", testFrom)
+	msg.SetHeader("Bcc", "bcc1@example.com", "bcc2@example.com")
+	msg.SetBody("text/plain", testBody)
+	err := mailer.Send(msg)
+	if got, want := strings.Join(txs, ";"), "bcc1@example.com;bcc2@example.com"; got != want {
+		t.Errorf("Invalid transactions, got %q, want %q", got, want)
+	}
+	if errs, ok := err.(*RecipientErrors); !ok || len(errs.Errors) != 1 || errs.Errors[0].Recipient != "bcc1@example.com" {
+		t.Errorf("Invalid error, got %v, want the failure of bcc1@example.com", err)
+	}
+
+	txs = nil
+	msg = NewMessage(SetExcludeFrom(true))
+	msg.SetHeader("From", testFrom)
+	msg.SetHeader("To", testFrom)
+	msg.SetBody("text/plain", testBody)
+	if err := mailer.Send(msg); err != ErrNoRecipient {
+		t.Errorf("Invalid error, got %v, want %v", err, ErrNoRecipient)
+	}
+	if txs != nil {
+		t.Errorf("No email should be sent, got %q", txs)
+	}
+}
+
+func TestNetDialer(t *testing.T) {
+	initSMTP, initTLS = realInitSMTP, realInitTLS
+
+	for _, addr := range []string{testAddr, testSSLAddr} {
+		d := new(recordingDialer)
+		mailer := NewCustomMailer(addr, testAuth, SetNetDialer(d))
+		err := mailer.send(addr, testAuth, testFrom, testTo, []byte(wantMsg))
+		if err != errDial {
+			t.Errorf("Invalid error with %s, got %v, want %v", addr, err, errDial)
+		}
+		if d.network != "tcp" || d.addr != addr {
+			t.Errorf("Invalid dial, got %s %q, want tcp %q", d.network, d.addr, addr)
+		}
+	}
+
+	mailer := NewCustomMailer(testAddr, testAuth)
+	if got, want := reflect.TypeOf(mailer.netDialer), reflect.TypeOf(new(net.Dialer)); got != want {
+		t.Errorf("Invalid default dialer, got %v, want %v", got, want)
+	}
+}
+
 type mockClient struct {
-	t      *testing.T
-	i      int
-	want   []string
-	addr   string
-	auth   smtp.Auth
-	config *tls.Config
+	t           *testing.T
+	i           int
+	want        []string
+	addr        string
+	auth        smtp.Auth
+	config      *tls.Config
+	unsupported []string
+}
+
+func (c *mockClient) Hello(localName string) error {
+	c.do("Hello " + localName)
+	return nil
 }
 
 func (c *mockClient) Extension(ext string) (bool, string) {
 	c.do("Extension " + ext)
+	for _, e := range c.unsupported {
+		if e == ext {
+			return false, ""
+		}
+	}
 	return true, ""
 }
 
@@ -124,16 +548,37 @@ func (c *mockClient) Mail(from string) error {
 	return nil
 }
 
+func (c *mockClient) MailParams(from string, params ...string) error {
+	c.do("Mail " + strings.Join(append([]string{from}, params...), " "))
+	return nil
+}
+
 func (c *mockClient) Rcpt(to string) error {
 	c.do("Rcpt " + to)
 	return nil
 }
 
+func (c *mockClient) RcptParams(to string, params ...string) error {
+	c.do("Rcpt " + strings.Join(append([]string{to}, params...), " "))
+	return nil
+}
+
 func (c *mockClient) Data() (io.WriteCloser, error) {
 	c.do("Data")
 	return &mockWriter{c: c, want: wantMsg}, nil
 }
 
+func (c *mockClient) Bdat(msg []byte) error {
+	c.do("Bdat")
+	compareBodies(c.t, string(msg), wantMsg)
+	return nil
+}
+
+func (c *mockClient) Reset() error {
+	c.do("Reset")
+	return nil
+}
+
 func (c *mockClient) Quit() error {
 	c.do("Quit")
 	return nil
@@ -155,6 +600,184 @@ func (c *mockClient) do(cmd string) {
 	c.i++
 }
 
+func TestSMTPUTF8(t *testing.T) {
+	from := "用户@例子.中国"
+	to := []string{"bob@bücher.de", "to@example.com"}
+
+	testSendMailFunc(t, nil, from, to, nil, []string{
+		"Extension STARTTLS",
+		"StartTLS",
+		"Extension AUTH",
+		"Auth",
+		"Extension SMTPUTF8",
+		"Mail " + from,
+		"Rcpt " + to[0],
+		"Rcpt " + to[1],
+		"Data",
+		"Write message",
+		"Close writer",
+		"Quit",
+		"Close",
+	})
+
+	testSendMailFunc(t, []string{"SMTPUTF8"}, "from@例子.中国", to, nil, []string{
+		"Extension STARTTLS",
+		"StartTLS",
+		"Extension AUTH",
+		"Auth",
+		"Extension SMTPUTF8",
+		"Mail from@xn--fsqu00a.xn--fiqs8s",
+		"Rcpt bob@xn--bcher-kva.de",
+		"Rcpt " + to[1],
+		"Data",
+		"Write message",
+		"Close writer",
+		"Quit",
+		"Close",
+	})
+
+	testSendMailFunc(t, []string{"SMTPUTF8"}, from, to, errors.New("unsupported"), []string{
+		"Extension STARTTLS",
+		"StartTLS",
+		"Extension AUTH",
+		"Auth",
+		"Extension SMTPUTF8",
+		"Close",
+	})
+}
+
+func TestDSN(t *testing.T) {
+	dsn := WithDSN(DSNOptions{
+		Return:     "hdrs",
+		EnvelopeID: "id+42=test",
+		Notify:     []string{"SUCCESS", "FAILURE"},
+	})
+
+	testSendMailFunc(t, nil, testFrom, testTo, nil, []string{
+		"Extension STARTTLS",
+		"StartTLS",
+		"Extension AUTH",
+		"Auth",
+		"Extension DSN",
+		"Mail " + testFrom + " RET=HDRS ENVID=id+2B42+3Dtest",
+		"Rcpt " + testTo[0] + " NOTIFY=SUCCESS,FAILURE ORCPT=rfc822;" + testTo[0],
+		"Rcpt " + testTo[1] + " NOTIFY=SUCCESS,FAILURE ORCPT=rfc822;" + testTo[1],
+		"Data",
+		"Write message",
+		"Close writer",
+		"Quit",
+		"Close",
+	}, dsn)
+
+	testSendMailFunc(t, []string{"DSN"}, testFrom, testTo, nil, []string{
+		"Extension STARTTLS",
+		"StartTLS",
+		"Extension AUTH",
+		"Auth",
+		"Extension DSN",
+		"Mail " + testFrom,
+		"Rcpt " + testTo[0],
+		"Rcpt " + testTo[1],
+		"Data",
+		"Write message",
+		"Close writer",
+		"Quit",
+		"Close",
+	}, dsn)
+}
+
+func TestBodyExtension(t *testing.T) {
+	msg := NewMessage(SetEncoding(Unencoded))
+	msg.SetHeader("From", testFrom)
+	msg.SetHeader("To", testTo...)
+	msg.SetBody("text/plain", "Test")
+
+	tests := []struct {
+		update      func()
+		unsupported []string
+		want        []string
+		wantErr     bool
+	}{
+		{
+			update:      func() {},
+			unsupported: []string{"8BITMIME"},
+			want:        []string{"Mail " + testFrom},
+		},
+		{
+			update:      func() { msg.AddAlternative("text/html", "¡Hola!") },
+			unsupported: []string{"8BITMIME"},
+			want:        []string{"Extension 8BITMIME"},
+			wantErr:     true,
+		},
+		{
+			update: func() {},
+			want:   []string{"Extension 8BITMIME", "Mail " + testFrom},
+		},
+		{
+			update:      func() { msg.Attach(CreateFile("test.bin", []byte{0}, SetFileEncoding(Binary))) },
+			unsupported: []string{"CHUNKING"},
+			want:        []string{"Extension BINARYMIME", "Extension CHUNKING"},
+			wantErr:     true,
+		},
+		{
+			update: func() {},
+			want:   []string{"Extension BINARYMIME", "Extension CHUNKING", "Mail " + testFrom + " BODY=BINARYMIME"},
+		},
+	}
+
+	for i, test := range tests {
+		test.update()
+		want := test.want
+		if !test.wantErr {
+			want = append(want, "Rcpt "+testTo[0], "Rcpt "+testTo[1])
+			if strings.HasSuffix(want[len(want)-3], "BINARYMIME") {
+				want = append(want, "Bdat")
+			} else {
+				want = append(want, "Data", "Write message", "Close writer")
+			}
+		}
+		c := &mockClient{t: t, want: want, unsupported: test.unsupported}
+		err := sendMail(c, testFrom, testTo, []byte(wantMsg), msg.sendOptions(nil))
+		if (err != nil) != test.wantErr {
+			t.Errorf("#%d: invalid error: %v", i, err)
+		}
+		if c.i != len(want) {
+			t.Errorf("#%d: missing commands, got %d, want %d", i, c.i, len(want))
+		}
+	}
+}
+
+func TestXtext(t *testing.T) {
+	if got, want := xtext("a+b=c d\t"), "a+2Bb+3Dc+20d+09"; got != want {
+		t.Errorf("Invalid xtext, got %q, want %q", got, want)
+	}
+}
+
+// testSendMailFunc calls the default email-sending function of a mailer with
+// the given envelope.
+func testSendMailFunc(t *testing.T, unsupported []string, from string, to []string, wantErr error, want []string, opts ...SendOption) {
+	testClient := &mockClient{
+		t:           t,
+		want:        want,
+		addr:        testAddr,
+		auth:        testAuth,
+		unsupported: unsupported,
+	}
+	initSMTP = func(d NetDialer, addr string) (smtpClient, error) {
+		return testClient, nil
+	}
+
+	mailer := NewCustomMailer(testAddr, testAuth)
+	send := mailer.getSendMailFunc(mailer.ssl, newSendOptions(opts))
+	err := send(testAddr, testAuth, from, to, []byte(wantMsg))
+	if (err != nil) != (wantErr != nil) {
+		t.Errorf("Invalid error, got %v, want %v", err, wantErr)
+	}
+	if testClient.i != len(want) {
+		t.Errorf("Missing commands, got %d, want %d", testClient.i, len(want))
+	}
+}
+
 type mockWriter struct {
 	want string
 	c    *mockClient
@@ -171,21 +794,26 @@ func (w *mockWriter) Close() error {
 	return nil
 }
 
-func testSendMail(t *testing.T, addr string, config *tls.Config, want []string) {
+func testSendMail(t *testing.T, addr string, config *tls.Config, want []string, settings ...MailerSetting) {
+	wantConfig := config
+	if config != nil && config.ServerName == "" {
+		wantConfig = config.Clone()
+		wantConfig.ServerName = testHost
+	}
 	testClient := &mockClient{
 		t:      t,
 		want:   want,
 		addr:   addr,
 		auth:   testAuth,
-		config: config,
+		config: wantConfig,
 	}
 
-	initSMTP = func(addr string) (smtpClient, error) {
+	initSMTP = func(d NetDialer, addr string) (smtpClient, error) {
 		assertAddr(t, addr, testClient.addr)
 		return testClient, nil
 	}
 
-	initTLS = func(network, addr string, config *tls.Config) (*tls.Conn, error) {
+	initTLS = func(d NetDialer, network, addr string, config *tls.Config) (*tls.Conn, error) {
 		if network != "tcp" {
 			t.Errorf("Invalid network, got %q, want tcp", network)
 		}
@@ -209,9 +837,8 @@ func testSendMail(t *testing.T, addr string, config *tls.Config, want []string)
 	msg.SetHeader("To", testTo...)
 	msg.SetBody("text/plain", testBody)
 
-	var settings []MailerSetting
 	if config != nil {
-		settings = []MailerSetting{SetTLSConfig(config)}
+		settings = append(settings, SetTLSConfig(config))
 	}
 
 	mailer := NewCustomMailer(addr, testAuth, settings...)
@@ -243,3 +870,188 @@ func assertConfig(t *testing.T, got, want *tls.Config) {
 		t.Errorf("Invalid field InsecureSkipVerify in config, got %v, want %v", got.InsecureSkipVerify, want.InsecureSkipVerify)
 	}
 }
+
+func TestClientParams(t *testing.T) {
+	server, conn := net.Pipe()
+	done := make(chan []string)
+	go func() {
+		var cmds []string
+		tc := textproto.NewConn(server)
+		tc.PrintfLine("220 smtp.example.com ESMTP")
+		for {
+			line, err := tc.ReadLine()
+			if err != nil {
+				break
+			}
+			cmds = append(cmds, line)
+			var size int
+			if _, err := fmt.Sscanf(line, "BDAT %d LAST", &size); err == nil {
+				data := make([]byte, size)
+				io.ReadFull(tc.R, data)
+				cmds = append(cmds, string(data))
+			}
+			switch {
+			case strings.HasPrefix(line, "EHLO"):
+				tc.PrintfLine("250-smtp.example.com\r\n250-8BITMIME\r\n250 DSN")
+			case line == "QUIT":
+				tc.PrintfLine("221 Bye")
+				server.Close()
+			default:
+				tc.PrintfLine("250 OK")
+			}
+		}
+		done <- cmds
+	}()
+
+	sc, err := smtp.NewClient(conn, testHost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &client{sc}
+	if err := c.MailParams(testFrom, "RET=HDRS"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RcptParams(testTo[0], "NOTIFY=NEVER"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RcptParams(testTo[0], "NOTIFY=NEVER\r\nRSET"); err == nil {
+		t.Error("RcptParams should reject parameters containing CRLF")
+	}
+	if err := c.MailParams(testFrom, "BODY=BINARYMIME"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Bdat([]byte("Test\x00\r\nmessage")); err != nil {
+		t.Fatal(err)
+	}
+	c.Quit()
+
+	want := []string{
+		"EHLO localhost",
+		"MAIL FROM:<" + testFrom + "> BODY=8BITMIME RET=HDRS",
+		"RCPT TO:<" + testTo[0] + "> NOTIFY=NEVER",
+		"MAIL FROM:<" + testFrom + "> BODY=BINARYMIME",
+		"BDAT 14 LAST",
+		"Test\x00\r\nmessage",
+		"QUIT",
+	}
+	got := <-done
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Invalid commands, got %q, want %q", got, want)
+	}
+}
+
+// pipeliningServer runs an SMTP server advertising extensions on conn. It
+// rejects the recipients starting with "bad" and returns the commands it
+// received, those sent along with the following ones being marked with "+".
+func pipeliningServer(conn net.Conn, extensions string, done chan<- []string) {
+	var cmds []string
+	data := false
+	tc := textproto.NewConn(conn)
+	tc.PrintfLine("220 smtp.example.com ESMTP")
+	for {
+		line, err := tc.ReadLine()
+		if err != nil {
+			break
+		}
+		if tc.R.Buffered() > 0 {
+			cmds = append(cmds, "+"+line)
+		} else {
+			cmds = append(cmds, line)
+		}
+		switch {
+		case data:
+			if line == "." {
+				data = false
+				tc.PrintfLine("250 OK")
+			}
+		case strings.HasPrefix(line, "EHLO"):
+			tc.PrintfLine("250-smtp.example.com\r\n250 %s", extensions)
+		case strings.HasPrefix(line, "RCPT TO:<bad1"):
+			tc.PrintfLine("550 5.1.1 No such user")
+		case strings.HasPrefix(line, "RCPT TO:<bad"):
+			tc.PrintfLine("553 5.1.3 Invalid address")
+		case line == "DATA":
+			data = true
+			tc.PrintfLine("354 Go ahead")
+		case line == "QUIT":
+			tc.PrintfLine("221 Bye")
+			conn.Close()
+		default:
+			tc.PrintfLine("250 OK")
+		}
+	}
+	done <- cmds
+}
+
+func TestPipelining(t *testing.T) {
+	tests := []struct {
+		extensions string
+		want       []string
+	}{
+		{
+			extensions: "PIPELINING",
+			want: []string{
+				"EHLO localhost",
+				"+MAIL FROM:<from@example.com>",
+				"+RCPT TO:<to1@example.com>",
+				"RCPT TO:<to2@example.com>",
+				"DATA",
+				"+Test",
+				".",
+				"RSET",
+				"+MAIL FROM:<from@example.com>",
+				"+RCPT TO:<to1@example.com>",
+				"+RCPT TO:<bad1@example.com>",
+				"RCPT TO:<bad2@example.com>",
+				"QUIT",
+			},
+		},
+		{
+			extensions: "8BITMIME",
+			want: []string{
+				"EHLO localhost",
+				"MAIL FROM:<from@example.com> BODY=8BITMIME",
+				"RCPT TO:<to1@example.com>",
+				"RCPT TO:<to2@example.com>",
+				"DATA",
+				"+Test",
+				".",
+				"RSET",
+				"MAIL FROM:<from@example.com> BODY=8BITMIME",
+				"RCPT TO:<to1@example.com>",
+				"RCPT TO:<bad1@example.com>",
+				"QUIT",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		server, conn := net.Pipe()
+		done := make(chan []string)
+		go pipeliningServer(server, test.extensions, done)
+
+		sc, err := smtp.NewClient(conn, testHost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := &client{sc}
+		if err := sendMail(c, "from@example.com", []string{"to1@example.com", "to2@example.com"}, []byte("Test\r\n"), nil); err != nil {
+			t.Errorf("Send error with %s: %v", test.extensions, err)
+		}
+		if err := c.Reset(); err != nil {
+			t.Fatal(err)
+		}
+		err = sendMail(c, "from@example.com", []string{"to1@example.com", "bad1@example.com", "bad2@example.com"}, []byte("Test\r\n"), nil)
+		var smtpErr *SMTPError
+		if !errors.As(err, &smtpErr) || smtpErr.Code != 550 {
+			t.Errorf("The error of the first rejected recipient should be returned with %s, got %v", test.extensions, err)
+		}
+		if err := c.Quit(); err != nil {
+			t.Errorf("The replies should stay in sync with %s, got %v", test.extensions, err)
+		}
+
+		if got := <-done; strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+			t.Errorf("Invalid commands with %s,\ngot  %q\nwant %q", test.extensions, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package gomail
+
+import "io"
+
+// A Sender sends emails to any backend, such as an SMTP server, an HTTP API or
+// a queue.
+type Sender interface {
+	// Send sends msg from the envelope sender from to the recipients to. msg
+	// writes the whole email, header included, as Message.WriteTo does.
+	Send(from string, to []string, msg io.WriterTo) error
+}
+
+// The SendFunc type is an adapter to allow the use of ordinary functions as
+// senders, like http.HandlerFunc.
+//
+// Example:
+//
+//	s := gomail.SendFunc(func(from string, to []string, msg io.WriterTo) error {
+//		// Send the email through an HTTP API
+//	})
+//	err := gomail.Send(s, msg)
+type SendFunc func(from string, to []string, msg io.WriterTo) error
+
+// Send calls f(from, to, msg).
+func (f SendFunc) Send(from string, to []string, msg io.WriterTo) error {
+	return f(from, to, msg)
+}
+
+// Send sends the messages with s. Each message is sent from its envelope sender
+// to all its recipients, Bcc included, and is written without its Bcc header
+// field. If a message fails, the others are still sent and a *BatchError
+// reporting every failed message is returned.
+//
+// Example:
+//
+//	if err := gomail.Send(sender, msgs...); err != nil {
+//		log.Print(err)
+//	}
+func Send(s Sender, msgs ...*Message) error {
+	var errs []*MessageError
+	for i, msg := range msgs {
+		if err := send(s, msg); err != nil {
+			errs = append(errs, &MessageError{Index: i, Err: err})
+		}
+	}
+	if len(errs) > 0 {
+		return &BatchError{Errors: errs}
+	}
+
+	return nil
+}
+
+func send(s Sender, msg *Message) error {
+	from, err := msg.GetEnvelopeFrom()
+	if err != nil {
+		return err
+	}
+	to, err := msg.GetRecipients()
+	if err != nil {
+		return err
+	}
+
+	return s.Send(from, to, msg)
+}
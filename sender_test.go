@@ -0,0 +1,59 @@
+package gomail
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestSendFunc(t *testing.T) {
+	msg1 := NewMessage()
+	msg1.SetHeader("From", "from@example.com")
+	msg1.SetHeader("To", "to@example.com")
+	msg1.SetHeader("Bcc", "bcc@example.com")
+	msg1.SetBody("text/plain", "Hello")
+
+	msg2 := NewMessage()
+	msg2.SetHeader("From", "from@example.com")
+	msg2.SetHeader("To", "fail@example.com")
+	msg2.SetBody("text/plain", "Hello")
+
+	msg3 := NewMessage()
+	msg3.SetHeader("From", "from@example.com")
+	msg3.SetHeader("To", "to@example.com")
+	msg3.SetEnvelopeFrom("bounces@example.com")
+	msg3.SetBody("text/plain", "Hello")
+
+	errSend := errors.New("send error")
+	var sent []string
+	s := SendFunc(func(from string, to []string, msg io.WriterTo) error {
+		if to[0] == "fail@example.com" {
+			return errSend
+		}
+		buf := new(bytes.Buffer)
+		if _, err := msg.WriteTo(buf); err != nil {
+			return err
+		}
+		if strings.Contains(buf.String(), "Bcc") {
+			t.Errorf("The Bcc header field should not be written:\n%s", buf)
+		}
+		sent = append(sent, from+" -> "+strings.Join(to, ","))
+		return nil
+	})
+
+	err := Send(s, msg1, msg2, msg3)
+	batchErr, ok := err.(*BatchError)
+	if !ok || len(batchErr.Errors) != 1 || batchErr.Errors[0].Index != 1 || batchErr.Errors[0].Err != errSend {
+		t.Errorf("Invalid error, got %v", err)
+	}
+
+	want := []string{
+		"from@example.com -> to@example.com,bcc@example.com",
+		"bounces@example.com -> to@example.com",
+	}
+	if strings.Join(sent, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Invalid emails sent, got %q, want %q", sent, want)
+	}
+}
@@ -0,0 +1,31 @@
+package gomail
+
+// SESRawInput returns the fields of the input of the SendRawEmail operation of
+// Amazon SES: the envelope sender of the message as source, all its
+// recipients, Bcc included, as destinations, and the message as written by
+// WriteTo, so without its Bcc header field, as raw data.
+//
+// Example:
+//
+//	source, destinations, data, err := gomail.SESRawInput(msg)
+//	if err != nil {
+//		return err
+//	}
+//	_, err = client.SendRawEmail(&ses.SendRawEmailInput{
+//		Source:       aws.String(source),
+//		Destinations: aws.StringSlice(destinations),
+//		RawMessage:   &ses.RawMessage{Data: data},
+//	})
+func SESRawInput(msg *Message) (source string, destinations []string, rawData []byte, err error) {
+	if source, err = msg.GetEnvelopeFrom(); err != nil {
+		return "", nil, nil, err
+	}
+	if destinations, err = msg.GetRecipients(); err != nil {
+		return "", nil, nil, err
+	}
+	if rawData, err = msg.Bytes(); err != nil {
+		return "", nil, nil, err
+	}
+
+	return source, destinations, rawData, nil
+}
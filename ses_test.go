@@ -0,0 +1,38 @@
+package gomail
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSESRawInput(t *testing.T) {
+	msg := NewMessage()
+	msg.SetHeader("From", "Alex <from@example.com>")
+	msg.SetHeader("To", "to@example.com")
+	msg.SetHeader("Cc", "cc@example.com")
+	msg.SetHeader("Bcc", "bcc@example.com")
+	msg.SetBody("text/plain", "Hello")
+
+	source, destinations, data, err := SESRawInput(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source != "from@example.com" {
+		t.Errorf("Invalid source, got %q, want %q", source, "from@example.com")
+	}
+	want := []string{"to@example.com", "cc@example.com", "bcc@example.com"}
+	if strings.Join(destinations, ",") != strings.Join(want, ",") {
+		t.Errorf("Invalid destinations, got %q, want %q", destinations, want)
+	}
+	if got := string(data); strings.Contains(got, "Bcc") || strings.Contains(got, "bcc@example.com") {
+		t.Errorf("The Bcc header field should not be in the raw data:\n%s", got)
+	}
+	if got := string(data); !strings.Contains(got, "Cc: cc@example.com\r\n") || !strings.HasSuffix(got, "\r\n\r\nHello") {
+		t.Errorf("Invalid raw data:\n%s", got)
+	}
+
+	msg.SetHeader("Bcc", "bcc@")
+	if _, _, _, err := SESRawInput(msg); err == nil {
+		t.Error("An invalid Bcc address should be rejected")
+	}
+}
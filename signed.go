@@ -0,0 +1,123 @@
+package gomail
+
+import (
+	"bytes"
+	"sort"
+	"time"
+)
+
+// A Signature is a detached signature of the content of a message.
+type Signature struct {
+	// Protocol is the MIME type of the signature, for example
+	// "application/pkcs7-signature" or "application/pgp-signature".
+	Protocol string
+	// Micalg is the message integrity check algorithm used to compute the
+	// signature, for example "sha-256" or "pgp-sha256".
+	Micalg string
+	// Content is the signature itself. PGP signatures are expected to be
+	// ASCII-armored, other signatures are written in base64.
+	Content []byte
+}
+
+// A SignatureProvider signs the content of a message.
+type SignatureProvider interface {
+	// Sign returns a detached signature of content. content holds the exact
+	// bytes, header included, of the signed part as they will be sent.
+	Sign(content []byte) (*Signature, error)
+}
+
+// A timedSigner is a SignatureProvider whose signature includes a signing
+// time, which is then taken from the clock of the message.
+type timedSigner interface {
+	signAt(content []byte, signingTime time.Time) (*Signature, error)
+}
+
+// SetSignature signs the message with the given provider. The message is then
+// sent as a multipart/signed message as defined in RFC 1847.
+//
+// Example:
+//
+//	msg.SetBody("text/plain", "Hello!")
+//	msg.SetSignature(mySigner)
+func (msg *Message) SetSignature(sp SignatureProvider) {
+	msg.signer = sp
+}
+
+const pgpSignature = "application/pgp-signature"
+
+// writeSigned renders the content of msg, signs it and writes both the content
+// and the signature inside a multipart/signed part. The content is rendered
+// once and written verbatim so that the signed bytes and the sent bytes are
+// identical.
+func (w *messageWriter) writeSigned(msg *Message, sp SignatureProvider) error {
+	content := w.newInnerWriter()
+	defer putMessageWriter(content)
+	// The multipart/signed part comes before the parts of the content.
+	first := len(w.used)
+	if first+1 < len(w.boundaries) {
+		content.boundaries = w.boundaries[first+1:]
+	}
+	msg.writeContent(content)
+
+	signed := getBuffer()
+	defer putBuffer(signed)
+	writeHeaderBlock(signed, content.header)
+	signed.Write(content.buf.Bytes())
+
+	var sig *Signature
+	var err error
+	if ts, ok := sp.(timedSigner); ok {
+		sig, err = ts.signAt(signed.Bytes(), msg.currentTime())
+	} else {
+		sig, err = sp.Sign(signed.Bytes())
+	}
+	if err != nil {
+		return err
+	}
+
+	w.openMultipart("signed; protocol=\"" + sig.Protocol + "\"; micalg=" + sig.Micalg)
+	w.used = append(w.used, content.used...)
+	p, err := w.writers[w.depth-1].CreateRawPart()
+	if err != nil {
+		return err
+	}
+	if _, err := p.Write(signed.Bytes()); err != nil {
+		return err
+	}
+
+	h := make(map[string][]string)
+	if sig.Protocol == pgpSignature {
+		h["Content-Type"] = []string{sig.Protocol + "; name=\"signature.asc\""}
+		h["Content-Transfer-Encoding"] = []string{string(SevenBit)}
+		w.write(h, sig.Content, SevenBit)
+	} else {
+		h["Content-Type"] = []string{sig.Protocol + "; name=\"smime.p7s\""}
+		h["Content-Disposition"] = []string{"attachment; filename=\"smime.p7s\""}
+		h["Content-Transfer-Encoding"] = []string{string(Base64)}
+		w.write(h, sig.Content, Base64)
+	}
+	w.closeMultipart()
+
+	return nil
+}
+
+// writeHeaderBlock writes the header fields sorted by name followed by the
+// blank line separating them from the body, the same way multipart parts are
+// written.
+func writeHeaderBlock(buf *bytes.Buffer, h map[string][]string) {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		for _, v := range h[k] {
+			buf.WriteString(k)
+			buf.WriteString(": ")
+			buf.WriteString(v)
+			buf.WriteString("\r\n")
+		}
+	}
+	buf.WriteString("\r\n")
+}
@@ -0,0 +1,171 @@
+package gomail
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/smtp"
+	"strings"
+	"testing"
+)
+
+type stubSigner struct {
+	sig     *Signature
+	err     error
+	content []byte
+}
+
+func (s *stubSigner) Sign(content []byte) (*Signature, error) {
+	s.content = append([]byte(nil), content...)
+	return s.sig, s.err
+}
+
+func TestPGPSignature(t *testing.T) {
+	signer := &stubSigner{sig: &Signature{
+		Protocol: "application/pgp-signature",
+		Micalg:   "pgp-sha256",
+		Content:  []byte("-----BEGIN PGP SIGNATURE-----"),
+	}}
+
+	msg := NewMessage()
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.SetBody("text/plain", "¡Hola, señor!")
+	msg.SetSignature(signer)
+
+	signed := "Content-Transfer-Encoding: quoted-printable\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"Mime-Version: 1.0\r\n" +
+		"\r\n" +
+		"=C2=A1Hola, se=C3=B1or!"
+
+	want := message{
+		from: "from@example.com",
+		to:   []string{"to@example.com"},
+		content: "From: from@example.com\r\n" +
+			"To: to@example.com\r\n" +
+			"Content-Type: multipart/signed; protocol=\"application/pgp-signature\"; micalg=pgp-sha256; boundary=_BOUNDARY_1_\r\n" +
+			"\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			signed + "\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			"Content-Type: application/pgp-signature; name=\"signature.asc\"\r\n" +
+			"Content-Transfer-Encoding: 7bit\r\n" +
+			"\r\n" +
+			"-----BEGIN PGP SIGNATURE-----\r\n" +
+			"--_BOUNDARY_1_--\r\n",
+	}
+
+	testMessage(t, msg, 1, want)
+
+	if string(signer.content) != signed {
+		t.Errorf("Invalid signed content, got:\n%s\nwant:\n%s", signer.content, signed)
+	}
+}
+
+func TestSMIMESignatureVerbatim(t *testing.T) {
+	signer := &stubSigner{sig: &Signature{
+		Protocol: "application/pkcs7-signature",
+		Micalg:   "sha-256",
+		Content:  []byte("signature"),
+	}}
+
+	msg := NewMessage()
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.SetBody("text/plain", "Test")
+	msg.AddAlternative("text/html", "<p>Test</p>")
+	msg.Attach(CreateFile("test.pdf", []byte("Content")))
+	msg.SetSignature(signer)
+
+	var got string
+	sendMail := func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		got = string(msg)
+		return nil
+	}
+	mailer := NewMailer("host", "username", "password", 587, SetSendMail(sendMail))
+	if err := mailer.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(got, "\r\n\r\n--"+getBoundaries(t, 1, got)[0]+"\r\n"+string(signer.content)+"\r\n--") {
+		t.Errorf("Signed content not found verbatim in message:\n%s", got)
+	}
+	if !strings.Contains(got, "Content-Type: application/pkcs7-signature; name=\"smime.p7s\"\r\n") {
+		t.Errorf("Signature part not found in message:\n%s", got)
+	}
+	if !strings.Contains(got, base64.StdEncoding.EncodeToString([]byte("signature"))) {
+		t.Errorf("Signature not found in message:\n%s", got)
+	}
+}
+
+func TestSignatureError(t *testing.T) {
+	wantErr := errors.New("gomail: test error")
+	msg := NewMessage()
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.SetBody("text/plain", "Test")
+	msg.SetSignature(&stubSigner{err: wantErr})
+
+	if m := msg.Export(); m != nil {
+		t.Error("Export should return nil when signing fails")
+	}
+
+	mailer := NewMailer("host", "username", "password", 587, SetSendMail(stubSendMail(t, 0)))
+	if err := mailer.Send(msg); err != wantErr {
+		t.Errorf("Invalid error, got %v, want %v", err, wantErr)
+	}
+}
+
+func TestSignedContentSettings(t *testing.T) {
+	signer := &stubSigner{sig: &Signature{Protocol: pgpSignature, Micalg: "pgp-sha256"}}
+	msg := NewMessage(SetCRLFNormalization(true))
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.SetBody("text/plain", "Hello")
+	msg.Attach(CreateFile("test.txt", []byte("l1\nl2\n")))
+	msg.Attach(CreateFile("raw.txt", []byte("r1\nr2\n"), SetFileCRLFNormalization(false)))
+	msg.SetSignature(signer)
+	sendToString(t, msg)
+
+	signed := string(signer.content)
+	if want := base64.StdEncoding.EncodeToString([]byte("l1\r\nl2\r\n")); !strings.Contains(signed, want) {
+		t.Errorf("The line breaks of the text file should be normalized:\n%s", signed)
+	}
+	if want := base64.StdEncoding.EncodeToString([]byte("r1\nr2\n")); !strings.Contains(signed, want) {
+		t.Errorf("The line breaks of the file opted out should be kept:\n%s", signed)
+	}
+}
+
+func TestSignedUnwrappedBase64(t *testing.T) {
+	signer := &stubSigner{sig: &Signature{Protocol: pgpSignature, Micalg: "pgp-sha256"}}
+	content := []byte(strings.Repeat("0123456789", 20))
+	msg := NewMessage(SetUnwrappedBase64(true))
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.SetBody("text/plain", "Hello")
+	msg.Attach(CreateFile("test.bin", content))
+	msg.SetSignature(signer)
+	sendToString(t, msg)
+
+	if want := base64.StdEncoding.EncodeToString(content); !strings.Contains(string(signer.content), "\r\n"+want+"\r\n") {
+		t.Errorf("The attachment should be written on a single line:\n%s", signer.content)
+	}
+}
+
+func TestSignedPartContentLength(t *testing.T) {
+	signer := &stubSigner{sig: &Signature{Protocol: pgpSignature, Micalg: "pgp-sha256"}}
+	msg := NewMessage(SetPartContentLength(true))
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.SetBody("text/plain", "Hello")
+	msg.Attach(CreateFile("test.txt", []byte("Content")))
+	msg.SetSignature(signer)
+	sendToString(t, msg)
+
+	signed := string(signer.content)
+	for _, want := range []string{"Content-Length: 5\r\n", "Content-Length: 12\r\n"} {
+		if !strings.Contains(signed, want) {
+			t.Errorf("The signed parts should have a %q header field:\n%s", want, signed)
+		}
+	}
+}
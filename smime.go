@@ -0,0 +1,198 @@
+package gomail
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"sort"
+	"time"
+)
+
+var (
+	oidData            = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidContentType     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSigningTime     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidSHA256          = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidRSAEncryption   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+)
+
+// The ASN.1 structures of a CMS signed-data content as defined in RFC 5652.
+type (
+	contentInfo struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue
+	}
+
+	signedData struct {
+		Version          int
+		DigestAlgorithms []algorithmIdentifier `asn1:"set"`
+		EncapContentInfo encapContentInfo
+		Certificates     asn1.RawValue
+		SignerInfos      []signerInfo `asn1:"set"`
+	}
+
+	encapContentInfo struct {
+		EContentType asn1.ObjectIdentifier
+	}
+
+	algorithmIdentifier struct {
+		Algorithm  asn1.ObjectIdentifier
+		Parameters asn1.RawValue `asn1:"optional"`
+	}
+
+	signerInfo struct {
+		Version            int
+		SID                issuerAndSerialNumber
+		DigestAlgorithm    algorithmIdentifier
+		SignedAttrs        asn1.RawValue
+		SignatureAlgorithm algorithmIdentifier
+		Signature          []byte
+	}
+
+	issuerAndSerialNumber struct {
+		Issuer       asn1.RawValue
+		SerialNumber *big.Int
+	}
+
+	attribute struct {
+		Type   asn1.ObjectIdentifier
+		Values asn1.RawValue
+	}
+)
+
+// smimeSigner is a SignatureProvider creating detached S/MIME signatures.
+type smimeSigner struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+// SignSMIME signs the message with S/MIME, as defined in RFC 8551, using the
+// given certificate and its private key. The signature is computed with
+// SHA-256 and includes the certificate. RSA and ECDSA keys are supported.
+//
+// Example:
+//
+//	cert, err := tls.LoadX509KeyPair("cert.pem", "key.pem")
+//	if err != nil {
+//		panic(err)
+//	}
+//	msg.SignSMIME(cert.Leaf, cert.PrivateKey.(crypto.Signer))
+func (msg *Message) SignSMIME(cert *x509.Certificate, key crypto.Signer) {
+	msg.SetSignature(&smimeSigner{cert: cert, key: key})
+}
+
+// Sign implements SignatureProvider.
+func (s *smimeSigner) Sign(content []byte) (*Signature, error) {
+	return s.signAt(content, now())
+}
+
+// signAt signs content with the given signing time.
+func (s *smimeSigner) signAt(content []byte, signingTime time.Time) (*Signature, error) {
+	var sigAlg algorithmIdentifier
+	switch s.key.Public().(type) {
+	case *rsa.PublicKey:
+		sigAlg = algorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue}
+	case *ecdsa.PublicKey:
+		sigAlg = algorithmIdentifier{Algorithm: oidECDSAWithSHA256}
+	default:
+		return nil, errors.New("gomail: S/MIME signing only supports RSA and ECDSA keys")
+	}
+
+	digest := sha256.Sum256(content)
+	attrs, err := signedAttributes(digest[:], signingTime)
+	if err != nil {
+		return nil, err
+	}
+
+	// The signature is computed over the DER encoding of the attributes as a
+	// SET OF, RFC 5652, 5.4.
+	set, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: attrs})
+	if err != nil {
+		return nil, err
+	}
+	setDigest := sha256.Sum256(set)
+	sig, err := s.key.Sign(rand.Reader, setDigest[:], crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	digestAlg := algorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}
+	sd, err := asn1.Marshal(signedData{
+		Version:          1,
+		DigestAlgorithms: []algorithmIdentifier{digestAlg},
+		EncapContentInfo: encapContentInfo{EContentType: oidData},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: s.cert.Raw},
+		SignerInfos: []signerInfo{{
+			Version: 1,
+			SID: issuerAndSerialNumber{
+				Issuer:       asn1.RawValue{FullBytes: s.cert.RawIssuer},
+				SerialNumber: s.cert.SerialNumber,
+			},
+			DigestAlgorithm:    digestAlg,
+			SignedAttrs:        asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: attrs},
+			SignatureAlgorithm: sigAlg,
+			Signature:          sig,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	p7s, err := asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sd},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Signature{
+		Protocol: "application/pkcs7-signature",
+		Micalg:   "sha-256",
+		Content:  p7s,
+	}, nil
+}
+
+// signedAttributes returns the DER encoding of the content-type, message-digest
+// and signing-time attributes sorted as required for a SET OF.
+func signedAttributes(digest []byte, signingTime time.Time) ([]byte, error) {
+	values := []struct {
+		oid   asn1.ObjectIdentifier
+		value interface{}
+	}{
+		{oidContentType, oidData},
+		{oidMessageDigest, digest},
+		{oidSigningTime, signingTime.UTC()},
+	}
+
+	encoded := make([][]byte, len(values))
+	for i, v := range values {
+		value, err := asn1.Marshal(v.value)
+		if err != nil {
+			return nil, err
+		}
+		attr, err := asn1.Marshal(attribute{
+			Type:   v.oid,
+			Values: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: value},
+		})
+		if err != nil {
+			return nil, err
+		}
+		encoded[i] = attr
+	}
+	sort.Slice(encoded, func(i, j int) bool {
+		return bytes.Compare(encoded[i], encoded[j]) < 0
+	})
+
+	return bytes.Join(encoded, nil), nil
+}
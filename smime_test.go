@@ -0,0 +1,135 @@
+package gomail
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"math/big"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testCertificate(t *testing.T, key crypto.Signer) *x509.Certificate {
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "from@example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert
+}
+
+func TestSignSMIME(t *testing.T) {
+	openssl, err := exec.LookPath("openssl")
+	if err != nil {
+		t.Skip("openssl is not installed")
+	}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []crypto.Signer{rsaKey, ecKey} {
+		msg := NewMessage()
+		msg.SetHeader("From", "from@example.com")
+		msg.SetHeader("To", "to@example.com")
+		msg.SetBody("text/plain", "¡Hola, señor!")
+		msg.AddAlternative("text/html", "<p>¡Hola, señor!</p>")
+		msg.SignSMIME(testCertificate(t, key), key)
+
+		name := filepath.Join(t.TempDir(), "signed.eml")
+		if err := msg.WriteToFile(name); err != nil {
+			t.Fatal(err)
+		}
+		out, err := exec.Command(openssl, "smime", "-verify", "-noverify", "-in", name).CombinedOutput()
+		if err != nil {
+			t.Fatalf("Verification failed with a %T: %v\n%s", key, err, out)
+		}
+		if !strings.Contains(string(out), "Verification successful") {
+			t.Errorf("Verification did not succeed with a %T:\n%s", key, out)
+		}
+	}
+}
+
+func TestSignSMIMESigningTime(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingTime := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	msg := NewMessage(SetNow(func() time.Time { return signingTime }))
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.SetBody("text/plain", "Hello")
+	msg.SignSMIME(testCertificate(t, key), key)
+
+	out := sendToString(t, msg)
+	i := strings.Index(out, "filename=\"smime.p7s\"")
+	if i < 0 {
+		t.Fatalf("No S/MIME signature:\n%s", out)
+	}
+	encoded := out[i:]
+	encoded = encoded[strings.Index(encoded, "\r\n\r\n")+4:]
+	encoded = encoded[:strings.Index(encoded, "\r\n--")]
+	sig, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(encoded, "\r\n", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := asn1.Marshal(signingTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(sig, want) {
+		t.Errorf("The signing time should be the time of the message, %s", signingTime)
+	}
+}
+
+func TestSignSMIMEUnsupportedKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := &smimeSigner{cert: testCertificate(t, key), key: unsupportedSigner{key}}
+	if _, err := signer.Sign([]byte("Test")); err == nil {
+		t.Error("Sign should fail with an unsupported key")
+	}
+}
+
+// unsupportedSigner hides the type of the public key of the signer.
+type unsupportedSigner struct {
+	crypto.Signer
+}
+
+func (s unsupportedSigner) Public() crypto.PublicKey {
+	return struct{}{}
+}
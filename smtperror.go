@@ -0,0 +1,92 @@
+package gomail
+
+import (
+	"errors"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// An SMTPError is an error reply of the SMTP server. It is returned by
+// Mailer.Send, Mailer.SendBatch and Pool.Send, possibly wrapped in a
+// RecipientErrors or a BatchError, so errors.As can be used to know whether
+// the email can be sent again later.
+//
+// Example:
+//
+//	var smtpErr *gomail.SMTPError
+//	if errors.As(err, &smtpErr) && smtpErr.Temporary() {
+//		// Try again later
+//	}
+type SMTPError struct {
+	// Code is the reply code, such as 550.
+	Code int
+	// Enhanced is the enhanced status code of RFC 3463, such as "5.1.1", or
+	// an empty string if the server did not send one.
+	Enhanced string
+	// Message is the text of the reply, without the enhanced status code.
+	// The lines of a multiline reply are separated by "\n".
+	Message string
+
+	err *textproto.Error
+}
+
+func (e *SMTPError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the underlying *textproto.Error.
+func (e *SMTPError) Unwrap() error {
+	return e.err
+}
+
+// Temporary reports whether the failure is transient, with a 4yz reply code,
+// so that sending the email again later may succeed. A permanent failure, with
+// a 5yz reply code, should not be retried as is.
+func (e *SMTPError) Temporary() bool {
+	return e.Code >= 400 && e.Code < 500
+}
+
+// wrapSMTPError returns err as an *SMTPError if it is an error reply of the
+// SMTP server and err unchanged otherwise.
+func wrapSMTPError(err error) error {
+	var tpErr *textproto.Error
+	if _, ok := err.(*SMTPError); ok || !errors.As(err, &tpErr) {
+		return err
+	}
+
+	e := &SMTPError{Code: tpErr.Code, err: tpErr}
+	lines := strings.Split(tpErr.Msg, "\n")
+	if code, _, _ := strings.Cut(lines[0], " "); isEnhancedCode(code, tpErr.Code) {
+		e.Enhanced = code
+		for i, line := range lines {
+			if line == code || strings.HasPrefix(line, code+" ") {
+				lines[i] = strings.TrimPrefix(line[len(code):], " ")
+			}
+		}
+	}
+	e.Message = strings.Join(lines, "\n")
+
+	return e
+}
+
+// isEnhancedCode reports whether s is an enhanced status code, as defined in
+// RFC 3463, whose class matches the reply code.
+func isEnhancedCode(s string, code int) bool {
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 || parts[0] != strconv.Itoa(code/100) {
+		return false
+	}
+	for _, p := range parts[1:] {
+		if len(p) == 0 || len(p) > 3 {
+			return false
+		}
+		for i := 0; i < len(p); i++ {
+			if p[i] < '0' || p[i] > '9' {
+				return false
+			}
+		}
+	}
+
+	return true
+}
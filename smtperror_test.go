@@ -0,0 +1,57 @@
+package gomail
+
+import (
+	"errors"
+	"io"
+	"net/textproto"
+	"testing"
+)
+
+func TestWrapSMTPError(t *testing.T) {
+	tests := []struct {
+		code              int
+		msg               string
+		enhanced, message string
+		temporary         bool
+	}{
+		{550, "5.1.1 No such user", "5.1.1", "No such user", false},
+		{452, "4.5.3 Too many recipients", "4.5.3", "Too many recipients", true},
+		{421, "Service not available", "", "Service not available", true},
+		{554, "5.7.1 Message rejected\n5.7.1 See the policy", "5.7.1", "Message rejected\nSee the policy", false},
+		{550, "4.1.1 Mismatched class", "", "4.1.1 Mismatched class", false},
+		{550, "5.1234.1 Invalid subject", "", "5.1234.1 Invalid subject", false},
+		{451, "4.3.0", "4.3.0", "", true},
+	}
+
+	for _, test := range tests {
+		tpErr := &textproto.Error{Code: test.code, Msg: test.msg}
+		err := wrapSMTPError(tpErr)
+		e, ok := err.(*SMTPError)
+		if !ok {
+			t.Fatalf("Invalid error type for %q, got %T", test.msg, err)
+		}
+		if e.Code != test.code || e.Enhanced != test.enhanced || e.Message != test.message {
+			t.Errorf("Invalid error for %d %q, got %d %q %q, want %q %q",
+				test.code, test.msg, e.Code, e.Enhanced, e.Message, test.enhanced, test.message)
+		}
+		if e.Temporary() != test.temporary {
+			t.Errorf("Temporary() for %d should be %v", test.code, test.temporary)
+		}
+		if e.Error() != tpErr.Error() {
+			t.Errorf("Invalid error message, got %q, want %q", e.Error(), tpErr.Error())
+		}
+		if !errors.Is(err, tpErr) {
+			t.Errorf("The *textproto.Error should be wrapped")
+		}
+		if wrapSMTPError(err) != err {
+			t.Errorf("An *SMTPError should not be wrapped again")
+		}
+	}
+
+	if err := wrapSMTPError(io.EOF); err != io.EOF {
+		t.Errorf("Other errors should be returned unchanged, got %v", err)
+	}
+	if err := wrapSMTPError(nil); err != nil {
+		t.Errorf("A nil error should stay nil, got %v", err)
+	}
+}
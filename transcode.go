@@ -0,0 +1,193 @@
+package gomail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+	"unicode/utf8"
+)
+
+// A CharsetEncoder converts UTF-8 text to another charset. The
+// *encoding.Encoder type of golang.org/x/text/encoding implements it, for
+// example japanese.ShiftJIS.NewEncoder().
+type CharsetEncoder interface {
+	// Writer returns a writer converting the UTF-8 text written to it and
+	// writing the result to w. The writer must return an error if a character
+	// cannot be represented in the charset. If it implements io.Closer, it is
+	// closed once the whole text is written.
+	Writer(w io.Writer) io.Writer
+}
+
+// SetTranscoding is a message setting to convert the text parts of the message
+// from UTF-8 to their charset when it is not UTF-8, so that their content
+// matches the charset they declare. US-ASCII and ISO-8859-1 are supported and
+// encoders for other charsets are added with SetCharsetEncoder. Exporting the
+// message fails if a part has no encoder for its charset or if one of its
+// characters cannot be represented in it.
+//
+// Header fields are then encoded in UTF-8 whatever the charset of the message.
+//
+// Example:
+//
+//	msg := gomail.NewMessage(gomail.SetCharset("ISO-8859-1"), gomail.SetTranscoding(true))
+func SetTranscoding(enable bool) MessageSetting {
+	return func(msg *Message) {
+		msg.transcode = enable
+	}
+}
+
+// SetCharsetEncoder is a message setting to convert the text parts using the
+// given charset with enc. It enables transcoding, see SetTranscoding.
+//
+// Example:
+//
+//	msg := gomail.NewMessage(
+//		gomail.SetCharset("Shift_JIS"),
+//		gomail.SetCharsetEncoder("Shift_JIS", japanese.ShiftJIS.NewEncoder()),
+//	)
+func SetCharsetEncoder(charset string, enc CharsetEncoder) MessageSetting {
+	return func(msg *Message) {
+		if msg.charsetEncoders == nil {
+			msg.charsetEncoders = make(map[string]CharsetEncoder)
+		}
+		msg.charsetEncoders[charsetKey(charset)] = enc
+		msg.transcode = true
+	}
+}
+
+// charsetKey returns the key of charset in the charset encoders of a message.
+func charsetKey(charset string) string {
+	if name, ok := normalizeCharset(charset); ok {
+		charset = name
+	}
+
+	return strings.ToLower(charset)
+}
+
+var (
+	asciiEncoder  = runeEncoder{charset: "US-ASCII", max: 0x80}
+	latin1Encoder = runeEncoder{charset: "ISO-8859-1", max: 0x100}
+)
+
+// charsetEncoder returns the encoder of the given charset, or nil if the
+// charset is UTF-8.
+func (msg *Message) charsetEncoder(charset string) (CharsetEncoder, error) {
+	key := charsetKey(charset)
+	if enc, ok := msg.charsetEncoders[key]; ok {
+		return enc, nil
+	}
+	switch key {
+	case "utf-8":
+		return nil, nil
+	case "us-ascii":
+		return asciiEncoder, nil
+	case "iso-8859-1":
+		return latin1Encoder, nil
+	}
+
+	return nil, fmt.Errorf("gomail: no encoder for charset %q", charset)
+}
+
+// partCharset returns the charset of p.
+func (msg *Message) partCharset(p part) string {
+	if _, params, err := mime.ParseMediaType(p.contentType); err == nil {
+		if charset, ok := params["charset"]; ok {
+			return charset
+		}
+	}
+	if p.charset != "" {
+		return p.charset
+	}
+
+	return msg.charset
+}
+
+// transcodeBytes converts the UTF-8 text b with enc.
+func transcodeBytes(enc CharsetEncoder, b []byte) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := transcodeFunc(enc, func(w io.Writer) error {
+		_, err := w.Write(b)
+		return err
+	})(buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// transcodeFunc returns a function converting the UTF-8 text written by f with
+// enc.
+func transcodeFunc(enc CharsetEncoder, f func(io.Writer) error) func(io.Writer) error {
+	return func(w io.Writer) error {
+		tw := enc.Writer(w)
+		if err := f(tw); err != nil {
+			return err
+		}
+		if c, ok := tw.(io.Closer); ok {
+			return c.Close()
+		}
+		return nil
+	}
+}
+
+var errInvalidUTF8 = errors.New("gomail: invalid UTF-8 text")
+
+// runeEncoder encodes the runes lower than max as a single byte, as do
+// US-ASCII and ISO-8859-1.
+type runeEncoder struct {
+	charset string
+	max     rune
+}
+
+func (e runeEncoder) Writer(w io.Writer) io.Writer {
+	return &runeWriter{w: w, enc: e}
+}
+
+type runeWriter struct {
+	w   io.Writer
+	enc runeEncoder
+	// partial holds the first bytes of a rune split between two writes.
+	partial []byte
+}
+
+func (rw *runeWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if len(rw.partial) > 0 {
+		p = append(rw.partial, p...)
+		rw.partial = nil
+	}
+
+	out := make([]byte, 0, len(p))
+	for len(p) > 0 {
+		r, size := utf8.DecodeRune(p)
+		if r == utf8.RuneError && size <= 1 {
+			if !utf8.FullRune(p) {
+				rw.partial = append([]byte(nil), p...)
+				break
+			}
+			return 0, errInvalidUTF8
+		}
+		if r >= rw.enc.max {
+			return 0, fmt.Errorf("gomail: %q cannot be represented in %s", r, rw.enc.charset)
+		}
+		out = append(out, byte(r))
+		p = p[size:]
+	}
+	if _, err := rw.w.Write(out); err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// Close returns an error if the text ended in the middle of a rune.
+func (rw *runeWriter) Close() error {
+	if len(rw.partial) > 0 {
+		return errInvalidUTF8
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package gomail
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestTranscodeLatin1(t *testing.T) {
+	msg := NewMessage(SetCharset("ISO-8859-1"), SetTranscoding(true))
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("To", "to@example.com")
+	msg.SetHeader("Subject", "Noël")
+	msg.SetBody("text/plain", "Café à Noël")
+	msg.AddAlternativeWriter("text/html", func(w io.Writer) error {
+		// The "é" is split between two writes.
+		io.WriteString(w, "<p>Caf\xc3")
+		_, err := io.WriteString(w, "\xa9</p>")
+		return err
+	})
+
+	want := message{
+		from: "from@example.com",
+		to:   []string{"to@example.com"},
+		content: "From: from@example.com\r\n" +
+			"To: to@example.com\r\n" +
+			"Subject: =?UTF-8?Q?No=C3=ABl?=\r\n" +
+			"Content-Type: multipart/alternative; boundary=_BOUNDARY_1_\r\n" +
+			"\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			"Content-Type: text/plain; charset=ISO-8859-1\r\n" +
+			"Content-Transfer-Encoding: quoted-printable\r\n" +
+			"Mime-Version: 1.0\r\n" +
+			"\r\n" +
+			"Caf=E9 =E0 No=EBl\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			"Content-Type: text/html; charset=ISO-8859-1\r\n" +
+			"Content-Transfer-Encoding: quoted-printable\r\n" +
+			"Mime-Version: 1.0\r\n" +
+			"\r\n" +
+			"<p>Caf=E9</p>\r\n" +
+			"--_BOUNDARY_1_--\r\n",
+	}
+
+	testMessage(t, msg, 1, want)
+}
+
+func TestTranscodeErrors(t *testing.T) {
+	tests := []struct {
+		charset, body, err string
+	}{
+		{"ISO-8859-1", "10 €", `gomail: '€' cannot be represented in ISO-8859-1`},
+		{"US-ASCII", "Café", `gomail: 'é' cannot be represented in US-ASCII`},
+		{"ISO-8859-1", "Caf\xc3", "gomail: invalid UTF-8 text"},
+		{"Shift_JIS", "こんにちは", `gomail: no encoder for charset "Shift_JIS"`},
+	}
+	for _, test := range tests {
+		msg := NewMessage(SetCharset(test.charset), SetTranscoding(true))
+		msg.SetHeader("From", "from@example.com")
+		msg.SetBody("text/plain", test.body)
+		if _, err := msg.WriteTo(new(bytes.Buffer)); err == nil || err.Error() != test.err {
+			t.Errorf("Invalid error for %q in %s, got %v, want %q", test.body, test.charset, err, test.err)
+		}
+	}
+}
+
+type upperEncoder struct{}
+
+func (upperEncoder) Writer(w io.Writer) io.Writer {
+	return upperWriter{w}
+}
+
+type upperWriter struct {
+	w io.Writer
+}
+
+func (w upperWriter) Write(p []byte) (int, error) {
+	return w.w.Write(bytes.ToUpper(p))
+}
+
+func TestCharsetEncoder(t *testing.T) {
+	msg := NewMessage(SetCharset("x-upper"), SetCharsetEncoder("X-Upper", upperEncoder{}))
+	msg.SetHeader("From", "from@example.com")
+	msg.SetBody("text/plain", "hello")
+	msg.Attach(CreateFile("test.txt", []byte("attachment")))
+
+	buf := new(bytes.Buffer)
+	if _, err := msg.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); !strings.Contains(got, "\r\n\r\nHELLO\r\n") {
+		t.Errorf("Body should be transcoded:\n%s", got)
+	}
+}
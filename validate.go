@@ -0,0 +1,189 @@
+package gomail
+
+import (
+	"bytes"
+	"net/mail"
+	"strconv"
+	"strings"
+)
+
+// A ValidationError is returned by Message.Validate when the message is not
+// valid.
+type ValidationError struct {
+	// Field is the header field that is missing or invalid.
+	Field string
+	// Reason describes the problem.
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return "gomail: invalid message, " + e.Field + " " + e.Reason
+}
+
+// SetStrictValidation is a message setting to make Message.Validate also
+// require a non-empty Subject.
+//
+// Example:
+//
+//	msg := gomail.NewMessage(SetStrictValidation(true))
+func SetStrictValidation(strict bool) MessageSetting {
+	return func(msg *Message) {
+		msg.strict = strict
+	}
+}
+
+// ValidationErrors is returned by Message.Validate when the message has several
+// problems.
+type ValidationErrors struct {
+	Errors []*ValidationError
+}
+
+func (e *ValidationErrors) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Field + " " + err.Reason
+	}
+
+	return "gomail: invalid message, " + strings.Join(msgs, "; ")
+}
+
+func (e *ValidationErrors) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, err := range e.Errors {
+		errs[i] = err
+	}
+
+	return errs
+}
+
+// SetExportValidation is a message setting to validate the message with
+// Message.Validate each time it is exported, written or sent. The error of
+// Validate is then returned instead of writing an invalid message.
+//
+// Example:
+//
+//	msg := gomail.NewMessage(SetExportValidation(true))
+func SetExportValidation(validate bool) MessageSetting {
+	return func(msg *Message) {
+		msg.validate = validate
+	}
+}
+
+// Validate checks, without encoding the message, that:
+//   - it has a valid From field and at least one valid recipient,
+//   - it has a Sender field if From has several addresses,
+//   - its parts have a content type and a valid charset,
+//   - its 7bit parts only contain 7-bit characters,
+//   - its attachments and embedded files have a name.
+//
+// It returns a *ValidationError if a single problem is found or a
+// *ValidationErrors describing every problem found.
+func (msg *Message) Validate() error {
+	var errs []*ValidationError
+	add := func(err error) {
+		if err != nil {
+			errs = append(errs, err.(*ValidationError))
+		}
+	}
+
+	from, ok := msg.header["From"]
+	if !ok || len(from) == 0 {
+		add(&ValidationError{Field: "From", Reason: "is absent"})
+	}
+	add(validateAddresses("From", from))
+	// RFC 5322, 3.6.2.
+	if len(from) > 1 {
+		sender, ok := msg.header["Sender"]
+		if !ok || len(sender) == 0 {
+			add(&ValidationError{Field: "Sender", Reason: "is absent, it is required when From has several addresses"})
+		} else if len(sender) > 1 {
+			add(&ValidationError{Field: "Sender", Reason: "must contain a single address"})
+		} else {
+			add(validateAddresses("Sender", sender))
+		}
+	}
+
+	hasRecipient := false
+	for _, field := range []string{"To", "Cc", "Bcc"} {
+		addresses := msg.header[field]
+		add(validateAddresses(field, addresses))
+		if len(addresses) > 0 {
+			hasRecipient = true
+		}
+	}
+	if !hasRecipient {
+		add(&ValidationError{Field: "To", Reason: "is absent, the message has no recipient"})
+	}
+
+	if msg.strict {
+		if subject := msg.header["Subject"]; len(subject) == 0 || strings.TrimSpace(strings.Join(subject, "")) == "" {
+			add(&ValidationError{Field: "Subject", Reason: "is empty"})
+		}
+	}
+
+	for i, p := range append(msg.parts[:len(msg.parts):len(msg.parts)], msg.mixedParts...) {
+		add(msg.validatePart(i, p))
+	}
+	for _, files := range []struct {
+		kind  string
+		files []*File
+	}{{"attachment", msg.attachments}, {"embedded file", msg.embedded}} {
+		for i, f := range files.files {
+			if strings.TrimSpace(f.Name) == "" {
+				add(&ValidationError{
+					Field:  "Content-Disposition",
+					Reason: "of " + files.kind + " #" + strconv.Itoa(i) + " has no file name",
+				})
+			}
+		}
+	}
+
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+
+	return &ValidationErrors{Errors: errs}
+}
+
+// validatePart checks the content type, the charset and the encoding of the
+// part number i.
+func (msg *Message) validatePart(i int, p part) error {
+	part := "of part #" + strconv.Itoa(i)
+	if strings.TrimSpace(p.contentType) == "" {
+		return &ValidationError{Field: "Content-Type", Reason: part + " is empty"}
+	}
+	if isText(p.contentType) {
+		if charset := msg.partCharset(p); charset == "" {
+			return &ValidationError{Field: "Content-Type", Reason: part + " has an empty charset"}
+		} else if _, ok := normalizeCharset(charset); !ok {
+			return &ValidationError{Field: "Content-Type", Reason: part + " has an invalid charset " + charset}
+		}
+	}
+
+	enc := msg.encoding
+	if p.encoding != "" {
+		enc = p.encoding
+	}
+	if enc == SevenBit && p.write == nil && bytes.IndexFunc(p.body.Bytes(), isNotASCII) != -1 {
+		return &ValidationError{Field: "Content-Transfer-Encoding", Reason: part + " is 7bit but the body contains 8-bit characters"}
+	}
+
+	return nil
+}
+
+func validateAddresses(field string, addresses []string) error {
+	for _, addr := range addresses {
+		if _, err := mail.ParseAddress(addr); err != nil {
+			return invalidAddress(field, addr, err)
+		}
+	}
+
+	return nil
+}
+
+func invalidAddress(field, addr string, err error) *ValidationError {
+	return &ValidationError{Field: field, Reason: "contains an invalid address " + addr + ": " + err.Error()}
+}
@@ -0,0 +1,154 @@
+package gomail
+
+import (
+	"bytes"
+	"errors"
+	"net/smtp"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		header map[string][]string
+		strict bool
+		field  string
+	}{
+		{
+			header: map[string][]string{"From": {"from@example.com"}, "To": {"to@example.com"}},
+		},
+		{
+			header: map[string][]string{"From": {"from@example.com"}, "Bcc": {"bcc@example.com"}},
+		},
+		{
+			header: map[string][]string{"To": {"to@example.com"}},
+			field:  "From",
+		},
+		{
+			header: map[string][]string{"From": {"from"}, "To": {"to@example.com"}},
+			field:  "From",
+		},
+		{
+			header: map[string][]string{"From": {"from@example.com"}},
+			field:  "To",
+		},
+		{
+			header: map[string][]string{"From": {"from@example.com"}, "To": {"to@example.com"}, "Cc": {"cc@"}},
+			field:  "Cc",
+		},
+		{
+			header: map[string][]string{"From": {"a@example.com", "b@example.com"}, "To": {"to@example.com"}},
+			field:  "Sender",
+		},
+		{
+			header: map[string][]string{"From": {"a@example.com", "b@example.com"}, "Sender": {"a@"}, "To": {"to@example.com"}},
+			field:  "Sender",
+		},
+		{
+			header: map[string][]string{"From": {"a@example.com", "b@example.com"}, "Sender": {"a@example.com"}, "To": {"to@example.com"}},
+		},
+		{
+			header: map[string][]string{"From": {"from@example.com"}, "To": {"to@example.com"}},
+			strict: true,
+			field:  "Subject",
+		},
+		{
+			header: map[string][]string{"From": {"from@example.com"}, "To": {"to@example.com"}, "Subject": {"Hello"}},
+			strict: true,
+		},
+	}
+
+	for i, test := range tests {
+		msg := NewMessage(SetStrictValidation(test.strict))
+		msg.SetHeaders(test.header)
+		err := msg.Validate()
+		if test.field == "" {
+			if err != nil {
+				t.Errorf("#%d: Validate returned an error: %v", i, err)
+			}
+			continue
+		}
+
+		vErr, ok := err.(*ValidationError)
+		if !ok {
+			t.Errorf("#%d: Invalid error, got %#v, want a *ValidationError", i, err)
+			continue
+		}
+		if vErr.Field != test.field {
+			t.Errorf("#%d: Invalid field, got %q, want %q", i, vErr.Field, test.field)
+		}
+	}
+}
+
+func TestMessageValidation(t *testing.T) {
+	sendMail := func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		t.Fatal("Invalid messages should not be sent")
+		return nil
+	}
+
+	msg := NewMessage()
+	msg.SetHeader("To", "to@example.com")
+	msg.SetBody("text/plain", "Test")
+
+	mailer := NewMailer("host", "username", "password", 587, SetSendMail(sendMail), SetMessageValidation(true))
+	if _, ok := mailer.Send(msg).(*ValidationError); !ok {
+		t.Error("Send should return a *ValidationError")
+	}
+}
+
+func TestValidateAll(t *testing.T) {
+	msg := NewMessage(SetEncoding(SevenBit))
+	msg.SetHeader("Cc", "cc@")
+	msg.SetBody("text/plain", "Café")
+	msg.AddAlternative("", "Test")
+	msg.AddAlternative("text/html; charset=\"utf 8\"", "<p>Test</p>")
+	msg.Attach(CreateFile("", []byte("Content")))
+	msg.Embed(CreateFile("image.jpg", []byte("Content")))
+
+	err := msg.Validate()
+	vErrs, ok := err.(*ValidationErrors)
+	if !ok {
+		t.Fatalf("Invalid error, got %#v, want a *ValidationErrors", err)
+	}
+	want := []string{
+		"From is absent",
+		"Cc contains an invalid address cc@",
+		"Content-Transfer-Encoding of part #0 is 7bit but the body contains 8-bit characters",
+		"Content-Type of part #1 is empty",
+		"Content-Type of part #2 has an invalid charset utf 8",
+		"Content-Disposition of attachment #0 has no file name",
+	}
+	if len(vErrs.Errors) != len(want) {
+		t.Fatalf("Invalid number of errors, got %d: %v, want %d", len(vErrs.Errors), err, len(want))
+	}
+	for i, e := range vErrs.Errors {
+		if got := e.Field + " " + e.Reason; !strings.HasPrefix(got, want[i]) {
+			t.Errorf("#%d: Invalid error, got %q, want %q", i, got, want[i])
+		}
+	}
+	if !strings.HasPrefix(err.Error(), "gomail: invalid message, From is absent; Cc contains") {
+		t.Errorf("Invalid error message: %v", err)
+	}
+	var vErr *ValidationError
+	if !errors.As(err, &vErr) || vErr.Field != "From" {
+		t.Errorf("errors.As should find the first *ValidationError, got %v", vErr)
+	}
+}
+
+func TestExportValidation(t *testing.T) {
+	msg := NewMessage(SetExportValidation(true))
+	msg.SetHeader("To", "to@example.com")
+	msg.SetBody("text/plain", "Test")
+
+	if m, err := msg.ExportWithError(); m != nil || err == nil {
+		t.Errorf("ExportWithError should fail, got %v, %v", m, err)
+	}
+	if _, err := msg.WriteTo(new(bytes.Buffer)); err == nil {
+		t.Error("WriteTo should fail")
+	}
+
+	msg.SetHeader("From", "from@example.com")
+	if _, err := msg.WriteTo(new(bytes.Buffer)); err != nil {
+		t.Errorf("WriteTo should succeed, got %v", err)
+	}
+}